@@ -0,0 +1,104 @@
+package cache
+
+// AdmissionPolicy decides whether a loaded entry is worth the entries it evicts.
+type AdmissionPolicy interface {
+	newAdmitter(capacity int64) admitter
+}
+
+// admitter is not thread safe, the owner cache holds the lock.
+type admitter interface {
+	// record counts an access of the key hashed to h.
+	record(h uint64)
+	// admit tells whether the candidate is admitted at the cost of evicting the victims.
+	admit(candidate uint64, victims []uint64) bool
+}
+
+type tinyLFU struct{}
+
+// TinyLFU admits a new entry only if it is accessed more frequently than every entry it evicts.
+//
+//	Frequencies are estimated by a count-min sketch of 4-bit counters, sized to the capacity.
+//	The counters are halved once the samples reach 10 times the width, so that they track the recent popularity.
+func TinyLFU() AdmissionPolicy {
+	return tinyLFU{}
+}
+
+func (tinyLFU) newAdmitter(capacity int64) admitter {
+	return newCountMinSketch(capacity)
+}
+
+const (
+	sketchDepth    = 4
+	sketchMaxWidth = 1 << 20
+	sketchMaxCount = 15
+)
+
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+type countMinSketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	samples int
+	resetAt int
+}
+
+func newCountMinSketch(capacity int64) *countMinSketch {
+	width := 64
+	for int64(width) < capacity && width < sketchMaxWidth {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:    uint64(width - 1),
+		resetAt: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	return mix(h^sketchSeeds[row]) & s.mask
+}
+
+func (s *countMinSketch) record(h uint64) {
+	for i := range s.rows {
+		if idx := s.index(h, i); s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+	s.samples++
+	if s.samples >= s.resetAt {
+		s.reset()
+	}
+}
+
+// reset halves all counters, so that the history fades.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.samples /= 2
+}
+
+func (s *countMinSketch) estimate(h uint64) uint8 {
+	count := uint8(sketchMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < count {
+			count = c
+		}
+	}
+	return count
+}
+
+func (s *countMinSketch) admit(candidate uint64, victims []uint64) bool {
+	freq := s.estimate(candidate)
+	for _, victim := range victims {
+		if s.estimate(victim) >= freq {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	t.Run("test estimate", func(t *testing.T) {
+		s := newCountMinSketch(100)
+		assert.Len(t, s.rows[0], 128)
+		for i := 0; i < 5; i++ {
+			s.record(1)
+		}
+		s.record(2)
+		assert.EqualValues(t, 5, s.estimate(1))
+		assert.EqualValues(t, 1, s.estimate(2))
+		assert.EqualValues(t, 0, s.estimate(3))
+
+		// saturated
+		for i := 0; i < 100; i++ {
+			s.record(1)
+		}
+		assert.EqualValues(t, sketchMaxCount, s.estimate(1))
+
+		assert.True(t, s.admit(1, []uint64{2, 3}))
+		assert.False(t, s.admit(2, []uint64{3, 1}))
+		assert.False(t, s.admit(3, []uint64{3}))
+	})
+
+	t.Run("test reset", func(t *testing.T) {
+		s := newCountMinSketch(64)
+		for i := 0; i < 8; i++ {
+			s.record(1)
+		}
+		for i := 8; i < s.resetAt-1; i++ {
+			s.record(2)
+		}
+		assert.EqualValues(t, 8, s.estimate(1))
+		assert.EqualValues(t, sketchMaxCount, s.estimate(2))
+		s.record(2)
+		assert.EqualValues(t, 4, s.estimate(1))
+		assert.EqualValues(t, sketchMaxCount/2, s.estimate(2))
+		assert.Equal(t, s.resetAt/2, s.samples)
+	})
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	loadCnt := 0
+	causes := make(map[RemovalCause]int)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		loadCnt++
+		return key, true
+	}).WithEvictionListener(func(key, value int, cause RemovalCause) {
+		causes[cause]++
+	}).WithCapacity(10).WithAdmissionPolicy(TinyLFU()).Build()
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+	}
+	assert.Equal(t, 10, loadCnt)
+
+	// one-hit wonders of a scan are served but not admitted
+	for i := 100; i < 200; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error {
+			assert.Equal(t, i, v)
+			return nil
+		}))
+	}
+	for i := 0; i < 10; i++ {
+		_, ok := cache.GetIfPresent(i)
+		assert.True(t, ok)
+	}
+	assert.Equal(t, map[RemovalCause]int{Evicted: 100}, causes)
+	assert.EqualValues(t, 0, cache.Stats().Evictions)
+
+	// put is always admitted
+	assert.NoError(t, cache.Put(1000, 1000))
+	_, ok := cache.GetIfPresent(1000)
+	assert.True(t, ok)
+
+	// a key accessed frequently enough gets in
+	for i := 0; i < 14; i++ {
+		assert.NoError(t, cache.Do(2000, func(v int) error { return nil }))
+	}
+	_, ok = cache.GetIfPresent(2000)
+	assert.True(t, ok)
+}
+
+func TestTinyLFURecordOnce(t *testing.T) {
+	for name, builder := range map[string]*CacheBuilder[int, int]{
+		"loader": NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}),
+		"batch loader": NewCacheBuilder[int, int]().WithBatchLoader(func(keys []int) (map[int]int, error) {
+			values := make(map[int]int)
+			for _, key := range keys {
+				values[key] = key
+			}
+			return values, nil
+		}),
+	} {
+		t.Run("test do many with "+name, func(t *testing.T) {
+			cache := builder.WithAdmissionPolicy(TinyLFU()).Build().(*lruCache[int, int])
+			sketch := cache.admitter.(*countMinSketch)
+			assert.NoError(t, cache.DoMany([]int{1, 2}, func(values map[int]int) error { return nil }))
+			assert.NoError(t, cache.DoMany([]int{1}, func(values map[int]int) error { return nil }))
+			assert.EqualValues(t, 2, sketch.estimate(hashKey(cache.seed, 1)))
+			assert.EqualValues(t, 1, sketch.estimate(hashKey(cache.seed, 2)))
+		})
+	}
+}
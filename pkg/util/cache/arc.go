@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+type arcPolicy struct{}
+
+// ARC adapts between recency and frequency, as the Adaptive Replacement Cache of Megiddo and Modha.
+//
+//	Entries hit once are kept in T1 and the ones hit again in T2, both in LRU order. The keys evicted from them are
+//	remembered in the ghost lists B1 and B2, which hold only a hash and the cost of the key, up to the capacity in
+//	cost each. Reloading a key remembered in B1 grows the target cost p of T1, one remembered in B2 shrinks it,
+//	and entries are evicted from T1 while it exceeds p, from T2 otherwise.
+//	Only the entries evicted for room become ghosts, the removed, replaced and expired ones do not.
+func ARC() Policy {
+	return arcPolicy{}
+}
+
+func (arcPolicy) newEvictor(capacity int64) evictor {
+	return &arcEvictor{
+		capacity: capacity,
+		t1:       list.New(),
+		t2:       list.New(),
+		b1:       newGhostList(),
+		b2:       newGhostList(),
+	}
+}
+
+type arcEvictor struct {
+	capacity int64
+	// p is the target cost of t1, t1Cost and t2Cost are the current costs of t1 and t2
+	p      int64
+	t1Cost int64
+	t2Cost int64
+	// the frequent entries are in t2, marked by policyNode.protected
+	t1 *list.List
+	t2 *list.List
+	b1 *ghostList
+	b2 *ghostList
+}
+
+func (e *arcEvictor) keyed() {}
+
+func (e *arcEvictor) add(n *policyNode) {
+	switch {
+	case e.b1.contains(n.hash):
+		e.p += adaptStep(n.cost, e.b1.cost, e.b2.cost)
+		if e.p > e.capacity {
+			e.p = e.capacity
+		}
+		e.b1.remove(n.hash)
+		e.pushFrequent(n)
+	case e.b2.contains(n.hash):
+		e.p -= adaptStep(n.cost, e.b2.cost, e.b1.cost)
+		if e.p < 0 {
+			e.p = 0
+		}
+		e.b2.remove(n.hash)
+		e.pushFrequent(n)
+	default:
+		n.protected = false
+		n.elem = e.t1.PushFront(n)
+		e.t1Cost += n.cost
+	}
+	e.trimGhosts()
+}
+
+// adaptStep is how much p moves on a hit of a ghost of cost in a list of cost hit, against the other list of cost other.
+func adaptStep(cost, hit, other int64) int64 {
+	if other <= hit || hit == 0 {
+		return cost
+	}
+	return cost * other / hit
+}
+
+func (e *arcEvictor) pushFrequent(n *policyNode) {
+	n.protected = true
+	n.elem = e.t2.PushFront(n)
+	e.t2Cost += n.cost
+}
+
+func (e *arcEvictor) access(n *policyNode) {
+	if n.protected {
+		e.t2.MoveToFront(n.elem)
+		return
+	}
+	e.t1.Remove(n.elem)
+	e.t1Cost -= n.cost
+	e.pushFrequent(n)
+}
+
+func (e *arcEvictor) remove(n *policyNode) {
+	if n.protected {
+		e.t2.Remove(n.elem)
+		e.t2Cost -= n.cost
+		if n.evicted {
+			e.b2.push(n.hash, n.cost)
+		}
+	} else {
+		e.t1.Remove(n.elem)
+		e.t1Cost -= n.cost
+		if n.evicted {
+			e.b1.push(n.hash, n.cost)
+		}
+	}
+	n.elem = nil
+	e.trimGhosts()
+}
+
+// trimGhosts bounds t1 and b1 to the capacity, and all the lists to twice the capacity.
+func (e *arcEvictor) trimGhosts() {
+	for e.t1Cost+e.b1.cost > e.capacity && e.b1.len() > 0 {
+		e.b1.removeOldest()
+	}
+	for e.t1Cost+e.t2Cost+e.b1.cost+e.b2.cost > 2*e.capacity && e.b2.len() > 0 {
+		e.b2.removeOldest()
+	}
+}
+
+func (e *arcEvictor) resize(capacity int64) {
+	e.capacity = capacity
+	if e.p > capacity {
+		e.p = capacity
+	}
+	e.trimGhosts()
+}
+
+func (e *arcEvictor) describe() string {
+	return fmt.Sprintf("arc p=%d t1=%d cost=%d t2=%d cost=%d b1=%d cost=%d b2=%d cost=%d",
+		e.p, e.t1.Len(), e.t1Cost, e.t2.Len(), e.t2Cost, e.b1.len(), e.b1.cost, e.b2.len(), e.b2.cost)
+}
+
+func (e *arcEvictor) victims(f func(n *policyNode) bool) {
+	lists := []*list.List{e.t2, e.t1}
+	if e.t1Cost > e.p || e.t2.Len() == 0 {
+		lists = []*list.List{e.t1, e.t2}
+	}
+	for _, l := range lists {
+		for p := l.Back(); p != nil; p = p.Prev() {
+			if !f(p.Value.(*policyNode)) {
+				return
+			}
+		}
+	}
+}
+
+// ghostList remembers evicted keys by hash in LRU order, along with their costs.
+type ghostList struct {
+	order *list.List
+	elems map[uint64]*list.Element
+	cost  int64
+}
+
+type ghost struct {
+	hash uint64
+	cost int64
+}
+
+func newGhostList() *ghostList {
+	return &ghostList{order: list.New(), elems: make(map[uint64]*list.Element)}
+}
+
+func (l *ghostList) len() int {
+	return l.order.Len()
+}
+
+func (l *ghostList) contains(hash uint64) bool {
+	_, ok := l.elems[hash]
+	return ok
+}
+
+func (l *ghostList) push(hash uint64, cost int64) {
+	l.remove(hash)
+	l.elems[hash] = l.order.PushFront(ghost{hash: hash, cost: cost})
+	l.cost += cost
+}
+
+func (l *ghostList) remove(hash uint64) {
+	if elem, ok := l.elems[hash]; ok {
+		l.drop(elem)
+	}
+}
+
+func (l *ghostList) removeOldest() {
+	l.drop(l.order.Back())
+}
+
+func (l *ghostList) drop(elem *list.Element) {
+	g := l.order.Remove(elem).(ghost)
+	delete(l.elems, g.hash)
+	l.cost -= g.cost
+}
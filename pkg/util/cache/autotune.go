@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// autoTuneInterval is how often the auto tuner adjusts the capacity.
+	autoTuneInterval = 10 * time.Second
+	// autoTuneStep is the fraction of the capacity grown or shrunk at a time.
+	autoTuneStep = 0.1
+	// autoTuneSlack is how far the hit ratio could go beyond the target before the capacity shrinks.
+	autoTuneSlack = 0.05
+)
+
+// autoTuneConfig is set by WithAutoTune.
+type autoTuneConfig struct {
+	min, max int64
+	target   float64
+}
+
+// autoTuner adjusts the capacity of a cache to keep its hit ratio near the target, within [min, max].
+type autoTuner[K comparable, V any] struct {
+	autoTuneConfig
+	cache Cache[K, V]
+	// pressure returns the memory pressure, the capacity shrinks beyond softPressure
+	pressure func() float64
+	// lookups is the number of lookups seen by the last round
+	lookups int64
+}
+
+// startAutoTune clamps the capacity of c into the bounds of WithAutoTune and tunes it in background until done is closed.
+func (b *CacheBuilder[K, V]) startAutoTune(c Cache[K, V], done <-chan struct{}) {
+	if b.autoTune == nil {
+		return
+	}
+	t := &autoTuner[K, V]{autoTuneConfig: *b.autoTune, cache: c, pressure: memoryPressure}
+	if capacity := t.clamp(c.Capacity()); capacity != c.Capacity() {
+		c.Resize(capacity)
+	}
+	go t.run(autoTuneInterval, done)
+}
+
+func (t *autoTuner[K, V]) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.tune()
+		case <-done:
+			return
+		}
+	}
+}
+
+// tune makes a round of tuning, it shrinks the capacity if the memory is tight or the hit ratio is well above the target,
+// and grows it if the hit ratio is below the target. The capacity is left as is if there is no lookup since the last round.
+func (t *autoTuner[K, V]) tune() {
+	stats := t.cache.Stats()
+	lookups := stats.Hits + stats.Misses
+	idle := lookups == t.lookups
+	t.lookups = lookups
+
+	current := t.cache.Capacity()
+	step := int64(math.Ceil(float64(current) * autoTuneStep))
+	capacity := current
+	ratio := t.cache.HitRatio()
+	switch {
+	case t.pressure() >= softPressure:
+		capacity -= step
+	case idle:
+	case ratio < t.target:
+		capacity += step
+	case ratio > t.target+autoTuneSlack:
+		capacity -= step
+	}
+	if capacity = t.clamp(capacity); capacity != current {
+		t.cache.Resize(capacity)
+	}
+}
+
+func (t *autoTuner[K, V]) clamp(capacity int64) int64 {
+	if capacity < t.min {
+		return t.min
+	}
+	if capacity > t.max {
+		return t.max
+	}
+	return capacity
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoTune(t *testing.T) {
+	t.Run("test clamp", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithCapacity(100).WithAutoTune(5, 20, 0.9).Build()
+		defer cache.Close()
+		assert.EqualValues(t, 20, cache.Capacity())
+		cache = NewCacheBuilder[int, int]().WithCapacity(1).WithAutoTune(5, 20, 0.9).WithShards(2).Build()
+		defer cache.Close()
+		assert.EqualValues(t, 5, cache.Capacity())
+	})
+
+	t.Run("test tune", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(10).WithClock(newFakeClock()).Build()
+		pressure := 0.0
+		tuner := &autoTuner[int, int]{
+			autoTuneConfig: autoTuneConfig{min: 8, max: 12, target: 0.5},
+			cache:          cache,
+			pressure:       func() float64 { return pressure },
+		}
+		do := func(keys ...int) {
+			for _, key := range keys {
+				assert.NoError(t, cache.Do(key, func(v int) error { return nil }))
+			}
+		}
+
+		// all misses, grows up to max
+		do(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+		tuner.tune()
+		assert.EqualValues(t, 11, cache.Capacity())
+		do(10)
+		tuner.tune()
+		assert.EqualValues(t, 12, cache.Capacity())
+		do(11)
+		tuner.tune()
+		assert.EqualValues(t, 12, cache.Capacity())
+		assert.Equal(t, 12, cache.Len())
+
+		// no lookup, no change
+		tuner.tune()
+		assert.EqualValues(t, 12, cache.Capacity())
+
+		// well above the target, shrinks evicting entries
+		for i := 0; i < 5; i++ {
+			do(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+		}
+		tuner.tune()
+		assert.EqualValues(t, 10, cache.Capacity())
+		assert.Equal(t, 10, cache.Len())
+
+		// memory is tight, shrinks down to min regardless of the hit ratio
+		pressure = 0.95
+		tuner.tune()
+		tuner.tune()
+		assert.EqualValues(t, 8, cache.Capacity())
+		assert.Equal(t, 8, cache.Len())
+	})
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// circuitBreaker stops calling a failing loader for a cooldown, after threshold consecutive failures.
+//
+//	Once the cooldown elapses, a single probe is let through while others still fail fast,
+//	the breaker closes if the probe succeeds, or opens for another cooldown if not. Only the probe decides it,
+//	the late reports of calls allowed before the breaker opened are ignored until it closes.
+//	A nil circuitBreaker always lets the loader be called. It is thread safe, as it is shared by the shards.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	failures  int
+	openedAt  time.Time // zero if closed
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, clock Clock) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     clock,
+	}
+}
+
+// allow tells whether the loader could be called, and whether the call is the probe of the open breaker.
+// A call allowed must be followed by report with the probe returned.
+func (b *circuitBreaker) allow() (probe bool, ok bool) {
+	if b == nil {
+		return false, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return false, true
+	}
+	if b.probing || b.clock.Now().Sub(b.openedAt) < b.cooldown {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// report records the result of a loader call, ErrNoSuchItem and permanent errors are successes as the backend works.
+//
+//	Context errors tell nothing of the backend, they are neither successes nor failures,
+//	the probe ending with one lets the next call probe again.
+func (b *circuitBreaker) report(probe bool, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !probe && !b.openedAt.IsZero() {
+		// allowed before the breaker opened
+		return
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if probe {
+			b.probing = false
+		}
+		return
+	}
+	if err == nil || errors.Is(err, ErrNoSuchItem) || isPermanent(err) {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.probing = false
+		return
+	}
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.probing = false
+	}
+}
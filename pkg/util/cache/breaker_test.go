@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	failing := true
+	calls := 0
+	cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+		calls++
+		if failing {
+			return 0, errors.New("backend down")
+		}
+		return key, nil
+	}).WithLoaderCircuitBreaker(3, time.Minute).WithClock(clock).WithShards(4).Build()
+
+	// consecutive failures across shards open the breaker
+	for i := 0; i < 3; i++ {
+		assert.EqualError(t, cache.Do(i, func(v int) error { return nil }), "backend down")
+	}
+	for i := 0; i < 5; i++ {
+		assert.ErrorIs(t, cache.Do(i, func(v int) error { return nil }), ErrLoaderUnavailable)
+	}
+	assert.Equal(t, 3, calls)
+
+	// a failed probe opens it again
+	clock.Advance(time.Minute)
+	assert.EqualError(t, cache.Do(1, func(v int) error { return nil }), "backend down")
+	assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrLoaderUnavailable)
+	assert.Equal(t, 4, calls)
+
+	// a successful probe closes it
+	clock.Advance(time.Minute)
+	failing = false
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	assert.Equal(t, 9, calls)
+
+	allowed := func(b *circuitBreaker) bool {
+		_, ok := b.allow()
+		return ok
+	}
+
+	t.Run("test probe", func(t *testing.T) {
+		b := newCircuitBreaker(1, time.Minute, clock)
+		probe, ok := b.allow()
+		assert.True(t, ok)
+		assert.False(t, probe)
+		b.report(probe, errors.New("failure"))
+		assert.False(t, allowed(b))
+
+		clock.Advance(time.Minute)
+		probe, ok = b.allow()
+		assert.True(t, ok)
+		assert.True(t, probe)
+		// only one probe at a time
+		assert.False(t, allowed(b))
+		b.report(probe, ErrNoSuchItem)
+		assert.True(t, allowed(b))
+		assert.True(t, allowed(b))
+	})
+
+	t.Run("test context errors", func(t *testing.T) {
+		b := newCircuitBreaker(2, time.Minute, clock)
+		b.report(false, errors.New("failure"))
+		// neither a success nor a failure
+		b.report(false, context.Canceled)
+		b.report(false, errors.Wrap(context.DeadlineExceeded, "load"))
+		assert.True(t, allowed(b))
+		b.report(false, errors.New("failure"))
+		assert.False(t, allowed(b))
+
+		// a probe canceled lets the next call probe
+		clock.Advance(time.Minute)
+		probe, _ := b.allow()
+		b.report(probe, context.Canceled)
+		probe, ok := b.allow()
+		assert.True(t, ok)
+		assert.True(t, probe)
+		b.report(probe, nil)
+		assert.True(t, allowed(b))
+	})
+
+	t.Run("test late report", func(t *testing.T) {
+		b := newCircuitBreaker(1, time.Minute, clock)
+		// allowed before the breaker opens
+		late, _ := b.allow()
+		b.report(false, errors.New("failure"))
+
+		b.report(late, nil)
+		assert.False(t, allowed(b))
+		clock.Advance(time.Minute)
+		probe, ok := b.allow()
+		assert.True(t, ok)
+		assert.True(t, probe)
+		// the late success does not close the half open breaker, the probe does
+		b.report(late, nil)
+		assert.False(t, allowed(b))
+		b.report(probe, errors.New("failure"))
+		assert.False(t, allowed(b))
+		clock.Advance(time.Minute)
+		probe, _ = b.allow()
+		b.report(probe, nil)
+		assert.True(t, allowed(b))
+	})
+
+	t.Run("test nil", func(t *testing.T) {
+		b := newCircuitBreaker(0, time.Minute, clock)
+		assert.Nil(t, b)
+		b.report(false, errors.New("failure"))
+		assert.True(t, allowed(b))
+	})
+}
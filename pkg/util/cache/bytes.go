@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// byteUnits are the units accepted by ParseBytes, by their lower case.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// ParseBytes parses a byte size such as "512MiB", "1.5GB" or "4096" for WithByteCapacity.
+//
+//	The units are B, KB, MB, GB and TB by powers of 1000, KiB, MiB, GiB and TiB by powers of 1024, in any case.
+//	A fraction of a byte is rounded down.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, errors.Newf("invalid byte size %q: unknown unit", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid byte size %q", s)
+	}
+	bytes := n * unit
+	if bytes >= math.MaxInt64 {
+		return 0, errors.Newf("invalid byte size %q: out of range", s)
+	}
+	return int64(bytes), nil
+}
+
+// formatBytes prints n by the largest binary unit not above it.
+func formatBytes(n int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if n < 1<<10 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f, unit := float64(n)/(1<<10), units[0]
+	for _, u := range units[1:] {
+		if f < 1<<10 {
+			break
+		}
+		f, unit = f/(1<<10), u
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64) + unit
+}
+
+// WithByteCapacity bounds the total byte size of the values, as measured by size, see ParseBytes for human readable sizes.
+//
+//	It is WithWeigher weighing values by size along with the capacity in bytes, so it replaces the weigher and the capacity set before.
+//	A value larger than the capacity, of each shard if sharded, fails the Put or the load with an error telling both sizes,
+//	which is ErrNotEnoughSpace by errors.Is.
+func (b *CacheBuilder[K, V]) WithByteCapacity(bytes int64, size func(V) int64) *CacheBuilder[K, V] {
+	b.WithCapacity(bytes)
+	b.weigher = func(_ K, value V) int64 {
+		return size(value)
+	}
+	b.byteSized = true
+	return b
+}
+
+// tooLargeError fails an entry larger than the byte capacity, it is ErrNotEnoughSpace.
+type tooLargeError struct {
+	size     int64
+	capacity int64
+}
+
+func (e *tooLargeError) Error() string {
+	return fmt.Sprintf("not enough space: entry of %s exceeds the capacity of %s", formatBytes(e.size), formatBytes(e.capacity))
+}
+
+func (e *tooLargeError) Is(target error) bool {
+	return target == ErrNotEnoughSpace
+}
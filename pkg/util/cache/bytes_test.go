@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"4096":    4096,
+		"512MiB":  512 << 20,
+		"1.5 GB":  1500000000,
+		"2kib":    2048,
+		" 10B ":   10,
+		"0.5KiB":  512,
+		"1TiB":    1 << 40,
+		"1.25GiB": 5 << 28,
+	} {
+		n, err := ParseBytes(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, n, s)
+	}
+	for _, s := range []string{"", "MiB", "1 PiB", "1..5MB", "-1MB", "10000000TiB"} {
+		_, err := ParseBytes(s)
+		assert.Error(t, err, s)
+	}
+
+	assert.Equal(t, "100B", formatBytes(100))
+	assert.Equal(t, "1.5KiB", formatBytes(1536))
+	assert.Equal(t, "512MiB", formatBytes(512<<20))
+}
+
+func TestByteCapacity(t *testing.T) {
+	capacity, err := ParseBytes("1KiB")
+	assert.NoError(t, err)
+	cache := NewCacheBuilder[string, []byte]().WithErrorLoader(func(key string) ([]byte, error) {
+		return make([]byte, 2048), nil
+	}).WithByteCapacity(capacity, func(v []byte) int64 {
+		return int64(len(v))
+	}).Build()
+
+	assert.NoError(t, cache.Put("a", make([]byte, 512)))
+	assert.NoError(t, cache.Put("b", make([]byte, 512)))
+	assert.Equal(t, int64(1024), cache.Usage())
+	// c evicts a
+	assert.NoError(t, cache.Put("c", make([]byte, 256)))
+	assert.ElementsMatch(t, []string{"b", "c"}, cache.SnapshotKeys())
+
+	// the entry too large fails telling the sizes, and leaves the cache untouched
+	err = cache.Put("d", make([]byte, 1536))
+	assert.ErrorIs(t, err, ErrNotEnoughSpace)
+	assert.Equal(t, "not enough space: entry of 1.5KiB exceeds the capacity of 1KiB", err.Error())
+	err = cache.Do("e", func(v []byte) error { return nil })
+	assert.True(t, errors.Is(err, ErrNotEnoughSpace))
+	assert.ElementsMatch(t, []string{"b", "c"}, cache.SnapshotKeys())
+}
@@ -1,44 +1,132 @@
 package cache
 
 import (
-	"container/list"
+	"context"
 	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"go.uber.org/atomic"
-	"golang.org/x/sync/singleflight"
+
+	"github.com/milvus-io/milvus/pkg/util/merr"
 )
 
 var (
 	ErrNoSuchItem     = errors.New("no such item")
 	ErrNotEnoughSpace = errors.New("not enough space")
+	ErrCacheClosed    = errors.New("cache closed")
+	ErrLoadTimeout    = errors.New("load timeout")
+	// ErrLoaderUnavailable fails a load without calling the loader while the circuit breaker is open.
+	ErrLoaderUnavailable = errors.New("loader unavailable")
+	// ErrOverloaded fails a miss without loading while there are too many loads pending, see WithMaxPendingLoads.
+	ErrOverloaded = errors.New("too many pending loads")
+	// errNotAdmitted rejects a loaded entry by the admission policy, the value is still handed to the waiters.
+	errNotAdmitted = errors.New("not admitted")
+	// ErrPanicked marks the error converted from a panic of the loader or the finalizer.
+	ErrPanicked = errors.New("panicked")
+	// ErrCachedFailure marks a load error remembered by WithErrorTTL and returned without invoking the loader,
+	// the error still matches the original one by errors.Is.
+	ErrCachedFailure = errors.New("cached load failure")
 )
 
+// Info is the info of an entry returned by EntryInfo.
+type Info struct {
+	// InsertedAt is when the value is loaded or put.
+	InsertedAt time.Time
+	// LastAccess is when the entry is accessed last, it is InsertedAt if never accessed.
+	LastAccess time.Time
+	// Accesses counts the hits of the entry by Do, DoMany, GetIfPresent and Touch.
+	Accesses int64
+	Cost     int64
+	// ExpireAt is when the entry expires, zero if it never expires.
+	ExpireAt time.Time
+	// InsertSeq orders the inserts of the cache, or of the shard if sharded, a replaced value is inserted anew.
+	// Entries tied by the policy are evicted by it, see Policy.
+	InsertSeq uint64
+}
+
+// NoExpiration is the TTL of an entry which never expires.
+const NoExpiration time.Duration = -1
+
 type cacheItem[K comparable, V any] struct {
+	node     policyNode
 	key      K
 	value    V
-	pinCount atomic.Int32
+	cost     int64
+	expireAt time.Time // zero means the item never expires
+	deadline time.Time // expireAt is never renewed beyond deadline by the sliding ttl, zero means no limit
+	// the fields below are guarded by the cache lock
+	insertedAt time.Time
+	lastAccess time.Time
+	accesses   int64
+	seq        uint64
+	tags       []string
+	namespace  string
+	// index in the expiry queue, -1 if absent
+	expiryIndex int
+	pinCount    atomic.Int32
+	// removed is set once the item is dropped from the cache, finalization is deferred to the last unpin if it is still pinned.
+	removed   atomic.Bool
+	finalized atomic.Bool
+	cause     RemovalCause
 }
 
 func newCacheItem[K comparable, V any](key K, value V) *cacheItem[K, V] {
-	return &cacheItem[K, V]{
-		key:   key,
-		value: value,
+	item := &cacheItem[K, V]{
+		key:         key,
+		value:       value,
+		expiryIndex: -1,
 	}
-}
-
-func (item *cacheItem[K, V]) Unpin() {
-	item.pinCount.Dec()
+	item.node.owner = item
+	return item
 }
 
 func (i *cacheItem[K, V]) Value() V {
 	return i.value
 }
 
+func (i *cacheItem[K, V]) expired(now time.Time) bool {
+	return !i.expireAt.IsZero() && !now.Before(i.expireAt)
+}
+
+// loadCall is an in-flight load of a key, shared by all callers missing the key meanwhile.
+type loadCall[K comparable, V any] struct {
+	done chan struct{}
+	// ctx is the context the loader is called with
+	ctx *loadContext
+	// the fields below are guarded by the cache lock
+	waiters   int
+	finished  bool
+	abandoned bool // set if all the waiters have gone away
+	discarded bool // set if the key is removed or put during loading
+	// discardCause tells why the loaded value is discarded
+	discardCause RemovalCause
+	item         *cacheItem[K, V]
+	err          error
+}
+
 type (
-	Loader[K comparable, V any]    func(key K) (V, bool)
-	Finalizer[K comparable, V any] func(key K, value V) error
+	// Loader loads the value of key, false means not found, the value returned with true is cached even if it is the zero value.
+	Loader[K comparable, V any] func(key K) (V, bool)
+	// TTLLoader is a loader which also decides how long the loaded value lives, a zero ttl means no per-entry ttl.
+	TTLLoader[K comparable, V any] func(key K) (V, time.Duration, bool)
+	// BatchLoader loads many keys at once, the keys absent from the returned map are not found.
+	BatchLoader[K comparable, V any] func(keys []K) (map[K]V, error)
+	// ErrorLoader is a loader reporting why it fails, the error is returned by Do as is.
+	ErrorLoader[K comparable, V any] func(key K) (V, error)
+	// ContextLoader is an ErrorLoader taking a context, which is done once the load times out.
+	ContextLoader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+	Finalizer[K comparable, V any]     func(key K, value V) error
+	// LoadObserver is notified of each call of the loader with how long it took and its error.
+	LoadObserver func(d time.Duration, err error)
+
+	// loadFunc is the loader all kinds of loaders are adapted to.
+	loadFunc[K comparable, V any] func(ctx context.Context, key K) (V, time.Duration, error)
 )
 
 // Scavenger records occupation of cache and decide whether to evict if necessary.
@@ -68,11 +156,25 @@ func NewLazyScavenger[K comparable](weight func(K) int64, capacity int64) *LazyS
 }
 
 func (s *LazyScavenger[K]) Collect(key K) (bool, func(K) bool) {
-	w := s.weight(key)
+	ok, collector := s.collectWeight(s.weight(key))
+	if ok {
+		return true, nil
+	}
+	return false, func(key K) bool {
+		return collector(s.weight(key))
+	}
+}
+
+func (s *LazyScavenger[K]) Throw(key K) {
+	s.throwWeight(s.weight(key))
+}
+
+// collectWeight is Collect of an entry weighing w, the collector is fed with weights of entries to evict.
+func (s *LazyScavenger[K]) collectWeight(w int64) (bool, func(int64) bool) {
 	if s.size+w > s.capacity {
 		needCollect := s.size + w - s.capacity
-		return false, func(key K) bool {
-			needCollect -= s.weight(key)
+		return false, func(w int64) bool {
+			needCollect -= w
 			return needCollect <= 0
 		}
 	}
@@ -80,48 +182,489 @@ func (s *LazyScavenger[K]) Collect(key K) (bool, func(K) bool) {
 	return true, nil
 }
 
-func (s *LazyScavenger[K]) Throw(key K) {
-	s.size -= s.weight(key)
+func (s *LazyScavenger[K]) throwWeight(w int64) {
+	s.size -= w
+}
+
+// Size returns the occupation of entries recorded.
+func (s *LazyScavenger[K]) Size() int64 {
+	return s.size
 }
 
 type Cache[K comparable, V any] interface {
 	Do(key K, doer func(V) error) error
+	// DoCtx is Do but stops waiting for the entry to be loaded once ctx is done, ctx.Err() is returned in that case.
+	// The values of ctx are passed to a loader set by WithContextLoader if the call starts the load.
+	DoCtx(ctx context.Context, key K, doer func(V) error) error
+	// DoWithSource is Do but fn is told whether the value is a hit, loaded or being refreshed.
+	DoWithSource(key K, fn func(V, Source) error) error
+	// Acquire borrows the value of key as Do does, loading it if absent, until release is called, which is idempotent.
+	// While borrowed, the entry is not evicted, and if it is removed, replaced or expired, it gives back its capacity at once,
+	// but is finalized only once the last borrow or pin of it is released. So the memory in use may exceed the capacity
+	// by the values dropped and still borrowed. release is a no-op if err is not nil.
+	Acquire(key K) (value V, release func(), err error)
+	// DoMany calls fn once with the values of keys, the missing ones are loaded concurrently.
+	// Keys failed to get are left out of the map, and reported as KeyErrors unless fn fails.
+	DoMany(keys []K, fn func(map[K]V) error) error
+	// GetAllPresent returns the cached values of the unexpired entries among keys, it never invokes the loader.
+	// Each hit and miss counts as GetIfPresent, taken within a single lock of the cache, or of each shard.
+	GetAllPresent(keys []K) map[K]V
+	// Warmup loads keys with at most concurrency loads at a time, entries are evicted as Do if they do not fit.
+	// Keys failed to load are reported as KeyErrors.
+	Warmup(keys []K, concurrency int) error
+	// Put inserts or replaces the value of key without invoking the loader.
+	Put(key K, value V) error
+	// PutAll inserts or replaces the values of items as Put, within a single lock of the cache, or of each shard.
+	// It fails with ErrNotEnoughSpace, leaving the cache untouched, if the items weigh more than the capacity in total,
+	// or else the items not fit for pinned entries are reported as KeyErrors, and the others stay put.
+	// A sharded cache puts the items shard by shard, the items of a shard failed as a whole are reported as KeyErrors.
+	PutAll(items map[K]V) error
+	// CompareAndSwap replaces the value of key with new if there is an unexpired entry and eq(current, old) holds,
+	// returns whether it is replaced. The replaced value is finalized with Replaced as the removal cause.
+	CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool
+	// Remove drops the entry of key, returns whether there was an entry removed.
+	Remove(key K) bool
+	// GetAndRemove drops the unexpired entry of key and returns its value, it never invokes the loader.
+	// Among concurrent callers only one gets the value. The value is finalized as removed by Remove.
+	GetAndRemove(key K) (V, bool)
+	// Pin protects the entry of key from eviction until unpinned as many times.
+	Pin(key K)
+	// Unpin undoes a Pin of key.
+	Unpin(key K)
+	// InvalidateTag drops and finalizes the entries tagged with tag by the tagger of WithTagger, returns the number dropped.
+	// Values being loaded are not tagged yet, so they are cached as usual.
+	InvalidateTag(tag string) int
+	// InvalidateIf drops and finalizes the entries matching pred, returns the number dropped.
+	// pred is evaluated on the live entries under the lock of the cache, or each shard, so it must not call the cache.
+	InvalidateIf(pred func(key K, value V) bool) int
+	// Clear drops and finalizes all entries, returns the combined finalizer errors.
+	Clear() error
+	// Close is Clear, and then Do, DoCtx, DoMany and Put fail with ErrCacheClosed, the background reaper is stopped.
+	// Closing a closed cache does nothing.
+	Close() error
+	// Len returns the number of entries Do serves, the expired ones not reclaimed yet are not counted
+	// unless still within the stale grace of WithStaleWhileRevalidate.
+	Len() int
+	// Usage returns the occupation of entries measured by the scavenger.
+	Usage() int64
+	// Capacity returns the capacity set by WithCapacity, Resize, or tuned by WithAutoTune.
+	Capacity() int64
+	// Resize sets the capacity, entries are evicted in eviction order until the usage fits if it shrinks.
+	// Pinned entries are kept, so the usage may stay above the capacity until they are unpinned and evicted later.
+	Resize(capacity int64)
+	// GetIfPresent returns the cached value of key, it never invokes the loader nor scavenges.
+	GetIfPresent(key K) (V, bool)
+	// GetOrDefault returns the cached value of key, or def if there is no unexpired entry of key, it never invokes the loader.
+	// With WithReadMostly, it takes only the read lock and the hit is buffered.
+	GetOrDefault(key K, def V) V
+	// Contains returns whether there is an unexpired entry of key, it changes nothing, not even the recency or stats.
+	Contains(key K) bool
+	// Touch marks the entry of key as just accessed and renews its sliding ttl, without loading nor reading it.
+	// It returns whether there is an unexpired entry of key.
+	Touch(key K) bool
+	// EntryInfo returns the info of the unexpired entry of key, it changes nothing, not even the recency or stats.
+	EntryInfo(key K) (Info, bool)
+	// TotalCost returns the sum of the weights of entries, as weighed on their last insert or replacement.
+	TotalCost() int64
+	// GetCost returns the weight of the entry of key, expired or not, it changes nothing, not even the recency or stats.
+	GetCost(key K) (int64, bool)
+	// TTL returns the remaining time before the entry of key expires, or NoExpiration if it never expires.
+	// It returns false if there is no unexpired entry of key.
+	TTL(key K) (time.Duration, bool)
+	// Range calls fn for each unexpired entry in eviction order, the next victim first, until fn returns false.
+	// It iterates a snapshot taken at the start, entries are pinned while iterated so fn is free to use the cache.
+	// A sharded cache takes the snapshot shard by shard, the order across shards is unspecified.
+	Range(fn func(key K, value V) bool)
+	// Snapshot returns a copy of the unexpired entries in eviction order, taken within a single lock of each shard.
+	// It is restored by WithRestore, e.g. to warm up after a restart.
+	Snapshot() []Entry[K, V]
+	// SnapshotKeys is Snapshot returning keys only, for values to be loaded again rather than restored.
+	SnapshotKeys() []K
+	// Iterator returns an iterator over a copy of the unexpired entries in no particular order, taken under the read lock,
+	// or the read lock of each shard once the iterator reaches it. Iterating holds no lock, so Do goes on meanwhile,
+	// and entries put or removed since the copy of their shard are not seen. Entries are not pinned, a removed one may be finalized while iterated.
+	Iterator() *Iterator[K, V]
+	// Stats returns a snapshot of the cache statistics.
+	Stats() Stats
+	// ShardStats returns the statistics of each shard, which add up to Stats, e.g. to detect the keys skewed to a few shards.
+	// An unsharded cache has a single shard.
+	ShardStats() []Stats
+	// StatsSnapshot returns the statistics along with the removals by cause, the load latency percentiles
+	// and the size, usage and capacity, as a copy to be marshaled as JSON while the cache is live.
+	StatsSnapshot() StatsSnapshot
+	// HitRatio returns the ratio of hits of Do over the recent lookups, weighed down exponentially by their age,
+	// with the half-life of WithHitRatioWindow. It is 0 if there is no lookup yet.
+	HitRatio() float64
+	// LoadLatency returns a snapshot of the histogram of loader call durations, it is reset along with the stats.
+	LoadLatency() LoadLatency
+	// ResetStats zeroes the cache statistics, it is useful for periodic sampling.
+	ResetStats()
+	// Dump is DumpTo a string, listing the top 10 entries.
+	Dump() string
+	// DumpTo writes a human readable summary of the cache for diagnosis: the size, capacity and usage,
+	// the internals of the eviction policy, and the top most recently used entries with their costs and ttls.
+	// Values are printed by the summarizer of WithSummarizer. A sharded cache is dumped shard by shard,
+	// each within a single lock of it.
+	DumpTo(w io.Writer, top int) error
+}
+
+// DoResult is Do returning a result derived from the cached value, with the same locking semantics as Do.
+func DoResult[K comparable, V any, R any](c Cache[K, V], key K, fn func(V) (R, error)) (R, error) {
+	var result R
+	err := c.Do(key, func(v V) error {
+		var err error
+		result, err = fn(v)
+		return err
+	})
+	return result, err
 }
 
 // lruCache extends the ccache library to provide pinning and unpinning of items.
 type lruCache[K comparable, V any] struct {
 	rwlock sync.RWMutex
-	// the value is *cacheItem[V]
-	items              map[K]*list.Element
-	accessList         *list.List
-	loaderSingleFlight singleflight.Group
+	// keyLocks serializes Put and CompareAndSwap of a key around the writer, without holding rwlock
+	keyLocks keyLocks[K]
+	items    map[K]*cacheItem[K, V]
+	evictor  evictor
+	// in-flight loads, concurrent misses of the same key share one load
+	loads map[K]*loadCall[K, V]
+	// pin counts by key, pinned entries are never evicted
+	pins map[K]int
+	// spaceFreed is closed to wake up callers waiting for space
+	spaceFreed   chan struct{}
+	spaceWaiters atomic.Int32
+	// entries are evicted in background down to softLimit once signaled by trim, if softLimit is set
+	softLimit int64
+	trim      chan struct{}
+	// at least evictBatch victims are evicted at a time if evicting, the victims are finalized once the lock is released
+	evictBatch int
+	victims    []*cacheItem[K, V]
+	// entries over the quota of their namespace by namespacer are evicted first, if namespaces is set
+	namespacer func(K) string
+	namespaces *namespaceUsage
+	// closed is set under lock, done is closed along to stop background goroutines
+	closed atomic.Bool
+	done   chan struct{}
 
-	loader    Loader[K, V]
-	finalizer Finalizer[K, V]
-	scavenger Scavenger[K]
+	loader      loadFunc[K, V]
+	batchLoader BatchLoader[K, V]
+	// loads are observed by loadObserver, and fail once they take longer than loadTimeout
+	loadObserver LoadObserver
+	loadTimeout  time.Duration
+	// tracer traces Do and loads if it is set
+	tracer Tracer
+	// failed loads are retried up to retries times, retryBackoff apart
+	retries      int
+	retryBackoff time.Duration
+	// breaker and loadSlots are shared by all shards of a cache, loadSlots bounds the concurrent loads if set
+	breaker   *circuitBreaker
+	loadSlots chan struct{}
+	// pendingLoads counts the loads started and not finished of all shards, a new one fails beyond maxPending if set
+	pendingLoads *atomic.Int32
+	maxPending   int32
+	// finalizers run the finalizer and the listener in background if set, it is shared by all shards
+	finalizers *finalizerPool
+	// postEviction is called by the hooks worker after each finalization if set, the worker is shared by all shards
+	postEviction func(key K, cause RemovalCause)
+	hooks        *finalizerPool
+	// a failed finalizer is retried up to finalizeRetries times, finalizeBackoff apart, then reported to finalizeFailed
+	finalizeRetries int
+	finalizeBackoff time.Duration
+	finalizeFailed  func(key K, value V, err error)
+	finalizer       Finalizer[K, V]
+	listener        EvictionListener[K, V]
+	// evictions are published to evictionCh if it is set, dropped if it is full
+	evictionCh chan<- Eviction[K, V]
+	veto       func(key K, value V) bool
+	writer     func(key K, value V) error
+	// validator rejects loaded values before they are cached if it is set
+	validator func(key K, value V) error
+	// loaded values are cached only if cacheWhen is true of them, if it is set
+	cacheWhen func(key K, value V) bool
+	// copier copies the values handed to callers if set
+	copier func(V) V
+	// summarizer prints values in Dump
+	summarizer func(V) string
+	scavenger  *LazyScavenger[K]
+	// weigher weighs entries by value if set, or else the weight of scavenger is used
+	weigher func(K, V) int64
+	// byteSized tells the capacity is in bytes, set by WithByteCapacity
+	byteSized bool
+	ttl       time.Duration
+	clock     Clock
+	// the ttl of each entry is randomized within ±ttlJitter of it
+	ttlJitter float64
+	// how long a miss waits for space before failing with ErrNotEnoughSpace
+	waitTimeout time.Duration
+	// entries whose remaining ttl is below refreshAhead are reloaded in background on hit
+	refreshAhead time.Duration
+	// entries accessed no more than refreshMinAccesses times are never refreshed before they expire
+	refreshMinAccesses int64
+	// entries are reloaded in background on hit by chance if earlyBeta is set, loadDelta averages the load durations
+	earlyBeta float64
+	loadDelta atomic.Int64
+	random    func() float64
+	// expired entries are served stale for staleGrace while reloaded in background
+	staleGrace time.Duration
+	// expired entries are served instead of load errors for staleIfError
+	staleIfError time.Duration
+	// entries expire once not accessed for slidingTTL
+	slidingTTL time.Duration
+	// entries not accessed for idleTimeout are evicted by the reaper, or by inserts lazily
+	idleTimeout time.Duration
+	// hits taken under the read lock, only for read mostly caches
+	reads []*readBuffer[K, V]
+	// entries with an expiry ordered by it, only if expired entries are reaped actively
+	expiry *expiryQueue[K, V]
+	// admitter decides whether loaded entries are admitted if it is set
+	admitter admitter
+	// seed hashes keys for the admitter and the read buffers
+	seed maphash.Seed
+	// tagger tags entries on insert, tags indexes them by tag
+	tagger func(key K, value V) []string
+	tags   tagIndex[K]
+	// keys not found by the loader recently
+	negatives *negativeCache[K]
+	// keys failed to load recently, along with their errors
+	failures *negativeCache[K]
+	stats    statsCounter
+	// hitRatio tracks the recent hits of Do
+	hitRatio *hitRatio
+	// insertSeq is the sequence number of the last insert
+	insertSeq uint64
 }
 
 type CacheBuilder[K comparable, V any] struct {
-	loader    Loader[K, V]
-	finalizer Finalizer[K, V]
-	scavenger Scavenger[K]
+	loader          loadFunc[K, V]
+	batchLoader     BatchLoader[K, V]
+	finalizer       Finalizer[K, V]
+	listener        EvictionListener[K, V]
+	evictionCh      chan<- Eviction[K, V]
+	postEviction    func(key K, cause RemovalCause)
+	finalizeWorkers int
+	finalizeRetries int
+	finalizeBackoff time.Duration
+	finalizeFailed  func(key K, value V, err error)
+	veto            func(key K, value V) bool
+	weight          func(K) int64
+	weigher         func(K, V) int64
+	byteSized       bool
+	capacity        int64
+	softLimit       int64
+	evictBatch      int
+	namespacer      func(K) string
+	namespaceQuota  func(namespace string) int64
+	ttl             time.Duration
+	ttlJitter       float64
+	refreshAhead    time.Duration
+	refreshMinHits  int
+	earlyBeta       float64
+	staleGrace      time.Duration
+	staleIfError    time.Duration
+	slidingTTL      time.Duration
+	idleTimeout     time.Duration
+	reapInterval    time.Duration
+	softValues      bool
+	readMostly      bool
+	tagger          func(key K, value V) []string
+	pressure        <-chan float64
+	admission       AdmissionPolicy
+	loadObserver    LoadObserver
+	tracer          Tracer
+	loadTimeout     time.Duration
+	retries         int
+	retryBackoff    time.Duration
+	// the circuit breaker opens after breakerThreshold consecutive failures for breakerCooldown
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	maxLoads         int
+	maxPending       int
+	negativeTTL      time.Duration
+	negativeCap      int
+	errorTTL         time.Duration
+	hitRatioWindow   time.Duration
+	autoTune         *autoTuneConfig
+	clock            Clock
+	waitTimeout      time.Duration
+	secondLevel      Cache[K, V]
+	writer           func(key K, value V) error
+	validator        func(key K, value V) error
+	cacheWhen        func(key K, value V) bool
+	copier           func(V) V
+	summarizer       func(V) string
+	policy           Policy
+	shards           int
+	shardHasher      func(K) uint64
+	initialCapacity  int
+	restored         []Entry[K, V]
 }
 
 func NewCacheBuilder[K comparable, V any]() *CacheBuilder[K, V] {
 	return &CacheBuilder[K, V]{
 		loader:    nil,
 		finalizer: nil,
-		scavenger: NewLazyScavenger(
-			func(key K) int64 {
-				return 1
-			},
-			64,
-		),
+		weight: func(key K) int64 {
+			return 1
+		},
+		capacity:    64,
+		negativeCap: 1024,
+		clock:       wallClock{},
+		policy:      LRU(),
+		shards:      1,
+		summarizer:  summarize[V],
 	}
 }
 
 func (b *CacheBuilder[K, V]) WithLoader(loader Loader[K, V]) *CacheBuilder[K, V] {
-	b.loader = loader
+	b.batchLoader = nil
+	b.loader = func(_ context.Context, key K) (V, time.Duration, error) {
+		value, ok := loader(key)
+		if !ok {
+			return value, 0, ErrNoSuchItem
+		}
+		return value, 0, nil
+	}
+	return b
+}
+
+// WithLoaderTTL sets a loader returning a per-entry ttl along with the value.
+//
+//	The entry expires at the nearer of its own ttl and the one set by WithTTL, it falls back to WithTTL if no per-entry ttl returned.
+func (b *CacheBuilder[K, V]) WithLoaderTTL(loader TTLLoader[K, V]) *CacheBuilder[K, V] {
+	b.batchLoader = nil
+	b.loader = func(_ context.Context, key K) (V, time.Duration, error) {
+		value, ttl, ok := loader(key)
+		if !ok {
+			return value, 0, ErrNoSuchItem
+		}
+		return value, ttl, nil
+	}
+	return b
+}
+
+// WithErrorLoader sets a loader returning an error on failure instead of a bool,
+// so that Do could tell a missing item from a transient failure. Failures are never cached.
+func (b *CacheBuilder[K, V]) WithErrorLoader(loader ErrorLoader[K, V]) *CacheBuilder[K, V] {
+	b.batchLoader = nil
+	b.loader = func(_ context.Context, key K) (V, time.Duration, error) {
+		value, err := loader(key)
+		return value, 0, err
+	}
+	return b
+}
+
+// WithContextLoader is WithErrorLoader with a loader taking a context, so that it could pass the deadline
+// and the values like trace IDs to the downstream calls, and give up a hanging call.
+//
+//	One load serves all the callers missing the key meanwhile, the context of the loader carries the values of the
+//	context of the DoCtx starting the load, while its deadline is the latest one of the waiters, extended as they join,
+//	and there is none if a waiter has no deadline. It is canceled once all the waiters have gone away with their
+//	contexts done, a miss after that starts a new load rather than joining the canceled one. Do, DoMany and refreshes
+//	never go away, so the context is never canceled for them. It is also done once the load timeout elapses, and after
+//	the load returns, right away if there is no deadline or else at the deadline.
+func (b *CacheBuilder[K, V]) WithContextLoader(loader ContextLoader[K, V]) *CacheBuilder[K, V] {
+	b.batchLoader = nil
+	b.loader = func(ctx context.Context, key K) (V, time.Duration, error) {
+		value, err := loader(ctx, key)
+		return value, 0, err
+	}
+	return b
+}
+
+// WithLoaderChain sets loaders tried in order on a miss, e.g. a local store, then a peer, then the origin,
+// the first value loaded is cached.
+//
+//	A loader failing with ErrNoSuchItem or any other error passes the key to the next one. If all fail, the errors other
+//	than ErrNoSuchItem are returned combined, or ErrNoSuchItem if all report it. The chain runs as one load shared
+//	by the waiters of the key, retried and timed out as a whole, and it stops before the next loader
+//	with the error of the context of the load once it is done, see WithContextLoader.
+func (b *CacheBuilder[K, V]) WithLoaderChain(loaders ...func(key K) (V, error)) *CacheBuilder[K, V] {
+	b.batchLoader = nil
+	b.loader = func(ctx context.Context, key K) (V, time.Duration, error) {
+		var zero V
+		errs := make([]error, 0)
+		for _, loader := range loaders {
+			if err := ctx.Err(); err != nil {
+				return zero, 0, err
+			}
+			value, err := loader(key)
+			if err == nil {
+				return value, 0, nil
+			}
+			if !errors.Is(err, ErrNoSuchItem) {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == 0 {
+			return zero, 0, ErrNoSuchItem
+		}
+		return zero, 0, merr.Combine(errs...)
+	}
+	return b
+}
+
+// WithBatchLoader sets a loader of many keys in a single call, it replaces the loader set before.
+//
+//	DoMany loads all the keys it misses with a single call per shard, Do loads with a single key.
+//	Keys absent from the returned map are not found, an error fails all the keys of the call.
+//	Keys being loaded by others are waited for instead of reloaded.
+//	Unlike Do, DoMany does not test the room before loading, keys which do not fit fail with ErrNotEnoughSpace after loaded.
+//	With a second level, keys are loaded one by one through it.
+func (b *CacheBuilder[K, V]) WithBatchLoader(loader BatchLoader[K, V]) *CacheBuilder[K, V] {
+	b.batchLoader = loader
+	b.loader = func(_ context.Context, key K) (V, time.Duration, error) {
+		values, err := loader([]K{key})
+		if err != nil {
+			var zero V
+			return zero, 0, err
+		}
+		value, ok := values[key]
+		if !ok {
+			return value, 0, ErrNoSuchItem
+		}
+		return value, 0, nil
+	}
+	return b
+}
+
+// WithSecondLevel makes a miss consult the second level cache before the loader.
+//
+//	The value is fetched with Do of the second level, which loads it with its own loader on a miss there,
+//	and promoted into this cache. The loader of this cache, if any, is used only when the second level has no such item.
+//	Each level weighs, evicts and finalizes its own entries, so finalizers of both levels may see the same value.
+func (b *CacheBuilder[K, V]) WithSecondLevel(l2 Cache[K, V]) *CacheBuilder[K, V] {
+	b.secondLevel = l2
+	return b
+}
+
+// WithWriteThrough makes Put write the value to the backing store before inserting it,
+// the cache is left unchanged and the error of writer is returned if the write fails.
+//
+//	The written value stays in the store even if it does not fit in the cache and Put fails with ErrNotEnoughSpace.
+//	The writer is called without holding the cache lock, so that a slow write blocks no other key, while Puts and
+//	CompareAndSwaps of the same key are serialized by a lock of the key to keep the store and the cache in the same order.
+func (b *CacheBuilder[K, V]) WithWriteThrough(writer func(key K, value V) error) *CacheBuilder[K, V] {
+	b.writer = writer
+	return b
+}
+
+// WithValidator checks loaded values before they are cached, e.g. to reject a truncated one.
+//
+//	The validator runs once per load, by the load shared by the waiters of the key, after retries. A value it rejects is not
+//	cached, and its error fails the load as a load error, which is remembered by WithErrorTTL and covered by WithStaleIfError.
+//	Values inserted by Put are not validated.
+func (b *CacheBuilder[K, V]) WithValidator(validator func(key K, value V) error) *CacheBuilder[K, V] {
+	b.validator = validator
+	return b
+}
+
+// WithCacheWhen caches a loaded value only if cache is true of it, e.g. to skip empty results or large objects.
+//
+//	A value not cached is handed to the waiters of the load as if it is evicted right away, it never takes room
+//	nor evicts others, and it is finalized with Evicted once unpinned. The room for the key is still tested before loading.
+//	A refreshed value not cached leaves the cached one to expire. Values inserted by Put are always cached.
+func (b *CacheBuilder[K, V]) WithCacheWhen(cache func(key K, value V) bool) *CacheBuilder[K, V] {
+	b.cacheWhen = cache
 	return b
 }
 
@@ -130,165 +673,2094 @@ func (b *CacheBuilder[K, V]) WithFinalizer(finalizer Finalizer[K, V]) *CacheBuil
 	return b
 }
 
-func (b *CacheBuilder[K, V]) WithLazyScavenger(weight func(K) int64, capacity int64) *CacheBuilder[K, V] {
-	b.scavenger = NewLazyScavenger(weight, capacity)
+// WithPostEvictionHook sets a hook called after an entry leaving the cache is finalized, e.g. for metrics or audit.
+//
+//	The hook runs asynchronously by a worker shared by all shards, in the order of finalization mostly, so a slow hook
+//	never stalls evictions. If 1024 hooks are queued already, or the cache is closed, the hook is called by a goroutine of its own.
+//	A panic of the hook is recovered and dropped. Close waits for the queued hooks to finish.
+func (b *CacheBuilder[K, V]) WithPostEvictionHook(hook func(key K, cause RemovalCause)) *CacheBuilder[K, V] {
+	b.postEviction = hook
 	return b
 }
 
-func (b *CacheBuilder[K, V]) WithCapacity(capacity int64) *CacheBuilder[K, V] {
-	b.scavenger = NewLazyScavenger(
-		func(key K) int64 {
-			return 1
-		},
-		capacity,
-	)
+// WithAsyncFinalizer runs the finalizer and the eviction listener by a pool of workers,
+// so that a slow finalizer does not stall the Do evicting entries.
+//
+//	The capacity of an entry is given back once it leaves the cache, before it is finalized.
+//	At most 1024 entries are queued, beyond which finalizing blocks until there is room. The finalizer errors are not
+//	returned by Clear or Close then. Close waits for the queued entries to be finalized, entries finalized later
+//	such as the ones unpinned after Close are finalized in place. The workers are shared by all shards.
+func (b *CacheBuilder[K, V]) WithAsyncFinalizer(workers int) *CacheBuilder[K, V] {
+	b.finalizeWorkers = workers
 	return b
 }
 
-func (b *CacheBuilder[K, V]) Build() Cache[K, V] {
-	return newLRUCache(b.loader, b.finalizer, b.scavenger)
+// WithFinalizerRetry retries a failed finalizer up to attempts times, backoff apart, before giving up the entry.
+//
+//	The finalizer error is still returned as before once the retries are exhausted, e.g. by Clear.
+//	A finalizer called under the lock holds it during the backoff, along with WithAsyncFinalizer it is retried by the workers.
+func (b *CacheBuilder[K, V]) WithFinalizerRetry(attempts int, backoff time.Duration) *CacheBuilder[K, V] {
+	b.finalizeRetries = attempts
+	b.finalizeBackoff = backoff
+	return b
 }
 
-func newLRUCache[K comparable, V any](
-	loader Loader[K, V],
-	finalizer Finalizer[K, V],
-	scavenger Scavenger[K],
-) Cache[K, V] {
-	return &lruCache[K, V]{
-		items:              make(map[K]*list.Element),
-		accessList:         list.New(),
-		loaderSingleFlight: singleflight.Group{},
-		loader:             loader,
-		finalizer:          finalizer,
-		scavenger:          scavenger,
-	}
+// WithFinalizerErrorHandler sets a handler notified of each entry the finalizer fails at last,
+// errors not returned to any caller, such as the ones of the async finalizer, are not lost then.
+func (b *CacheBuilder[K, V]) WithFinalizerErrorHandler(handler func(key K, value V, err error)) *CacheBuilder[K, V] {
+	b.finalizeFailed = handler
+	return b
 }
 
-// Do picks up an item from cache and executes doer. The entry of interest is garented in the cache when doer is executing.
-func (c *lruCache[K, V]) Do(key K, doer func(V) error) error {
-	item, err := c.getAndPin(key)
-	if err != nil {
-		return err
-	}
-	defer item.Unpin()
-	return doer(item.Value())
+// WithEvictionListener sets a listener notified with the cause whenever an entry leaves the cache, it coexists with the finalizer.
+func (b *CacheBuilder[K, V]) WithEvictionListener(listener EvictionListener[K, V]) *CacheBuilder[K, V] {
+	b.listener = listener
+	return b
 }
 
-func (c *lruCache[K, V]) peek(key K) *cacheItem[K, V] {
-	c.rwlock.Lock()
-	defer c.rwlock.Unlock()
-	e, ok := c.items[key]
-	if ok {
-		item := e.Value.(*cacheItem[K, V])
-		c.accessList.MoveToFront(e)
-		return item
-	}
-	return nil
+// WithEvictionChannel publishes every entry leaving the cache to ch with the cause, as the eviction listener is notified.
+//
+//	Publishing never blocks, an eviction is dropped if ch is full and counted by Stats as DroppedEvictions,
+//	so the capacity of ch is the backlog a slow consumer is allowed. ch is shared by all shards, and it is never closed by the cache.
+func (b *CacheBuilder[K, V]) WithEvictionChannel(ch chan<- Eviction[K, V]) *CacheBuilder[K, V] {
+	b.evictionCh = ch
+	return b
 }
 
-// GetAndPin gets and pins the given key if it exists
-func (c *lruCache[K, V]) getAndPin(key K) (*cacheItem[K, V], error) {
-	if item := c.peek(key); item != nil {
-		item.pinCount.Inc()
-		return item, nil
+// WithEvictionVeto lets veto protect an entry from eviction for the moment, a victim vetoed is skipped for the next one.
+//
+//	It is consulted, under the lock, whenever an entry is to be evicted to make room, by Resize, idle timeout or memory pressure,
+//	maybe more than once for the same victim. If all candidates are vetoed, the miss fails with ErrNotEnoughSpace.
+//	Remove, Clear and expiry are not subject to the veto.
+func (b *CacheBuilder[K, V]) WithEvictionVeto(veto func(key K, value V) bool) *CacheBuilder[K, V] {
+	b.veto = veto
+	return b
+}
+
+func (b *CacheBuilder[K, V]) WithLazyScavenger(weight func(K) int64, capacity int64) *CacheBuilder[K, V] {
+	b.weight = weight
+	b.weigher = nil
+	b.byteSized = false
+	b.capacity = capacity
+	return b
+}
+
+// WithWeigher weighs entries by the loaded value instead of the key, the capacity set by WithCapacity is the total weight then.
+//
+//	Since the weight is unknown until loaded, a miss is not tested against capacity before loading,
+//	entries are evicted in policy order when the loaded value is inserted.
+//	A value replaced by Put, CompareAndSwap or a refresh is weighed again, others are evicted if it outgrows the room left.
+func (b *CacheBuilder[K, V]) WithWeigher(weigher func(key K, value V) int64) *CacheBuilder[K, V] {
+	b.weigher = weigher
+	b.byteSized = false
+	return b
+}
+
+// WithWatermarks evicts entries in background down to soft once the usage grows beyond it, hard is the capacity.
+//
+//	So that inserts rarely find the cache full and have to evict inline, Stats counts how often they do as HardLimitHits.
+//	The entries are weighed as set by WithCapacity or WithWeigher, or counted if neither is set. Resize changes the hard limit only.
+//	A soft watermark <= 0 or not below hard disables the background eviction.
+func (b *CacheBuilder[K, V]) WithWatermarks(soft, hard int64) *CacheBuilder[K, V] {
+	b.capacity = hard
+	b.softLimit = soft
+	if soft >= hard {
+		b.softLimit = 0
 	}
+	return b
+}
 
-	if c.loader != nil {
-		// Try scavenge if there is room. If not, fail fast.
-		//	Note that the test is not accurate since we are not locking `loader` here.
-		if _, ok := c.tryScavenge(key); !ok {
-			return nil, ErrNotEnoughSpace
-		}
+// WithEvictionBatch evicts n victims at least once an insert has to evict, so that the next inserts find room without evicting.
+//
+//	Victims are taken in eviction order until the entry fits, and then up to n in all, never more.
+//	The victims are finalized after the lock is released, so that their finalizers do not stall the others. A n <= 1 disables it.
+func (b *CacheBuilder[K, V]) WithEvictionBatch(n int) *CacheBuilder[K, V] {
+	b.evictBatch = n
+	return b
+}
 
-		strKey := fmt.Sprint(key)
-		item, err, _ := c.loaderSingleFlight.Do(strKey, func() (interface{}, error) {
-			if item := c.peek(key); item != nil {
-				item.pinCount.Inc()
-				return item, nil
-			}
+// WithNamespacer tells the namespace of each key, e.g. the tenant, so that the quota of WithNamespaceQuota applies to each namespace.
+func (b *CacheBuilder[K, V]) WithNamespacer(namespacer func(key K) string) *CacheBuilder[K, V] {
+	b.namespacer = namespacer
+	return b
+}
 
-			value, ok := c.loader(key)
-			if !ok {
-				return nil, ErrNoSuchItem
-			}
+// WithNamespaceQuota sets the quota of each namespace told by WithNamespacer, a quota <= 0 means unlimited.
+//
+//	A namespace may grow beyond its quota while there is room, the capacity still bounds the total.
+//	Once the cache is full, the entries of the namespaces over their quota are evicted first, counting in the entry inserted,
+//	the others are evicted in the eviction order only if those are pinned or not enough.
+//	So if the quotas sum up to at most the capacity, a namespace within its quota never loses entries to others,
+//	a noisy namespace evicts its own entries once beyond its quota. With WithShards, each shard takes its part of a quota.
+func (b *CacheBuilder[K, V]) WithNamespaceQuota(quota func(namespace string) int64) *CacheBuilder[K, V] {
+	b.namespaceQuota = quota
+	return b
+}
 
-			item, err := c.setAndPin(key, value)
-			if err != nil {
-				return nil, err
-			}
-			return item, nil
-		})
+// WithCopier hands callers a copy of the cached value by copier, so that they could not mutate the value shared by others.
+//
+//	It applies to the values passed to the doers of Do, DoCtx, DoWithSource and DoMany, and returned by GetIfPresent.
+//	The copy is made on each call, the finalizer and listeners still get the cached value itself.
+func (b *CacheBuilder[K, V]) WithCopier(copier func(V) V) *CacheBuilder[K, V] {
+	b.copier = copier
+	return b
+}
 
-		if err == nil {
-			return item.(*cacheItem[K, V]), nil
-		}
+// WithSummarizer sets how Dump prints values, e.g. by size for large ones.
+//
+//	The default prints a value by %v cut to 64 bytes, which still formats the whole value first.
+func (b *CacheBuilder[K, V]) WithSummarizer(summarizer func(V) string) *CacheBuilder[K, V] {
+	b.summarizer = summarizer
+	return b
+}
+
+func (b *CacheBuilder[K, V]) WithCapacity(capacity int64) *CacheBuilder[K, V] {
+	b.weight = func(key K) int64 {
+		return 1
 	}
+	b.capacity = capacity
+	return b
+}
 
-	return nil, ErrNoSuchItem
+// WithTTL makes entries expire once they are older than ttl, the next Do on an expired entry reloads it.
+//
+//	Expired entries are reclaimed lazily, they still occupy capacity until reloaded or evicted.
+func (b *CacheBuilder[K, V]) WithTTL(ttl time.Duration) *CacheBuilder[K, V] {
+	b.ttl = ttl
+	return b
 }
 
-func (c *lruCache[K, V]) tryScavenge(key K) ([]K, bool) {
-	c.rwlock.Lock()
-	defer c.rwlock.Unlock()
-	return c.lockfreeTryScavenge(key)
+// WithTTLJitter randomizes the ttl of each entry within ±fraction of it on insert, so that entries inserted together expire apart.
+//
+//	It applies to the ttl by WithTTL or by the loader, the expiry is drawn once per entry, and honored by Do and the reaper alike.
+//	With WithSlidingTTL, the jittered ttl is the deadline renewals never go beyond, the sliding ttl itself is not jittered.
+//	The fraction is capped at 1, a fraction <= 0 disables it.
+func (b *CacheBuilder[K, V]) WithTTLJitter(fraction float64) *CacheBuilder[K, V] {
+	b.ttlJitter = math.Min(fraction, 1)
+	return b
 }
 
-func (c *lruCache[K, V]) lockfreeTryScavenge(key K) ([]K, bool) {
-	ok, collector := c.scavenger.Collect(key)
-	toEvict := make([]K, 0)
-	if !ok {
-		done := false
-		for p := c.accessList.Back(); p != nil && !done; p = p.Prev() {
-			evictItem := p.Value.(*cacheItem[K, V])
-			if evictItem.pinCount.Load() > 0 {
-				continue
-			}
-			toEvict = append(toEvict, evictItem.key)
-			done = collector(evictItem.key)
-		}
-		if !done {
-			return nil, false
-		}
-	} else {
-		// If no collection needed, give back the space.
-		c.scavenger.Throw(key)
-	}
-	return toEvict, true
+// WithSlidingTTL makes entries expire once not accessed by Do for ttl, each hit renews the expiry to ttl later.
+//
+//	It works along with WithTTL and per-entry ttl, which bound the lifetime from the load that renewals never go beyond.
+func (b *CacheBuilder[K, V]) WithSlidingTTL(ttl time.Duration) *CacheBuilder[K, V] {
+	b.slidingTTL = ttl
+	return b
 }
 
-// for cache miss
-func (c *lruCache[K, V]) setAndPin(key K, value V) (*cacheItem[K, V], error) {
-	c.rwlock.Lock()
-	defer c.rwlock.Unlock()
+// WithIdleTimeout evicts entries not accessed for d, no matter how fresh their values are, with Expired as the removal cause.
+//
+//	Unlike WithSlidingTTL, an idle entry is not expired, it is served as usual until evicted. Idle entries are evicted
+//	by the reaper of WithActiveExpiration, which scans all entries, and lazily by each insert, which scans the first
+//	128 entries in eviction order. Along with WithTTL, whichever comes first drops the entry. Pinned entries are kept.
+func (b *CacheBuilder[K, V]) WithIdleTimeout(d time.Duration) *CacheBuilder[K, V] {
+	b.idleTimeout = d
+	return b
+}
 
-	item := newCacheItem[K, V](key, value)
-	item.pinCount.Inc()
+// WithActiveExpiration reaps expired entries in background every interval, instead of leaving them occupying capacity.
+//
+//	Entries with an expiry are ordered by it, so reaping visits only the expired ones,
+//	a batch at a time to not block Do for long. Entries which could still be served stale are kept until they could not.
+//	The reaper runs until the cache is closed, each shard runs its own.
+func (b *CacheBuilder[K, V]) WithActiveExpiration(interval time.Duration) *CacheBuilder[K, V] {
+	b.reapInterval = interval
+	return b
+}
 
-	// tryScavenge is done again since the load call is lock free.
-	toEvict, ok := c.lockfreeTryScavenge(key)
+// WithSoftValues lets entries be reclaimed under memory pressure instead of running out of memory.
+//
+//	The memory in use is checked against the memory limit of the runtime, set by GOMEMLIMIT or debug.SetMemoryLimit,
+//	every second. Beyond 90% of the limit, a quarter of the unpinned entries are evicted in eviction order each time,
+//	they give back their capacity and are finalized with Evicted as the removal cause, the next Do reloads them.
+//	Nothing is reclaimed if there is no memory limit. Each shard checks on its own until the cache is closed.
+func (b *CacheBuilder[K, V]) WithSoftValues() *CacheBuilder[K, V] {
+	b.softValues = true
+	return b
+}
 
-	if !ok {
-		if c.finalizer != nil {
-			c.finalizer(key, value)
-		}
-		return nil, ErrNotEnoughSpace
-	}
+// WithTagger tags each entry inserted with tagger, so that entries sharing a tag could be dropped at once by InvalidateTag.
+//
+//	Tags are indexed while the entries stay in the cache, an entry replaced is tagged again by its new value.
+func (b *CacheBuilder[K, V]) WithTagger(tagger func(key K, value V) []string) *CacheBuilder[K, V] {
+	b.tagger = tagger
+	return b
+}
+
+// WithReadMostly takes hits under the read lock, so that concurrent hits do not contend for the lock.
+//
+//	Hits are buffered in 16 stripes and replayed to the policy under the write lock before picking victims, or once 64 are buffered
+//	if the lock is free at the moment, or else they are dropped. So the eviction order is approximate,
+//	and the access info of EntryInfo is updated late. A hit on an expired entry takes the write lock as usual.
+//	It has no effect along with the admission policy or the sliding ttl, which update on each hit.
+func (b *CacheBuilder[K, V]) WithReadMostly() *CacheBuilder[K, V] {
+	b.readMostly = true
+	return b
+}
 
-	for _, ek := range toEvict {
-		e := c.items[ek]
-		delete(c.items, ek)
-		c.accessList.Remove(e)
-		c.scavenger.Throw(ek)
+// WithMemoryPressureSignal evicts entries in eviction order as the memory pressure signaled through ch goes up,
+// in addition to the capacity limit.
+//
+//	The pressure ranges from 0.0 to 1.0, beyond 0.8 the fraction of entries evicted grows linearly up to all of them at 1.0.
+//	Pinned entries are kept, the evicted ones are finalized with Evicted as the removal cause.
+//	A sharded cache shrinks all shards on each signal. The signal is watched until it is closed or the cache is closed.
+func (b *CacheBuilder[K, V]) WithMemoryPressureSignal(ch <-chan float64) *CacheBuilder[K, V] {
+	b.pressure = ch
+	return b
+}
 
-		if c.finalizer != nil {
-			item := e.Value.(*cacheItem[K, V])
-			c.finalizer(ek, item.value)
-		}
-	}
+// WithAdmissionPolicy filters loaded entries which would evict others, e.g. with TinyLFU to resist scans.
+//
+//	Accesses of keys are recorded by Do and GetIfPresent. A rejected entry still serves the Do which loaded it,
+//	then it is finalized with Evicted as the removal cause. Put and reloads of cached keys are always admitted.
+func (b *CacheBuilder[K, V]) WithAdmissionPolicy(policy AdmissionPolicy) *CacheBuilder[K, V] {
+	b.admission = policy
+	return b
+}
 
-	c.scavenger.Collect(key)
-	e := c.accessList.PushFront(item)
-	c.items[item.key] = e
+// WithLoadTimeout makes a load fail with ErrLoadTimeout if the loader does not return within timeout,
+// so that a hanging loader does not stall the waiters of the key forever.
+//
+//	The context of a loader set by WithContextLoader is done on timeout, so the loader could return early.
+//	Other loaders could not be interrupted, they keep running in background after the timeout until they return.
+//	A value returned after the timeout is finalized with Abandoned as the removal cause, it is never cached.
+//	The key is not cached on timeout, the next Do loads it again.
+func (b *CacheBuilder[K, V]) WithLoadTimeout(timeout time.Duration) *CacheBuilder[K, V] {
+	b.loadTimeout = timeout
+	return b
+}
 
-	return item, nil
+// WithLoadRetry makes a failed load retried up to attempts times, backoff apart, before its error is returned.
+//
+//	Retries happen within the single load shared by the waiters of the key, the last error is returned if all fail.
+//	ErrNoSuchItem and permanent errors, see Transient, are never retried. Retrying stops once the context of the load is done, see WithContextLoader,
+//	or its deadline is nearer than the backoff.
+func (b *CacheBuilder[K, V]) WithLoadRetry(attempts int, backoff time.Duration) *CacheBuilder[K, V] {
+	b.retries = attempts
+	b.retryBackoff = backoff
+	return b
+}
+
+// WithLoaderCircuitBreaker makes loads fail fast with ErrLoaderUnavailable for cooldown
+// after threshold consecutive loader failures, so that a backend which is down is not hammered.
+//
+//	After the cooldown a single load probes the loader, the breaker closes if it succeeds or opens again if not.
+//	ErrNoSuchItem and permanent errors, see Transient, are not failures, context errors are neither failures nor successes.
+//	Each retry of WithLoadRetry is a loader call, retrying stops once the breaker opens.
+//	The breaker is shared by all shards, as they load from the same backend.
+func (b *CacheBuilder[K, V]) WithLoaderCircuitBreaker(threshold int, cooldown time.Duration) *CacheBuilder[K, V] {
+	b.breakerThreshold = threshold
+	b.breakerCooldown = cooldown
+	return b
+}
+
+// WithMaxConcurrentLoads bounds the loads in flight to n, the others wait for a slot before calling the loader.
+//
+//	Concurrent misses of the same key share one load, which takes one slot along with its retries.
+//	A load stops waiting for a slot once its context is done, see WithContextLoader, its waiters fail with ctx.Err().
+//	A load abandoned on timeout gives back its slot although the loader may be still running.
+//	The slots are shared by all shards.
+func (b *CacheBuilder[K, V]) WithMaxConcurrentLoads(n int) *CacheBuilder[K, V] {
+	b.maxLoads = n
+	return b
+}
+
+// WithMaxPendingLoads fails a miss fast with ErrOverloaded if it would start a load beyond n loads pending, waiting for a slot or in flight.
+//
+//	Concurrent misses of the same key join one load, which counts once. A refresh is skipped instead, serving the current value,
+//	and the keys of a batch load count one by one. The count is shared by all shards.
+func (b *CacheBuilder[K, V]) WithMaxPendingLoads(n int) *CacheBuilder[K, V] {
+	b.maxPending = n
+	return b
+}
+
+// WithLoadObserver sets an observer of loader calls, e.g. to export the load latency as metrics.
+//
+//	It is called outside the lock, a call of the batch loader is observed once.
+func (b *CacheBuilder[K, V]) WithLoadObserver(observer LoadObserver) *CacheBuilder[K, V] {
+	b.loadObserver = observer
+	return b
+}
+
+// WithTracer traces each Do in a span named cache.Do, and each load in a child span named cache.load.
+//
+//	The spans carry the fingerprint of the key as cache.key, and cache.Do tells whether it hits as cache.hit.
+//	A load shared by many callers is traced once, under the caller starting it. Nothing is traced without a tracer.
+func (b *CacheBuilder[K, V]) WithTracer(tracer Tracer) *CacheBuilder[K, V] {
+	b.tracer = tracer
+	return b
+}
+
+// WithRefreshAhead refreshes an entry in background when Do hits it with remaining ttl below threshold,
+// the current value is served meanwhile.
+//
+//	There is at most one load of a key in flight, callers missing the key after it expires join the refresh.
+//	A failed refresh leaves the current value in place until it expires.
+func (b *CacheBuilder[K, V]) WithRefreshAhead(threshold time.Duration) *CacheBuilder[K, V] {
+	b.refreshAhead = threshold
+	return b
+}
+
+// WithConditionalRefresh refreshes an entry ahead of its expiry only if it is accessed more than minAccessCount times,
+// cold entries simply expire and are loaded again on the next miss.
+//
+//	The hits are counted as Info.Accesses, including the hit which would trigger the refresh. It applies to
+//	WithRefreshAhead and WithEarlyExpiration, expired entries served by WithStaleWhileRevalidate are always reloaded.
+func (b *CacheBuilder[K, V]) WithConditionalRefresh(minAccessCount int) *CacheBuilder[K, V] {
+	b.refreshMinHits = minAccessCount
+	return b
+}
+
+// WithEarlyExpiration refreshes an entry in background by chance when Do hits it, the current value is served meanwhile.
+//
+//	The chance grows as the entry nears its expiry, scaled by beta and the moving average of load durations, as XFetch does,
+//	so entries expiring together are refreshed at different times. A beta of 1 is a fair start, greater ones refresh earlier.
+//	It works along with WithRefreshAhead, and there is at most one load of a key in flight as well. A beta <= 0 disables it.
+func (b *CacheBuilder[K, V]) WithEarlyExpiration(beta float64) *CacheBuilder[K, V] {
+	b.earlyBeta = beta
+	return b
+}
+
+// WithStaleWhileRevalidate makes Do serve an entry expired less than grace ago, and reload it in background like a refresh ahead.
+// Do blocks on the load only once the entry has been expired for grace.
+//
+//	A stale entry is evictable as any other, Do misses it once evicted, with Expired as the removal cause.
+//	The reload replaces the stale entry in place so it needs no more room than the difference in weight,
+//	if the new value still does not fit, the reload fails and the stale value is served until the grace ends.
+//	GetIfPresent never returns a stale value.
+func (b *CacheBuilder[K, V]) WithStaleWhileRevalidate(grace time.Duration) *CacheBuilder[K, V] {
+	b.staleGrace = grace
+	return b
+}
+
+// WithStaleIfError makes Do serve the expired value instead of failing if reloading it fails within d after it expires.
+//
+//	The load error is returned as usual if there is no such value, e.g. it has been evicted,
+//	or the loader reports ErrNoSuchItem, which means the item is gone rather than a transient failure,
+//	or a permanent error, see Transient.
+//	The stale value is not cached again, the next Do retries loading.
+func (b *CacheBuilder[K, V]) WithStaleIfError(d time.Duration) *CacheBuilder[K, V] {
+	b.staleIfError = d
+	return b
+}
+
+// WithNegativeCaching makes Do remember a key not found by the loader for ttl,
+// the following Do of the key fails with ErrNoSuchItem without invoking the loader until then.
+//
+//	At most the negative capacity of keys are remembered, 1024 by default, the oldest are forgotten first.
+//	Put or Remove of a key forgets it.
+func (b *CacheBuilder[K, V]) WithNegativeCaching(ttl time.Duration) *CacheBuilder[K, V] {
+	b.negativeTTL = ttl
+	return b
+}
+
+// WithErrorTTL makes Do remember a load error for d, the following Do of the key fails with the same error
+// marked as ErrCachedFailure without invoking the loader until then, so that a failing backend is not hammered.
+//
+//	It is distinct from negative caching, ErrNoSuchItem is never remembered by it, nor the context errors of the callers,
+//	ErrCacheClosed, ErrLoaderUnavailable and permanent errors, see Transient. The keys are bounded by the negative capacity as well.
+//	A successful load, Put or Remove of a key forgets its error, so does Clear.
+func (b *CacheBuilder[K, V]) WithErrorTTL(d time.Duration) *CacheBuilder[K, V] {
+	b.errorTTL = d
+	return b
+}
+
+// WithHitRatioWindow sets the half-life of HitRatio, the weight of a lookup halves every halfLife, 1 minute by default.
+func (b *CacheBuilder[K, V]) WithHitRatioWindow(halfLife time.Duration) *CacheBuilder[K, V] {
+	b.hitRatioWindow = halfLife
+	return b
+}
+
+// WithAutoTune adjusts the capacity in background to keep HitRatio near target, within [min, max].
+//
+//	Every 10 seconds the capacity grows by a tenth if the hit ratio is below target, and shrinks by a tenth if the memory
+//	is tight, i.e. beyond 90% of the memory limit of the runtime, or the hit ratio is well above target, and it is left
+//	as is if there is no lookup meanwhile. Shrinking evicts entries as Resize, growing just raises the limit.
+//	The capacity set by WithCapacity is clamped into [min, max], Capacity returns the one tuned.
+func (b *CacheBuilder[K, V]) WithAutoTune(min, max int64, target float64) *CacheBuilder[K, V] {
+	b.autoTune = &autoTuneConfig{min: min, max: max, target: target}
+	return b
+}
+
+// WithNegativeCapacity sets the max number of keys remembered by negative caching.
+func (b *CacheBuilder[K, V]) WithNegativeCapacity(capacity int) *CacheBuilder[K, V] {
+	b.negativeCap = capacity
+	return b
+}
+
+// WithWaitTimeout makes a miss wait up to timeout for space to be freed instead of failing with ErrNotEnoughSpace right away.
+//
+//	Waiters are woken up whenever an entry is unpinned or removed. An entry which never fits fails right away,
+//	and so does the miss of a cache weighed by value, whose weight is unknown before loaded.
+//	DoCtx stops waiting once its context is done. A zero timeout disables waiting.
+func (b *CacheBuilder[K, V]) WithWaitTimeout(timeout time.Duration) *CacheBuilder[K, V] {
+	b.waitTimeout = timeout
+	return b
+}
+
+// WithRestore preloads the entries taken by Snapshot into the cache built, as if they are put in order.
+//
+//	Entries expired already are skipped, the others keep their expiry unless the ttl of the cache is shorter.
+//	Costs are measured again, the earlier entries are evicted if they do not fit. Finalizers are not called for the skipped.
+func (b *CacheBuilder[K, V]) WithRestore(entries []Entry[K, V]) *CacheBuilder[K, V] {
+	b.restored = entries
+	return b
+}
+
+// WithClock sets the clock judging expiry, the wall clock is used by default.
+func (b *CacheBuilder[K, V]) WithClock(clock Clock) *CacheBuilder[K, V] {
+	b.clock = clock
+	return b
+}
+
+// WithEvictionPolicy sets the policy picking entries to evict, LRU is used by default.
+func (b *CacheBuilder[K, V]) WithEvictionPolicy(policy Policy) *CacheBuilder[K, V] {
+	b.policy = policy
+	return b
+}
+
+// WithInitialCapacity pre-sizes the entry map and the structures of the eviction policy for n entries,
+// to save the rehashing while a cache known to grow large warms up. It changes no behavior.
+//
+//	The entries are split over shards as the capacity.
+func (b *CacheBuilder[K, V]) WithInitialCapacity(n int) *CacheBuilder[K, V] {
+	b.initialCapacity = n
+	return b
+}
+
+// WithShards partitions keys by hash across n independent caches, each of them has its own lock
+// and a proportional slice of the capacity, to reduce lock contention.
+//
+//	The shards are cut down to the capacity if there are more, so that none of them is left without room.
+//	A single entry must fit in the capacity of its shard, i.e. capacity/n, or else it fails with ErrNotEnoughSpace.
+//	Resize below the number of shards leaves each shard room of 1.
+func (b *CacheBuilder[K, V]) WithShards(n int) *CacheBuilder[K, V] {
+	b.shards = n
+	return b
+}
+
+// WithShardHasher assigns keys to shards by hasher instead of the default hash, e.g. for keys skewed under it, see ShardStats.
+//
+//	The key goes to the shard of hasher(key) modulo the number of shards, so the hash should spread over its low bits.
+//	It is used only to pick the shard, keys are still looked up within the shard by equality, and the admitter
+//	and the read buffers keep hashing by the default. The default mixes integers and hashes others by maphash,
+//	by their fmt representation unless strings.
+func (b *CacheBuilder[K, V]) WithShardHasher(hasher func(K) uint64) *CacheBuilder[K, V] {
+	b.shardHasher = hasher
+	return b
+}
+
+// load composes the loader with the second level if any.
+func (b *CacheBuilder[K, V]) load() loadFunc[K, V] {
+	l2, loader := b.secondLevel, b.loader
+	if l2 == nil {
+		return loader
+	}
+	return func(ctx context.Context, key K) (V, time.Duration, error) {
+		var value V
+		err := l2.DoCtx(ctx, key, func(v V) error {
+			value = v
+			return nil
+		})
+		if errors.Is(err, ErrNoSuchItem) && loader != nil {
+			return loader(ctx, key)
+		}
+		return value, 0, err
+	}
+}
+
+// batchLoad returns the batch loader if it could be used in place of the loader.
+func (b *CacheBuilder[K, V]) batchLoad() BatchLoader[K, V] {
+	if b.secondLevel != nil {
+		return nil
+	}
+	return b.batchLoader
+}
+
+func (b *CacheBuilder[K, V]) Build() Cache[K, V] {
+	if b.shards > 1 && int64(b.shards) > b.capacity {
+		// Build on a copy, so that the builder keeps the shards set for later builds.
+		clamped := *b
+		clamped.shards = 1
+		if b.capacity > 1 {
+			clamped.shards = int(b.capacity)
+		}
+		b = &clamped
+	}
+	if b.shards > 1 {
+		c := newShardedCache(b)
+		b.startAutoTune(c, c.shards[0].done)
+		c.restore(b.restored)
+		return c
+	}
+	c := newLRUCache(b, b.capacity)
+	b.startAutoTune(c, c.done)
+	c.restore(b.restored)
+	return c
+}
+
+func newLRUCache[K comparable, V any](b *CacheBuilder[K, V], capacity int64) *lruCache[K, V] {
+	// Tombstones are split over shards as the capacity.
+	negativeCap, initialCap := b.negativeCap, b.initialCapacity
+	if b.shards > 1 {
+		negativeCap = (negativeCap + b.shards - 1) / b.shards
+		initialCap = (initialCap + b.shards - 1) / b.shards
+	}
+	c := &lruCache[K, V]{
+		items:        make(map[K]*cacheItem[K, V], initialCap),
+		evictor:      b.policy.newEvictor(capacity),
+		loads:        make(map[K]*loadCall[K, V]),
+		pins:         make(map[K]int),
+		done:         make(chan struct{}),
+		loader:       b.load(),
+		batchLoader:  b.batchLoad(),
+		finalizer:    b.finalizer,
+		listener:     b.listener,
+		postEviction: b.postEviction,
+		evictionCh:   b.evictionCh,
+		veto:         b.veto,
+		writer:       b.writer,
+		validator:    b.validator,
+		cacheWhen:    b.cacheWhen,
+		copier:       b.copier,
+		summarizer:   b.summarizer,
+		scavenger:    NewLazyScavenger(b.weight, capacity),
+		weigher:      b.weigher,
+		byteSized:    b.byteSized,
+		ttl:          b.ttl,
+		ttlJitter:    b.ttlJitter,
+		clock:        b.clock,
+		hitRatio:     newHitRatio(b.hitRatioWindow, b.clock.Now()),
+
+		finalizeRetries: b.finalizeRetries,
+		finalizeBackoff: b.finalizeBackoff,
+		finalizeFailed:  b.finalizeFailed,
+
+		refreshAhead:       b.refreshAhead,
+		refreshMinAccesses: int64(b.refreshMinHits),
+		earlyBeta:          b.earlyBeta,
+		random:             rand.Float64,
+		staleGrace:         b.staleGrace,
+		staleIfError:       b.staleIfError,
+		slidingTTL:         b.slidingTTL,
+		idleTimeout:        b.idleTimeout,
+		loadObserver:       b.loadObserver,
+		tracer:             b.tracer,
+		loadTimeout:        b.loadTimeout,
+		retries:            b.retries,
+		retryBackoff:       b.retryBackoff,
+		breaker:            newCircuitBreaker(b.breakerThreshold, b.breakerCooldown, b.clock),
+		waitTimeout:        b.waitTimeout,
+		negatives:          newNegativeCache[K](b.negativeTTL, negativeCap),
+		failures:           newNegativeCache[K](b.errorTTL, negativeCap),
+		seed:               maphash.MakeSeed(),
+	}
+	if b.readMostly && b.admission == nil && b.slidingTTL <= 0 {
+		c.reads = make([]*readBuffer[K, V], readStripes)
+		for i := range c.reads {
+			c.reads[i] = newReadBuffer[K, V]()
+		}
+	}
+	if b.maxLoads > 0 {
+		c.loadSlots = make(chan struct{}, b.maxLoads)
+	}
+	if b.maxPending > 0 {
+		c.maxPending = int32(b.maxPending)
+		c.pendingLoads = atomic.NewInt32(0)
+	}
+	if b.tagger != nil {
+		c.tagger = b.tagger
+		c.tags = make(tagIndex[K])
+	}
+	if b.admission != nil {
+		c.admitter = b.admission.newAdmitter(capacity)
+	}
+	if e, ok := c.evictor.(presizer); ok && initialCap > 0 {
+		e.presize(initialCap)
+	}
+	if b.reapInterval > 0 {
+		c.expiry = newExpiryQueue[K, V]()
+		go c.reapEvery(b.reapInterval)
+	}
+	if b.softValues {
+		go c.reclaimEvery(softCheckInterval)
+	}
+	if b.namespacer != nil {
+		c.namespacer = b.namespacer
+		c.namespaces = newNamespaceUsage(b.namespaceQuota)
+	}
+	if b.evictBatch > 1 {
+		c.evictBatch = b.evictBatch
+	}
+	if b.softLimit > 0 {
+		c.softLimit = b.softLimit
+		c.trim = make(chan struct{}, 1)
+		go c.trimEvery()
+	}
+	// The sharded cache watches the pressure and runs the finalizer workers for all shards.
+	if b.shards <= 1 {
+		c.finalizers = newFinalizerPool(b.finalizeWorkers)
+		if b.postEviction != nil {
+			c.hooks = newFinalizerPool(1)
+		}
+		if b.pressure != nil {
+			go watchPressure(b.pressure, c.done, c)
+		}
+	}
+	return c
+}
+
+// Do picks up an item from cache and executes doer. The entry of interest is garented in the cache when doer is executing.
+func (c *lruCache[K, V]) Do(key K, doer func(V) error) error {
+	return c.DoCtx(context.Background(), key, doer)
+}
+
+func (c *lruCache[K, V]) DoCtx(ctx context.Context, key K, doer func(V) error) error {
+	return c.do(ctx, key, func(v V, _ Source) error { return doer(v) })
+}
+
+// do is DoCtx telling fn the source of the value.
+func (c *lruCache[K, V]) do(ctx context.Context, key K, fn func(V, Source) error) (err error) {
+	ctx, span := c.startSpan(ctx, spanDo, key)
+	if span != nil {
+		defer func() { span.End(err) }()
+	}
+	item, source, err := c.getAndPin(ctx, key)
+	if span != nil {
+		span.SetAttribute(attrHit, source != Loaded)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.unpin(item)
+	return fn(c.copy(item.Value()), source)
+}
+
+func (c *lruCache[K, V]) Acquire(key K) (V, func(), error) {
+	item, _, err := c.getAndPin(context.Background(), key)
+	if err != nil {
+		var zero V
+		return zero, func() {}, err
+	}
+	released := atomic.NewBool(false)
+	return c.copy(item.Value()), func() {
+		if released.CompareAndSwap(false, true) {
+			c.unpin(item)
+		}
+	}, nil
+}
+
+func (c *lruCache[K, V]) Capacity() int64 {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.scavenger.capacity
+}
+
+func (c *lruCache[K, V]) Resize(capacity int64) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.scavenger.capacity = capacity
+	c.drainReads()
+	if r, ok := c.evictor.(resizer); ok {
+		r.resize(capacity)
+	}
+
+	over := c.scavenger.size - capacity
+	toEvict := make([]*cacheItem[K, V], 0)
+	c.evictor.victims(func(n *policyNode) bool {
+		if over <= 0 {
+			return false
+		}
+		item := n.owner.(*cacheItem[K, V])
+		if !c.evictable(item) {
+			return true
+		}
+		toEvict = append(toEvict, item)
+		over -= item.cost
+		return true
+	})
+	for _, item := range toEvict {
+		c.removeItem(item, c.removalCause(item, Evicted))
+		c.stats.evictions.Inc()
+	}
+	// Waiters may fit in the capacity grown.
+	c.notifySpaceFreed()
+}
+
+func (c *lruCache[K, V]) GetIfPresent(key K) (V, bool) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.recordAccess(key)
+	item, ok := c.items[key]
+	now := c.clock.Now()
+	if !ok || item.expired(now) {
+		c.stats.misses.Inc()
+		var zero V
+		return zero, false
+	}
+	c.access(item, now)
+	c.stats.hits.Inc()
+	return c.copy(item.value), true
+}
+
+func (c *lruCache[K, V]) GetOrDefault(key K, def V) V {
+	if c.reads == nil {
+		if v, ok := c.GetIfPresent(key); ok {
+			return v
+		}
+		return def
+	}
+	item := c.peekShared(key)
+	if item == nil {
+		c.stats.misses.Inc()
+		return def
+	}
+	defer c.unpin(item)
+	c.stats.hits.Inc()
+	return c.copy(item.Value())
+}
+
+// copy returns a copy of v by the copier if it is set, or else v itself.
+func (c *lruCache[K, V]) copy(v V) V {
+	if c.copier == nil {
+		return v
+	}
+	return c.copier(v)
+}
+
+func (c *lruCache[K, V]) Close() error {
+	err := c.close()
+	c.finalizers.drain()
+	c.hooks.drain()
+	return err
+}
+
+// close is Close without draining the async finalizer.
+func (c *lruCache[K, V]) close() error {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	if c.closed.Load() {
+		return nil
+	}
+	c.closed.Store(true)
+	close(c.done)
+	return c.lockfreeClear()
+}
+
+func (c *lruCache[K, V]) Len() int {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	now := c.clock.Now().Add(-c.staleGrace)
+	n := 0
+	for _, item := range c.items {
+		if !item.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *lruCache[K, V]) Usage() int64 {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.scavenger.Size()
+}
+
+func (c *lruCache[K, V]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+func (c *lruCache[K, V]) ShardStats() []Stats {
+	return []Stats{c.Stats()}
+}
+
+func (c *lruCache[K, V]) StatsSnapshot() StatsSnapshot {
+	removals := make(map[RemovalCause]int64)
+	c.stats.addRemovals(removals)
+	s := newStatsSnapshot(c.Stats(), removals, c.LoadLatency())
+	s.TakenAt = c.clock.Now()
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	s.Size, s.Usage, s.Capacity = len(c.items), c.scavenger.Size(), c.scavenger.capacity
+	return s
+}
+
+func (c *lruCache[K, V]) Contains(key K) bool {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	item, ok := c.items[key]
+	return ok && !item.expired(c.clock.Now())
+}
+
+func (c *lruCache[K, V]) Touch(key K) bool {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	item, ok := c.items[key]
+	now := c.clock.Now()
+	if !ok || item.expired(now) {
+		return false
+	}
+	c.slide(item, now)
+	c.access(item, now)
+	return true
+}
+
+func (c *lruCache[K, V]) EntryInfo(key K) (Info, bool) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	item, ok := c.items[key]
+	if !ok || item.expired(c.clock.Now()) {
+		return Info{}, false
+	}
+	return Info{
+		InsertedAt: item.insertedAt,
+		LastAccess: item.lastAccess,
+		Accesses:   item.accesses,
+		Cost:       item.cost,
+		ExpireAt:   item.expireAt,
+		InsertSeq:  item.seq,
+	}, true
+}
+
+// TotalCost is the same as Usage.
+func (c *lruCache[K, V]) TotalCost() int64 {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.scavenger.Size()
+}
+
+func (c *lruCache[K, V]) GetCost(key K) (int64, bool) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	item, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	return item.cost, true
+}
+
+func (c *lruCache[K, V]) TTL(key K) (time.Duration, bool) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	item, ok := c.items[key]
+	now := c.clock.Now()
+	if !ok || item.expired(now) {
+		return 0, false
+	}
+	if item.expireAt.IsZero() {
+		return NoExpiration, true
+	}
+	return item.expireAt.Sub(now), true
+}
+
+func (c *lruCache[K, V]) Range(fn func(key K, value V) bool) {
+	c.iterate(fn)
+}
+
+// iterate is Range returning false if stopped by fn.
+func (c *lruCache[K, V]) iterate(fn func(key K, value V) bool) bool {
+	c.rwlock.Lock()
+	now := c.clock.Now()
+	items := make([]*cacheItem[K, V], 0, len(c.items))
+	c.evictor.victims(func(n *policyNode) bool {
+		item := n.owner.(*cacheItem[K, V])
+		if !item.expired(now) {
+			item.pinCount.Inc()
+			items = append(items, item)
+		}
+		return true
+	})
+	c.rwlock.Unlock()
+
+	next := true
+	for _, item := range items {
+		if next {
+			next = fn(item.key, item.value)
+		}
+		c.unpin(item)
+	}
+	return next
+}
+
+func (c *lruCache[K, V]) LoadLatency() LoadLatency {
+	return c.stats.loadLatency.snapshot()
+}
+
+func (c *lruCache[K, V]) ResetStats() {
+	c.stats.reset()
+}
+
+// peek pins and returns the item of key if it exists and is not expired, or expired within the stale grace.
+//
+//	The item is pinned under lock, so that it could not be evicted before the caller gets it.
+func (c *lruCache[K, V]) peek(key K) *cacheItem[K, V] {
+	if c.reads != nil {
+		if item := c.peekShared(key); item != nil {
+			return item
+		}
+	}
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.recordAccess(key)
+	return c.lockfreePeek(key)
+}
+
+func (c *lruCache[K, V]) lockfreePeek(key K) *cacheItem[K, V] {
+	item, ok := c.items[key]
+	if ok {
+		now := c.clock.Now()
+		if item.expired(now.Add(-c.staleGrace)) {
+			// Leave the expired item in place, it is replaced when reloaded or evicted by scavenging.
+			return nil
+		}
+		if !item.expired(now) {
+			c.slide(item, now)
+		}
+		c.access(item, now)
+		item.pinCount.Inc()
+		return item
+	}
+	return nil
+}
+
+func (c *lruCache[K, V]) unpin(item *cacheItem[K, V]) {
+	if item.pinCount.Dec() > 0 {
+		return
+	}
+	if item.removed.Load() {
+		c.finalize(item)
+	} else if c.spaceWaiters.Load() > 0 {
+		// The item becomes evictable, wake up callers waiting for space.
+		c.rwlock.Lock()
+		c.notifySpaceFreed()
+		c.rwlock.Unlock()
+	}
+}
+
+// release marks an item already dropped from the cache as removed, the item is finalized right away if not pinned,
+// or else by the last unpin.
+func (c *lruCache[K, V]) release(item *cacheItem[K, V], cause RemovalCause) error {
+	item.cause = cause
+	item.removed.Store(true)
+	if item.pinCount.Load() == 0 {
+		return c.finalize(item)
+	}
+	return nil
+}
+
+func (c *lruCache[K, V]) finalize(item *cacheItem[K, V]) error {
+	if !item.finalized.CompareAndSwap(false, true) {
+		return nil
+	}
+	c.stats.removals[item.cause].Inc()
+	if c.finalizers.submit(func() { c.runFinalizer(item) }) {
+		return nil
+	}
+	return c.runFinalizer(item)
+}
+
+// runFinalizer calls the finalizer and the listener of item.
+func (c *lruCache[K, V]) runFinalizer(item *cacheItem[K, V]) error {
+	var err error
+	if c.finalizer != nil {
+		err = c.callFinalizer(item)
+		for attempt := 0; err != nil && attempt < c.finalizeRetries; attempt++ {
+			time.Sleep(c.finalizeBackoff)
+			err = c.callFinalizer(item)
+		}
+		if err != nil && c.finalizeFailed != nil {
+			c.finalizeFailed(item.key, item.value, err)
+		}
+	}
+	if c.listener != nil {
+		err = merr.Combine(err, c.callListener(item))
+	}
+	c.publishEviction(item)
+	c.runPostEviction(item)
+	return err
+}
+
+// runPostEviction hands the post eviction hook of item to the hooks worker, or to a goroutine if the worker is busy or closed.
+func (c *lruCache[K, V]) runPostEviction(item *cacheItem[K, V]) {
+	if c.postEviction == nil {
+		return
+	}
+	key, cause := item.key, item.cause
+	hook := func() {
+		defer func() {
+			// A failed hook is not the business of the cache.
+			_ = recover()
+		}()
+		c.postEviction(key, cause)
+	}
+	if !c.hooks.trySubmit(hook) {
+		go hook()
+	}
+}
+
+// publishEviction sends item to the eviction channel if set, it is dropped rather than blocking the finalization if the channel is full.
+func (c *lruCache[K, V]) publishEviction(item *cacheItem[K, V]) {
+	if c.evictionCh == nil {
+		return
+	}
+	select {
+	case c.evictionCh <- Eviction[K, V]{Key: item.key, Value: item.value, Cause: item.cause}:
+	default:
+		c.stats.dropped.Inc()
+	}
+}
+
+// callFinalizer invokes the finalizer, a panic is recovered as an error, as it is called under lock mostly.
+func (c *lruCache[K, V]) callFinalizer(item *cacheItem[K, V]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return c.finalizer(item.key, item.value)
+}
+
+func (c *lruCache[K, V]) callListener(item *cacheItem[K, V]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	c.listener(item.key, item.value, item.cause)
+	return nil
+}
+
+// callLoader invokes the loader, a panic is recovered as an error to fail the load instead of the process.
+func (c *lruCache[K, V]) callLoader(ctx context.Context, key K) (value V, ttl time.Duration, err error) {
+	start := c.clock.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+		c.observeLoad(start, err)
+	}()
+	return c.loader(ctx, key)
+}
+
+// validate checks a loaded value by the validator if set, a panic is recovered as an error to reject the value.
+func (c *lruCache[K, V]) validate(key K, value V) (err error) {
+	if c.validator == nil {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return c.validator(key, value)
+}
+
+func (c *lruCache[K, V]) callBatchLoader(keys []K) (values map[K]V, err error) {
+	start := c.clock.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, panicError(r)
+		}
+		c.observeLoad(start, err)
+	}()
+	return c.batchLoader(keys)
+}
+
+// startLoad registers a new load call of key, it fails with ErrOverloaded if there are maxPending loads pending already.
+// The caller must hold the lock.
+func (c *lruCache[K, V]) startLoad(ctx context.Context, key K) (*loadCall[K, V], error) {
+	if c.maxPending > 0 && c.pendingLoads.Inc() > c.maxPending {
+		c.pendingLoads.Dec()
+		return nil, ErrOverloaded
+	}
+	call := &loadCall[K, V]{done: make(chan struct{}), ctx: newLoadContext(ctx)}
+	c.loads[key] = call
+	return call, nil
+}
+
+// acquireLoad takes a slot of the concurrent loads, it fails if ctx is done or the cache is closed first.
+func (c *lruCache[K, V]) acquireLoad(ctx context.Context) error {
+	if c.loadSlots == nil {
+		return nil
+	}
+	select {
+	case c.loadSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrCacheClosed
+	}
+}
+
+func (c *lruCache[K, V]) releaseLoad() {
+	if c.loadSlots != nil {
+		<-c.loadSlots
+	}
+}
+
+// loadWithRetry calls the loader, and retries it on failure up to the retry attempts.
+func (c *lruCache[K, V]) loadWithRetry(ctx context.Context, key K) (V, time.Duration, error) {
+	value, ttl, err := c.loadWithBreaker(ctx, key)
+	for attempt := 0; err != nil && attempt < c.retries && c.retryable(err); attempt++ {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < c.retryBackoff {
+			break
+		}
+		if !c.sleep(ctx, c.retryBackoff) {
+			break
+		}
+		value, ttl, err = c.loadWithBreaker(ctx, key)
+	}
+	return value, ttl, err
+}
+
+func (c *lruCache[K, V]) retryable(err error) bool {
+	return !errors.Is(err, ErrNoSuchItem) && !errors.Is(err, ErrLoaderUnavailable) && !isPermanent(err)
+}
+
+// loadWithBreaker calls the loader unless the circuit breaker is open.
+func (c *lruCache[K, V]) loadWithBreaker(ctx context.Context, key K) (V, time.Duration, error) {
+	probe, ok := c.breaker.allow()
+	if !ok {
+		var zero V
+		return zero, 0, ErrLoaderUnavailable
+	}
+	value, ttl, err := c.loadWithTimeout(ctx, key)
+	c.breaker.report(probe, err)
+	return value, ttl, err
+}
+
+// sleep waits for d, returns false if ctx is done or the cache is closed meanwhile.
+func (c *lruCache[K, V]) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.done:
+		return false
+	}
+}
+
+// loadWithTimeout calls the loader with ctx within the load timeout, its context is done once the timeout elapses.
+func (c *lruCache[K, V]) loadWithTimeout(ctx context.Context, key K) (V, time.Duration, error) {
+	type result struct {
+		value V
+		ttl   time.Duration
+		err   error
+	}
+	if c.loadTimeout <= 0 {
+		return c.callLoader(ctx, key)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.loadTimeout)
+	r, ok := callWithTimeout(c.loadTimeout, func() result {
+		defer cancel()
+		value, ttl, err := c.callLoader(ctx, key)
+		return result{value, ttl, err}
+	}, func(r result) {
+		if r.err == nil {
+			c.abandon(key, r.value)
+		}
+	})
+	if !ok {
+		return r.value, 0, ErrLoadTimeout
+	}
+	return r.value, r.ttl, r.err
+}
+
+// batchLoadWithTimeout calls the batch loader within the load timeout.
+func (c *lruCache[K, V]) batchLoadWithTimeout(keys []K) (map[K]V, error) {
+	type result struct {
+		values map[K]V
+		err    error
+	}
+	r, ok := callWithTimeout(c.loadTimeout, func() result {
+		values, err := c.callBatchLoader(keys)
+		return result{values, err}
+	}, func(r result) {
+		if r.err == nil {
+			for key, value := range r.values {
+				c.abandon(key, value)
+			}
+		}
+	})
+	if !ok {
+		return nil, ErrLoadTimeout
+	}
+	return r.values, r.err
+}
+
+// abandon finalizes a value loaded after timeout, it is never cached.
+func (c *lruCache[K, V]) abandon(key K, value V) {
+	c.release(newCacheItem[K, V](key, value), Abandoned)
+}
+
+// callWithTimeout returns the result of call if it returns within timeout, or else false,
+// and abandon is called with the result once the call returns. A non-positive timeout waits forever.
+func callWithTimeout[R any](timeout time.Duration, call func() R, abandon func(R)) (R, bool) {
+	if timeout <= 0 {
+		return call(), true
+	}
+	ch := make(chan R, 1)
+	go func() {
+		ch <- call()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r, true
+	case <-timer.C:
+		go func() {
+			abandon(<-ch)
+		}()
+		var zero R
+		return zero, false
+	}
+}
+
+func (c *lruCache[K, V]) observeLoad(start time.Time, err error) {
+	d := c.clock.Now().Sub(start)
+	c.stats.loadLatency.observe(d)
+	c.observeDelta(d)
+	if c.loadObserver != nil {
+		c.loadObserver(d, err)
+	}
+}
+
+// panicError converts a recovered panic into an error.
+func panicError(r any) error {
+	return &PanicError{Value: r}
+}
+
+// PanicError is the error converted from a panic of the loader or the finalizer, it is ErrPanicked,
+// and it wraps the panic value if it is an error.
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanicked
+}
+
+// GetAndPin gets and pins the given key, the key is loaded if it does not exist. source tells where the item is got from.
+func (c *lruCache[K, V]) getAndPin(ctx context.Context, key K) (item *cacheItem[K, V], source Source, err error) {
+	if c.closed.Load() {
+		return nil, 0, ErrCacheClosed
+	}
+	if item := c.peek(key); item != nil {
+		c.stats.hits.Inc()
+		c.hitRatio.record(true, c.clock.Now())
+		if c.refreshIfNeeded(item) {
+			return item, Refreshed, nil
+		}
+		return item, Hit, nil
+	}
+	c.hitRatio.record(false, c.clock.Now())
+	item, err = c.loadAndPin(ctx, key)
+	return item, Loaded, err
+}
+
+// loadAndPin is the miss path of getAndPin, it loads the key missed by peek, or joins the load in flight.
+func (c *lruCache[K, V]) loadAndPin(ctx context.Context, key K) (*cacheItem[K, V], error) {
+	c.stats.misses.Inc()
+	if c.loader == nil {
+		return nil, ErrNoSuchItem
+	}
+	if err := c.rememberedFailure(key); err != nil {
+		return nil, err
+	}
+	// Try scavenge if there is room. If not, fail fast.
+	//	Note that the test is not accurate since we are not locking `loader` here.
+	//	The test is skipped if the entry is weighed by value, which is not known before loaded.
+	if c.weigher == nil {
+		if err := c.tryScavenge(ctx, key, c.scavenger.weight(key)); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.rwlock.Lock()
+	// The key may be loaded by others since peek.
+	if item := c.lockfreePeek(key); item != nil {
+		c.rwlock.Unlock()
+		return item, nil
+	}
+	if c.closed.Load() {
+		c.rwlock.Unlock()
+		return nil, ErrCacheClosed
+	}
+	call, loading := c.inflightLoad(key)
+	if !loading {
+		var err error
+		if call, err = c.startLoad(ctx, key); err != nil {
+			c.rwlock.Unlock()
+			return nil, err
+		}
+	} else {
+		call.ctx.join(ctx)
+	}
+	call.waiters++
+	c.rwlock.Unlock()
+
+	if !loading {
+		if ctx.Done() == nil {
+			// Never canceled, load in place to save a goroutine.
+			c.load(key, call)
+		} else {
+			go c.load(key, call)
+		}
+	}
+
+	select {
+	case <-call.done:
+		return call.item, call.err
+	case <-ctx.Done():
+		c.rwlock.Lock()
+		finished := call.finished
+		if !finished {
+			call.waiters--
+			if call.waiters == 0 && call.ctx.abandonable() {
+				call.abandoned = true
+				call.ctx.cancel(context.Canceled)
+			}
+		}
+		c.rwlock.Unlock()
+		if finished {
+			// The load finished just now, give back the pin taken for us.
+			<-call.done
+			if call.item != nil {
+				c.unpin(call.item)
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// refreshIfNeeded starts a background load of the item if its remaining ttl is below the refresh threshold, or it is stale.
+// It tells whether the item is being refreshed, by this call or by another.
+func (c *lruCache[K, V]) refreshIfNeeded(item *cacheItem[K, V]) bool {
+	if c.loader == nil || (c.refreshAhead <= 0 && c.staleGrace <= 0 && c.earlyBeta <= 0) {
+		return false
+	}
+
+	c.rwlock.Lock()
+	// The expiry is read under lock, it is renewed by the sliding ttl.
+	if remaining := item.expireAt.Sub(c.clock.Now()); item.expireAt.IsZero() ||
+		(remaining > 0 && remaining >= c.refreshAhead && !c.expireEarly(remaining)) {
+		c.rwlock.Unlock()
+		return false
+	}
+	if c.items[item.key] != item {
+		// Replaced already.
+		c.rwlock.Unlock()
+		return false
+	}
+	if item.expireAt.After(c.clock.Now()) && item.accesses <= c.refreshMinAccesses {
+		// Too cold to refresh ahead.
+		c.rwlock.Unlock()
+		return false
+	}
+	if _, loading := c.inflightLoad(item.key); loading {
+		c.rwlock.Unlock()
+		return true
+	}
+	call, err := c.startLoad(context.Background(), item.key)
+	c.rwlock.Unlock()
+	if err != nil {
+		// Overloaded, the current value is served as is.
+		return false
+	}
+
+	go c.load(item.key, call)
+	return true
+}
+
+// load invokes the loader with the context of call and hands the loaded item to all waiters, each of them holds a pin of the item.
+func (c *lruCache[K, V]) load(key K, call *loadCall[K, V]) {
+	var value V
+	var ttl time.Duration
+	ctx, span := c.startSpan(call.ctx, spanLoad, key)
+	err := c.acquireLoad(ctx)
+	if err == nil {
+		value, ttl, err = c.loadWithRetry(ctx, key)
+		c.releaseLoad()
+	}
+	if err == nil {
+		err = c.validate(key, value)
+	}
+	call.ctx.stop()
+	if span != nil {
+		span.End(err)
+	}
+
+	c.rwlock.Lock()
+	var victims []*cacheItem[K, V]
+	defer func() { c.finalizeVictims(victims) }()
+	defer close(call.done)
+	defer c.rwlock.Unlock()
+	c.finishLoad(key, call, value, ttl, err)
+	victims = c.takeVictims()
+}
+
+// finishLoad settles the load call with its result, the caller must hold the lock and close call.done after unlocking.
+func (c *lruCache[K, V]) finishLoad(key K, call *loadCall[K, V], value V, ttl time.Duration, err error) {
+	if c.loads[key] == call {
+		delete(c.loads, key)
+	}
+	if c.maxPending > 0 {
+		c.pendingLoads.Dec()
+	}
+	call.finished = true
+	if err != nil {
+		c.stats.loadFailures.Inc()
+		if errors.Is(err, ErrNoSuchItem) && !call.discarded {
+			c.negatives.add(key, c.clock.Now())
+		}
+		if item := c.staleOnError(key, err); item != nil && !call.discarded {
+			item.pinCount.Add(int32(call.waiters))
+			call.item = item
+			return
+		}
+		if memorable(err) && !call.discarded {
+			c.failures.addError(key, c.clock.Now(), err)
+		}
+		call.err = err
+		return
+	}
+	c.stats.loadSuccesses.Inc()
+	c.failures.remove(key)
+	call.item, call.err = c.setAndPin(key, value, ttl, call)
+}
+
+// tryScavenge tests if there is room for an entry of key with cost, waits for space to be freed up to the wait timeout if not.
+func (c *lruCache[K, V]) tryScavenge(ctx context.Context, key K, cost int64) error {
+	var timeout <-chan time.Time
+	for {
+		c.rwlock.Lock()
+		// Count in as a waiter before testing, so that an unpin right after the test could not miss it.
+		c.spaceWaiters.Inc()
+		_, ok := c.lockfreeTryScavenge(key, cost)
+		if ok || c.waitTimeout <= 0 || cost > c.scavenger.capacity {
+			c.spaceWaiters.Dec()
+			c.rwlock.Unlock()
+			if ok {
+				return nil
+			}
+			return ErrNotEnoughSpace
+		}
+		freed := c.spaceFreedCh()
+		c.rwlock.Unlock()
+
+		if timeout == nil {
+			timer := time.NewTimer(c.waitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-freed:
+			c.spaceWaiters.Dec()
+		case <-timeout:
+			c.spaceWaiters.Dec()
+			return ErrNotEnoughSpace
+		case <-ctx.Done():
+			c.spaceWaiters.Dec()
+			return ctx.Err()
+		}
+	}
+}
+
+// spaceFreedCh returns a channel closed once space may be freed, the caller must hold the lock.
+func (c *lruCache[K, V]) spaceFreedCh() <-chan struct{} {
+	if c.spaceFreed == nil {
+		c.spaceFreed = make(chan struct{})
+	}
+	return c.spaceFreed
+}
+
+// notifySpaceFreed wakes up callers waiting for space, the caller must hold the lock.
+func (c *lruCache[K, V]) notifySpaceFreed() {
+	if c.spaceFreed != nil {
+		close(c.spaceFreed)
+		c.spaceFreed = nil
+	}
+}
+
+// lockfreeTryScavenge tests if there is room for an entry of key with cost, returns items to evict to make room.
+func (c *lruCache[K, V]) lockfreeTryScavenge(key K, cost int64) ([]*cacheItem[K, V], bool) {
+	if cost > c.scavenger.capacity {
+		// Never fits even if everything is evicted, fail fast without scanning.
+		return nil, false
+	}
+	ok, collector := c.scavenger.collectWeight(cost)
+	toEvict := make([]*cacheItem[K, V], 0)
+	if !ok {
+		// Pick victims by the recency up to date.
+		c.drainReads()
+		done := false
+		var picked map[*cacheItem[K, V]]struct{}
+		if c.namespaces != nil {
+			// Namespaces over quota give up their entries first.
+			toEvict, done = c.pickOverQuota(key, cost, collector)
+			picked = make(map[*cacheItem[K, V]]struct{}, len(toEvict))
+			for _, item := range toEvict {
+				picked[item] = struct{}{}
+			}
+		}
+		// Once enough space is collected, more victims are taken to round up to the eviction batch.
+		enough := func() bool { return done && len(toEvict) >= c.evictBatch }
+		c.evictor.victims(func(n *policyNode) bool {
+			if enough() {
+				return false
+			}
+			evictItem := n.owner.(*cacheItem[K, V])
+			if _, ok := picked[evictItem]; ok || !c.evictable(evictItem) {
+				return true
+			}
+			if evictItem.key == key {
+				// The item of the same key is being replaced, its space is counted already.
+				return true
+			}
+			toEvict = append(toEvict, evictItem)
+			if !done {
+				done = collector(evictItem.cost)
+			}
+			return !enough()
+		})
+		if !done {
+			return nil, false
+		}
+	} else {
+		// If no collection needed, give back the space.
+		c.scavenger.throwWeight(cost)
+	}
+	return toEvict, true
+}
+
+// setAndPin inserts the loaded value for cache miss, and pins it for every waiter, the caller must hold the lock.
+func (c *lruCache[K, V]) setAndPin(key K, value V, ttl time.Duration, call *loadCall[K, V]) (*cacheItem[K, V], error) {
+	item := c.newItem(key, value, ttl)
+	if call.discarded {
+		// Removed or superseded while loading, hand the value to the waiters without caching it.
+		item.pinCount.Add(int32(call.waiters))
+		c.release(item, call.discardCause)
+		return item, nil
+	}
+	if c.cacheWhen != nil && !c.cacheWhen(key, value) {
+		item.pinCount.Add(int32(call.waiters))
+		c.release(item, Evicted)
+		return item, nil
+	}
+
+	// tryScavenge is done again since the load call is lock free.
+	if err := c.insert(item, true); err == errNotAdmitted {
+		// Serve the waiters once as if it is evicted right away.
+		item.pinCount.Add(int32(call.waiters))
+		c.release(item, Evicted)
+		return item, nil
+	} else if err != nil {
+		c.release(item, Evicted)
+		return nil, err
+	}
+	item.pinCount.Add(int32(call.waiters))
+	return item, nil
+}
+
+// rememberedFailure returns ErrNoSuchItem if key is remembered as not found, or the load error of key remembered,
+// nil if neither.
+func (c *lruCache[K, V]) rememberedFailure(key K) error {
+	if c.negatives == nil && c.failures == nil {
+		return nil
+	}
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	return c.lockfreeRememberedFailure(key)
+}
+
+func (c *lruCache[K, V]) lockfreeRememberedFailure(key K) error {
+	now := c.clock.Now()
+	if c.negatives.contains(key, now) {
+		return ErrNoSuchItem
+	}
+	if stone := c.failures.lookup(key, now); stone != nil {
+		return &cachedFailure{err: stone.err}
+	}
+	return nil
+}
+
+// cachedFailure is a load error remembered by WithErrorTTL, it is ErrCachedFailure and wraps the error.
+type cachedFailure struct {
+	err error
+}
+
+func (e *cachedFailure) Error() string {
+	return "cached: " + e.err.Error()
+}
+
+func (e *cachedFailure) Unwrap() error {
+	return e.err
+}
+
+func (e *cachedFailure) Is(target error) bool {
+	return target == ErrCachedFailure
+}
+
+// memorable tells whether a load error is remembered by WithErrorTTL, the errors telling nothing of the backend are not.
+func memorable(err error) bool {
+	return !errors.Is(err, ErrNoSuchItem) && !errors.Is(err, ErrCacheClosed) && !errors.Is(err, ErrLoaderUnavailable) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !isPermanent(err)
+}
+
+// staleOnError returns the item of key to serve instead of the load error if any, the caller must hold the lock.
+func (c *lruCache[K, V]) staleOnError(key K, err error) *cacheItem[K, V] {
+	if c.staleIfError <= 0 || errors.Is(err, ErrNoSuchItem) || isPermanent(err) {
+		return nil
+	}
+	item, ok := c.items[key]
+	if !ok || item.expireAt.IsZero() || item.expired(c.clock.Now().Add(-c.staleIfError)) {
+		return nil
+	}
+	return item
+}
+
+// inflightLoad returns the load of key in flight to join, the caller must hold the lock.
+//
+//	A load abandoned by all its waiters is not joined but superseded, its value is finalized with Abandoned if any.
+func (c *lruCache[K, V]) inflightLoad(key K) (*loadCall[K, V], bool) {
+	call, ok := c.loads[key]
+	if ok && call.abandoned {
+		c.discardLoad(key, Abandoned)
+		delete(c.loads, key)
+		return nil, false
+	}
+	return call, ok
+}
+
+// discardLoad makes the in-flight load of key, if any, hand its value to waiters without caching it.
+func (c *lruCache[K, V]) discardLoad(key K, cause RemovalCause) {
+	if call, ok := c.loads[key]; ok {
+		call.discarded = true
+		call.discardCause = cause
+	}
+}
+
+// Put inserts or replaces the value of key, entries are evicted if necessary, and the replaced value is finalized.
+//
+//	ErrNotEnoughSpace is returned if there is no room for the value even after scavenging, the cache is untouched in that case.
+//	A load of the key in flight is not cached once Put succeeds, its waiters still get the loaded value.
+func (c *lruCache[K, V]) Put(key K, value V) error {
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	unlock := c.keyLocks.lock(key)
+	defer unlock()
+	if c.writer != nil {
+		if err := c.writer(key, value); err != nil {
+			return err
+		}
+	}
+	c.rwlock.Lock()
+	defer c.unlock()
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	if err := c.insert(c.newItem(key, value, 0), false); err != nil {
+		return err
+	}
+	// The value put supersedes the one being loaded.
+	c.discardLoad(key, Replaced)
+	c.negatives.remove(key)
+	c.failures.remove(key)
+	return nil
+}
+
+// PutAll is Put of many items within a single lock, it is best effort once the items fit in the capacity in total.
+//
+//	The items put never evict each other, an item not fit unless evicting one put before is reported as ErrNotEnoughSpace.
+//	With WithWriteThrough, the items are written and put one by one by Put, so that each one is serialized with the others of its key.
+func (c *lruCache[K, V]) PutAll(items map[K]V) error {
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	errs := make(KeyErrors[K])
+	if c.writer != nil {
+		for key, value := range items {
+			if err := c.Put(key, value); err != nil {
+				errs[key] = err
+			}
+		}
+		return errs.orNil()
+	}
+
+	c.rwlock.Lock()
+	defer c.unlock()
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	batch := make([]*cacheItem[K, V], 0, len(items))
+	total := int64(0)
+	for key, value := range items {
+		item := c.newItem(key, value, 0)
+		batch = append(batch, item)
+		total += item.cost
+	}
+	// Fail fast if the items never fit, rather than evicting ones of the batch to make room for others.
+	if total > c.scavenger.capacity {
+		return ErrNotEnoughSpace
+	}
+	inserted := make([]*cacheItem[K, V], 0, len(batch))
+	for _, item := range batch {
+		if err := c.insert(item, false); err != nil {
+			errs[item.key] = err
+			continue
+		}
+		// Pinned till the end of the batch, so that the items never evict each other.
+		item.pinCount.Inc()
+		inserted = append(inserted, item)
+		c.discardLoad(item.key, Replaced)
+		c.negatives.remove(item.key)
+		c.failures.remove(item.key)
+	}
+	for _, item := range inserted {
+		item.pinCount.Dec()
+	}
+	if len(inserted) > 0 && c.spaceWaiters.Load() > 0 {
+		c.notifySpaceFreed()
+	}
+	return errs.orNil()
+}
+
+// CompareAndSwap is Put conditioned on the current value, it fails if there is no room for the new value.
+//
+//	The writer of WithWriteThrough is called under the lock of the key once the value matches, a failed write fails the swap.
+//	The cache lock is not held while writing, the new value is cached once written even if the entry is evicted or reloaded meanwhile.
+func (c *lruCache[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	if c.closed.Load() {
+		return false
+	}
+	unlock := c.keyLocks.lock(key)
+	defer unlock()
+	c.rwlock.Lock()
+	item, ok := c.items[key]
+	if c.closed.Load() || !ok || item.expired(c.clock.Now()) || !eq(item.value, old) {
+		c.rwlock.Unlock()
+		return false
+	}
+	if c.writer != nil {
+		c.rwlock.Unlock()
+		if c.writer(key, new) != nil {
+			return false
+		}
+		c.rwlock.Lock()
+	}
+	defer c.unlock()
+	if c.closed.Load() {
+		return false
+	}
+	if err := c.insert(c.newItem(key, new, 0), false); err != nil {
+		return false
+	}
+	c.discardLoad(key, Replaced)
+	return true
+}
+
+// Remove drops the entry of key and finalizes it once no longer in use, returns whether there was an entry removed.
+//
+//	Remove always wins the race against a concurrent load of the same key: the in-flight loaded value is handed to
+//	the callers waiting for it but not cached, so no value loaded before Remove is visible after it returns.
+func (c *lruCache[K, V]) Remove(key K) bool {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.discardLoad(key, Explicit)
+	c.negatives.remove(key)
+	c.failures.remove(key)
+	item, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.removeItem(item, Explicit)
+	return true
+}
+
+func (c *lruCache[K, V]) GetAndRemove(key K) (V, bool) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	item, ok := c.items[key]
+	if !ok || item.expired(c.clock.Now()) {
+		c.stats.misses.Inc()
+		var zero V
+		return zero, false
+	}
+	c.stats.hits.Inc()
+	c.discardLoad(key, Explicit)
+	c.removeItem(item, Explicit)
+	return item.value, true
+}
+
+// Pin protects the entry of key from eviction, pins are counted by key so they survive reloads and replacements of the value.
+//
+//	A key could be pinned before it is cached, an entry pinned is still dropped by Remove and Clear.
+func (c *lruCache[K, V]) Pin(key K) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.pins[key]++
+}
+
+func (c *lruCache[K, V]) Unpin(key K) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	if c.pins[key] <= 1 {
+		delete(c.pins, key)
+		c.notifySpaceFreed()
+		return
+	}
+	c.pins[key]--
+}
+
+func (c *lruCache[K, V]) InvalidateIf(pred func(key K, value V) bool) int {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	now := c.clock.Now()
+	items := make([]*cacheItem[K, V], 0)
+	c.evictor.victims(func(n *policyNode) bool {
+		item := n.owner.(*cacheItem[K, V])
+		// expired entries are not live, they are left to be removed as Expired
+		if !item.expired(now) && pred(item.key, item.value) {
+			items = append(items, item)
+		}
+		return true
+	})
+	for _, item := range items {
+		c.removeItem(item, Explicit)
+	}
+	return len(items)
+}
+
+// Clear drops all entries and finalizes them in eviction order, returns the combined finalizer errors.
+//
+//	The lock is held until all entries are finalized, so concurrent Do waits and never sees a half finalized cache.
+//	Entries pinned by running Do are finalized once unpinned, their finalizer errors are not returned.
+func (c *lruCache[K, V]) Clear() error {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	return c.lockfreeClear()
+}
+
+func (c *lruCache[K, V]) lockfreeClear() error {
+	for key := range c.loads {
+		c.discardLoad(key, Explicit)
+	}
+	c.negatives.clear()
+	c.failures.clear()
+
+	items := make([]*cacheItem[K, V], 0, len(c.items))
+	c.evictor.victims(func(n *policyNode) bool {
+		items = append(items, n.owner.(*cacheItem[K, V]))
+		return true
+	})
+	errs := make([]error, 0)
+	for _, item := range items {
+		errs = append(errs, c.removeItem(item, Explicit))
+	}
+	return merr.Combine(errs...)
+}
+
+func (c *lruCache[K, V]) newItem(key K, value V, ttl time.Duration) *cacheItem[K, V] {
+	item := newCacheItem[K, V](key, value)
+	if c.namespacer != nil {
+		item.namespace = c.namespacer(key)
+	}
+	if c.weigher != nil {
+		item.cost = c.weigher(key, value)
+	} else {
+		item.cost = c.scavenger.weight(key)
+	}
+	if ttl <= 0 || (c.ttl > 0 && c.ttl < ttl) {
+		ttl = c.ttl
+	}
+	if ttl > 0 && c.ttlJitter > 0 {
+		ttl += time.Duration(float64(ttl) * c.ttlJitter * (2*c.random() - 1))
+		if ttl <= 0 {
+			// Expires at once rather than never.
+			ttl = 1
+		}
+	}
+	now := c.clock.Now()
+	item.insertedAt, item.lastAccess = now, now
+	if ttl > 0 {
+		item.deadline = now.Add(ttl)
+	}
+	item.expireAt = item.deadline
+	c.slide(item, now)
+	return item
+}
+
+// slide renews the expiry of item by the sliding ttl, never beyond its deadline.
+func (c *lruCache[K, V]) slide(item *cacheItem[K, V], now time.Time) {
+	if c.slidingTTL <= 0 {
+		return
+	}
+	expireAt := now.Add(c.slidingTTL)
+	if !item.deadline.IsZero() && item.deadline.Before(expireAt) {
+		expireAt = item.deadline
+	}
+	item.expireAt = expireAt
+	c.expiry.fix(item)
+}
+
+// access records a hit of item for the policy and its info, the caller must hold the lock.
+func (c *lruCache[K, V]) access(item *cacheItem[K, V], now time.Time) {
+	item.lastAccess = now
+	item.accesses++
+	c.evictor.access(&item.node)
+}
+
+// evictable tells whether item could be evicted, i.e. it is neither pinned nor vetoed, the caller must hold the lock.
+func (c *lruCache[K, V]) evictable(item *cacheItem[K, V]) bool {
+	if item.pinCount.Load() > 0 || c.pins[item.key] > 0 {
+		return false
+	}
+	return c.veto == nil || !c.veto(item.key, item.value)
+}
+
+// recordAccess counts an access of key for the admission policy, the caller must hold the lock.
+func (c *lruCache[K, V]) recordAccess(key K) {
+	if c.admitter != nil {
+		c.admitter.record(hashKey(c.seed, key))
+	}
+}
+
+// admit tells whether the new item is admitted at the cost of evicting the victims.
+func (c *lruCache[K, V]) admit(item *cacheItem[K, V], victims []*cacheItem[K, V]) bool {
+	hashes := make([]uint64, 0, len(victims))
+	for _, victim := range victims {
+		hashes = append(hashes, hashKey(c.seed, victim.key))
+	}
+	return c.admitter.admit(hashKey(c.seed, item.key), hashes)
+}
+
+// insert puts the item into cache, replacing the existing item of the same key if any, the caller must hold the lock.
+//
+//	A new key evicting others is subject to the admission policy if admission is set.
+func (c *lruCache[K, V]) insert(item *cacheItem[K, V], admission bool) error {
+	// The replaced item gives back its space before scavenging.
+	old, replace := c.items[item.key]
+	c.evictIdle(reapBatch, old)
+	if replace {
+		c.scavenger.throwWeight(old.cost)
+		c.namespaces.add(old.namespace, -old.cost)
+	}
+
+	toEvict, ok := c.lockfreeTryScavenge(item.key, item.cost)
+	if !ok || len(toEvict) > 0 {
+		c.stats.hardLimitHits.Inc()
+	}
+	if !ok {
+		if replace {
+			// Restore unconditionally, the usage may be above the capacity after Resize.
+			c.scavenger.size += old.cost
+			c.namespaces.add(old.namespace, old.cost)
+		}
+		if c.byteSized && item.cost > c.scavenger.capacity {
+			return &tooLargeError{size: item.cost, capacity: c.scavenger.capacity}
+		}
+		return ErrNotEnoughSpace
+	}
+	if admission && !replace && len(toEvict) > 0 && c.admitter != nil && !c.admit(item, toEvict) {
+		return errNotAdmitted
+	}
+
+	if replace {
+		c.detach(old, c.removalCause(old, Replaced))
+	}
+	for _, evictItem := range toEvict {
+		if c.evictBatch > 1 {
+			// Pinned till the lock is released, so that it is finalized by unlock.
+			evictItem.pinCount.Inc()
+			c.victims = append(c.victims, evictItem)
+		}
+		c.removeItem(evictItem, c.removalCause(evictItem, Evicted))
+		c.stats.evictions.Inc()
+	}
+
+	c.scavenger.collectWeight(item.cost)
+	c.namespaces.add(item.namespace, item.cost)
+	c.signalTrim()
+	item.node.cost = item.cost
+	c.insertSeq++
+	item.seq = c.insertSeq
+	if _, ok := c.evictor.(keyedEvictor); ok {
+		item.node.hash = hashKey(c.seed, item.key)
+	}
+	c.evictor.add(&item.node)
+	c.expiry.push(item)
+	if c.tagger != nil {
+		item.tags = c.tagger(item.key, item.value)
+		c.tags.add(item.key, item.tags)
+	}
+	c.items[item.key] = item
+	return nil
+}
+
+// takeVictims returns the victims evicted by batch whose finalization is deferred, the caller must hold the lock.
+func (c *lruCache[K, V]) takeVictims() []*cacheItem[K, V] {
+	victims := c.victims
+	c.victims = nil
+	return victims
+}
+
+// finalizeVictims finalizes the victims taken by takeVictims, the caller must not hold the lock.
+func (c *lruCache[K, V]) finalizeVictims(victims []*cacheItem[K, V]) {
+	for _, item := range victims {
+		c.unpin(item)
+	}
+}
+
+// unlock releases the lock, and then finalizes the victims evicted by batch meanwhile.
+func (c *lruCache[K, V]) unlock() {
+	victims := c.takeVictims()
+	c.rwlock.Unlock()
+	c.finalizeVictims(victims)
+}
+
+// removalCause returns Expired for an expired item, or else the given cause.
+func (c *lruCache[K, V]) removalCause(item *cacheItem[K, V], cause RemovalCause) RemovalCause {
+	if item.expired(c.clock.Now()) {
+		return Expired
+	}
+	return cause
+}
+
+// removeItem drops an item from the cache and releases it, the caller must hold the lock.
+func (c *lruCache[K, V]) removeItem(item *cacheItem[K, V], cause RemovalCause) error {
+	c.scavenger.throwWeight(item.cost)
+	c.namespaces.add(item.namespace, -item.cost)
+	c.notifySpaceFreed()
+	return c.detach(item, cause)
+}
+
+// detach drops an item whose space is already given back, the caller must hold the lock.
+func (c *lruCache[K, V]) detach(item *cacheItem[K, V], cause RemovalCause) error {
+	delete(c.items, item.key)
+	item.node.evicted = cause == Evicted
+	c.evictor.remove(&item.node)
+	c.expiry.remove(item)
+	c.tags.remove(item.key, item.tags)
+	return c.release(item, cause)
 }
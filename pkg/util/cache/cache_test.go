@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"context"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/assert"
@@ -75,6 +78,170 @@ func TestLRUCache(t *testing.T) {
 		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}, finalizeSeq)
 	})
 
+	t.Run("test weigher", func(t *testing.T) {
+		finalizeSeq := make([]string, 0)
+		cache := NewCacheBuilder[int, string]().WithLoader(func(key int) (string, bool) {
+			return strings.Repeat("x", key), true
+		}).WithFinalizer(func(key int, value string) error {
+			finalizeSeq = append(finalizeSeq, value)
+			return nil
+		}).WithWeigher(func(key int, value string) int64 {
+			return int64(len(value))
+		}).WithCapacity(10).Build()
+
+		for _, i := range []int{3, 4, 2} {
+			assert.NoError(t, cache.Do(i, func(v string) error { return nil }))
+		}
+		assert.EqualValues(t, 9, cache.Usage())
+		// 3 and 4 are evicted in lru order to make room for 5
+		assert.NoError(t, cache.Do(5, func(v string) error { return nil }))
+		assert.Equal(t, []string{"xxx", "xxxx"}, finalizeSeq)
+		assert.EqualValues(t, 7, cache.Usage())
+
+		// an entry heavier than capacity never fits
+		assert.Equal(t, ErrNotEnoughSpace, cache.Do(11, func(v string) error { return nil }))
+		assert.Equal(t, []string{"xxx", "xxxx", "xxxxxxxxxxx"}, finalizeSeq)
+		assert.Equal(t, ErrNotEnoughSpace, cache.Put(11, strings.Repeat("x", 11)))
+		assert.EqualValues(t, 7, cache.Usage())
+	})
+
+	t.Run("test weigher replace", func(t *testing.T) {
+		causes := make(map[int]RemovalCause)
+		cache := NewCacheBuilder[int, string]().WithEvictionListener(func(key int, value string, cause RemovalCause) {
+			causes[key] = cause
+		}).WithWeigher(func(key int, value string) int64 {
+			return int64(len(value))
+		}).WithCapacity(10).Build()
+
+		for i := 1; i <= 4; i++ {
+			assert.NoError(t, cache.Put(i, "xx"))
+		}
+		assert.EqualValues(t, 8, cache.Usage())
+
+		// the grown value evicts others in lru order
+		assert.NoError(t, cache.Put(4, "xxxxx"))
+		assert.Equal(t, map[int]RemovalCause{1: Evicted, 4: Replaced}, causes)
+		assert.EqualValues(t, 9, cache.Usage())
+		assert.True(t, cache.CompareAndSwap(4, "xxxxx", "xxxxxxxx", func(a, b string) bool { return a == b }))
+		assert.Equal(t, map[int]RemovalCause{1: Evicted, 2: Evicted, 4: Replaced}, causes)
+		assert.EqualValues(t, 10, cache.Usage())
+		assert.Equal(t, 2, cache.Len())
+
+		// the shrunk value gives back the room
+		assert.NoError(t, cache.Put(4, "x"))
+		assert.EqualValues(t, 3, cache.Usage())
+		assert.EqualValues(t, 3, cache.TotalCost())
+		cost, ok := cache.GetCost(4)
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, cost)
+		cost, _ = cache.GetCost(3)
+		assert.EqualValues(t, 2, cost)
+		_, ok = cache.GetCost(1)
+		assert.False(t, ok)
+
+		// a value outgrowing the capacity is not swapped in
+		assert.False(t, cache.CompareAndSwap(4, "x", strings.Repeat("x", 11), func(a, b string) bool { return a == b }))
+		assert.EqualValues(t, 3, cache.Usage())
+	})
+
+	t.Run("test eviction batch", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		var cache Cache[int, string]
+		cache = NewCacheBuilder[int, string]().WithLoader(func(key int) (string, bool) {
+			return strings.Repeat("x", key), true
+		}).WithFinalizer(func(key int, value string) error {
+			// finalized out of the lock
+			assert.True(t, cache.Len() > 0)
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithWeigher(func(key int, value string) int64 {
+			return int64(len(value))
+		}).WithCapacity(10).WithEvictionBatch(4).Build()
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Put(i+100, "x"))
+		}
+		// rounded up to the batch
+		assert.NoError(t, cache.Do(1, func(v string) error { return nil }))
+		assert.Equal(t, []int{100, 101, 102, 103}, finalizeSeq)
+		assert.EqualValues(t, 7, cache.Usage())
+		// no eviction while there is room
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Put(i+200, "x"))
+		}
+		assert.Len(t, finalizeSeq, 4)
+		// never beyond what is needed if it is more than the batch
+		assert.NoError(t, cache.Put(300, strings.Repeat("x", 6)))
+		assert.Equal(t, []int{100, 101, 102, 103, 104, 105, 106, 107, 108, 109}, finalizeSeq)
+		assert.EqualValues(t, 10, cache.Usage())
+		assert.EqualValues(t, 10, cache.Stats().Evictions)
+	})
+
+	t.Run("test acquire", func(t *testing.T) {
+		finalized := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, key >= 0
+		}).WithFinalizer(func(key, value int) error {
+			finalized = append(finalized, value)
+			return nil
+		}).WithCapacity(2).Build()
+
+		v, release, err := cache.Acquire(1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, v)
+		_, release2, err := cache.Acquire(1)
+		assert.NoError(t, err)
+
+		// borrowed entries are not evicted
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, []int{2}, finalized)
+
+		// a replaced entry gives back its capacity, but is finalized after the last release
+		assert.NoError(t, cache.Put(1, 10))
+		assert.EqualValues(t, 2, cache.Usage())
+		assert.Equal(t, []int{2}, finalized)
+		release()
+		release()
+		assert.Equal(t, []int{2}, finalized)
+		release2()
+		assert.Equal(t, []int{2, 1}, finalized)
+
+		_, release, err = cache.Acquire(-1)
+		assert.ErrorIs(t, err, ErrNoSuchItem)
+		release()
+	})
+
+	t.Run("test copier", func(t *testing.T) {
+		for name, copier := range map[string]func([]int) []int{
+			"shared": nil,
+			"copied": func(v []int) []int { return append([]int(nil), v...) },
+		} {
+			cache := NewCacheBuilder[int, []int]().WithLoader(func(key int) ([]int, bool) {
+				return []int{key}, true
+			}).WithCopier(copier).Build()
+			mutate := func(v []int) error {
+				v[0] = -1
+				return nil
+			}
+			assert.NoError(t, cache.Do(1, mutate))
+			assert.NoError(t, cache.DoMany([]int{2}, func(values map[int][]int) error { return mutate(values[2]) }))
+			v, _ := cache.GetIfPresent(3)
+			assert.Nil(t, v)
+			assert.NoError(t, cache.Do(3, func(v []int) error { return nil }))
+			v, _ = cache.GetIfPresent(3)
+			mutate(v)
+
+			expected := map[string][]int{"shared": {-1, -1, -1}, "copied": {1, 2, 3}}[name]
+			for i, key := range []int{1, 2, 3} {
+				assert.NoError(t, cache.Do(key, func(v []int) error {
+					assert.Equal(t, expected[i], v[0], name)
+					return nil
+				}))
+			}
+		}
+	})
+
 	t.Run("test do negative", func(t *testing.T) {
 		cache := cacheBuilder.Build()
 		theErr := errors.New("error")
@@ -84,45 +251,1996 @@ func TestLRUCache(t *testing.T) {
 		assert.Equal(t, theErr, err)
 	})
 
-	t.Run("test scavenge negative", func(t *testing.T) {
+	t.Run("test scavenge negative", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		sumCapacity := 20 // inserting 1 to 19, capacity is set to sum of 20, expecting (19) at last.
+		cache := cacheBuilder.WithLazyScavenger(func(key int) int64 {
+			return int64(key)
+		}, int64(sumCapacity)).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).Build()
+
+		for i := 0; i < 20; i++ {
+			err := cache.Do(i, func(v int) error {
+				assert.Equal(t, i, v)
+				return nil
+			})
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}, finalizeSeq)
+		err := cache.Do(100, func(v int) error {
+			return nil
+		})
+		assert.Equal(t, ErrNotEnoughSpace, err)
+	})
+
+	t.Run("test get if present", func(t *testing.T) {
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).Build()
+
+		v, ok := cache.GetIfPresent(1)
+		assert.False(t, ok)
+		assert.Equal(t, 0, v)
+		assert.Equal(t, 0, loadCnt)
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		v, ok = cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 1, v)
+		assert.Equal(t, 1, loadCnt)
+	})
+
+	t.Run("test get or default", func(t *testing.T) {
+		clock := newFakeClock()
+		for _, readMostly := range []bool{false, true} {
+			builder := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithTTL(time.Second).WithClock(clock)
+			if readMostly {
+				builder = builder.WithReadMostly()
+			}
+			cache := builder.Build()
+
+			assert.Equal(t, -1, cache.GetOrDefault(1, -1))
+			assert.False(t, cache.Contains(1))
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+			assert.Equal(t, 1, cache.GetOrDefault(1, -1))
+			assert.EqualValues(t, 1, cache.Stats().Hits)
+
+			clock.Advance(time.Second)
+			assert.Equal(t, -1, cache.GetOrDefault(1, -1))
+		}
+	})
+
+	t.Run("test put", func(t *testing.T) {
+		loadCnt := 0
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, value)
+			return nil
+		}).WithCapacity(2).Build()
+
+		assert.NoError(t, cache.Put(1, 10))
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 10, v)
+			return nil
+		}))
+		assert.Equal(t, 0, loadCnt)
+
+		// replace
+		assert.NoError(t, cache.Put(1, 11))
+		assert.Equal(t, []int{10}, finalizeSeq)
+		v, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 11, v)
+
+		// evict
+		assert.NoError(t, cache.Put(2, 20))
+		assert.NoError(t, cache.Put(3, 30))
+		assert.Equal(t, []int{10, 11}, finalizeSeq)
+	})
+
+	t.Run("test compare and swap", func(t *testing.T) {
+		removals := make([]removal, 0)
+		cache := NewCacheBuilder[int, int]().WithEvictionListener(func(key, value int, cause RemovalCause) {
+			removals = append(removals, removal{key, value, cause})
+		}).Build()
+		eq := func(a, b int) bool { return a == b }
+
+		assert.False(t, cache.CompareAndSwap(1, 0, 10, eq))
+		assert.Equal(t, 0, cache.Len())
+		assert.NoError(t, cache.Put(1, 10))
+		assert.False(t, cache.CompareAndSwap(1, 11, 12, eq))
+		assert.True(t, cache.CompareAndSwap(1, 10, 11, eq))
+		v, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 11, v)
+		assert.Equal(t, []removal{{1, 10, Replaced}}, removals)
+
+		// only one of concurrent swaps from the same value wins
+		var wg sync.WaitGroup
+		swapped := new(atomic.Int32)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if cache.CompareAndSwap(1, 11, 100+i, eq) {
+					swapped.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, swapped.Load())
+	})
+
+	t.Run("test put negative", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLazyScavenger(func(key int) int64 {
+			return int64(key)
+		}, 10).Build()
+
+		assert.NoError(t, cache.Put(5, 5))
+		assert.Equal(t, ErrNotEnoughSpace, cache.Put(11, 11))
+		err := cache.Do(5, func(v int) error {
+			assert.Equal(t, 5, v)
+			// key 5 is pinned, there is no room for key 6
+			assert.Equal(t, ErrNotEnoughSpace, cache.Put(6, 6))
+			// replacing key 5 is fine
+			assert.NoError(t, cache.Put(5, 50))
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("test write through", func(t *testing.T) {
+		store := make(map[int]int)
+		writeErr := errors.New("write error")
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithWriteThrough(func(key, value int) error {
+			if value < 0 {
+				return writeErr
+			}
+			store[key] = value
+			return nil
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, value)
+			return nil
+		}).Build()
+
+		assert.NoError(t, cache.Put(1, 10))
+		assert.Equal(t, map[int]int{1: 10}, store)
+		v, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 10, v)
+
+		// failed write leaves both unchanged
+		assert.ErrorIs(t, cache.Put(1, -1), writeErr)
+		assert.ErrorIs(t, cache.Put(2, -1), writeErr)
+		assert.Equal(t, map[int]int{1: 10}, store)
+		v, ok = cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 10, v)
+		_, ok = cache.GetIfPresent(2)
+		assert.False(t, ok)
+		assert.Equal(t, 1, cache.Len())
+		assert.Empty(t, finalizeSeq)
+	})
+
+	t.Run("test remove", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(1).Build()
+
+		assert.False(t, cache.Remove(1))
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.True(t, cache.Remove(1))
+		assert.Equal(t, []int{1}, finalizeSeq)
+		_, ok := cache.GetIfPresent(1)
+		assert.False(t, ok)
+
+		// the space is given back
+		assert.NoError(t, cache.Do(2, func(v int) error {
+			// removing a pinned entry defers finalizing
+			assert.True(t, cache.Remove(2))
+			assert.Equal(t, []int{1}, finalizeSeq)
+			return nil
+		}))
+		assert.Equal(t, []int{1, 2}, finalizeSeq)
+	})
+
+	t.Run("test get and remove", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(1).Build()
+
+		_, ok := cache.GetAndRemove(1)
+		assert.False(t, ok)
+		assert.Equal(t, 0, loadCnt)
+		assert.NoError(t, cache.Put(1, 10))
+		v, ok := cache.GetAndRemove(1)
+		assert.True(t, ok)
+		assert.Equal(t, 10, v)
+		assert.Equal(t, []int{1}, finalizeSeq)
+		assert.EqualValues(t, 0, cache.Usage())
+
+		// exactly one of concurrent callers gets the value
+		cache.Put(2, 20)
+		var wg sync.WaitGroup
+		got := new(atomic.Int32)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, ok := cache.GetAndRemove(2); ok {
+					got.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, got.Load())
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("test invalidate if", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(10).Build()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+
+		older := func(key, value int) bool { return value < 5 }
+		err := cache.Do(0, func(v int) error {
+			// a pinned entry is finalized once unpinned
+			assert.Equal(t, 5, cache.InvalidateIf(older))
+			assert.Equal(t, []int{1, 2, 3, 4}, finalizeSeq)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 0}, finalizeSeq)
+		assert.Equal(t, 0, cache.InvalidateIf(older))
+		assert.Equal(t, 5, cache.Len())
+	})
+
+	t.Run("test invalidate if expired", func(t *testing.T) {
+		clock := newFakeClock()
+		removals := make([]removal, 0)
+		cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			if key == 0 {
+				return key, time.Second, true
+			}
+			return key, 0, true
+		}).WithEvictionListener(func(key, value int, cause RemovalCause) {
+			removals = append(removals, removal{key, value, cause})
+		}).WithCapacity(10).WithClock(clock).Build()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		clock.Advance(2 * time.Second)
+
+		keys := make([]int, 0)
+		assert.Equal(t, 2, cache.InvalidateIf(func(key, value int) bool {
+			keys = append(keys, key)
+			return true
+		}))
+		// expired 0 is not passed to pred, nor removed as Explicit
+		assert.Equal(t, []int{1, 2}, keys)
+		assert.Equal(t, []removal{{1, 1, Explicit}, {2, 2, Explicit}}, removals)
+	})
+
+	t.Run("test remove while loading", func(t *testing.T) {
+		loading := make(chan struct{})
+		removed := make(chan struct{})
+		finalized := make(chan int, 1)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			close(loading)
+			<-removed
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- key
+			return nil
+		}).Build()
+
+		go func() {
+			<-loading
+			assert.False(t, cache.Remove(1))
+			close(removed)
+		}()
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		assert.Equal(t, 1, <-finalized)
+		_, ok := cache.GetIfPresent(1)
+		assert.False(t, ok)
+	})
+
+	t.Run("test pin", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(2).Build()
+
+		assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+		cache.Pin(0)
+		cache.Pin(0)
+		for i := 1; i < 4; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, []int{1, 2}, finalizeSeq)
+
+		// the pin survives replacement
+		assert.NoError(t, cache.Put(0, 100))
+		cache.Pin(3)
+		assert.Equal(t, ErrNotEnoughSpace, cache.Do(4, func(v int) error { return nil }))
+
+		cache.Unpin(0)
+		assert.Equal(t, ErrNotEnoughSpace, cache.Do(4, func(v int) error { return nil }))
+		cache.Unpin(0)
+		assert.NoError(t, cache.Do(4, func(v int) error { return nil }))
+		assert.Equal(t, []int{1, 2, 0, 0}, finalizeSeq)
+	})
+
+	t.Run("test clear", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			if key < 4 && key%2 == 0 {
+				return errors.Newf("failed to finalize %d", key)
+			}
+			return nil
+		}).WithCapacity(4).Build()
+
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		err := cache.Clear()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to finalize 0")
+		assert.Contains(t, err.Error(), "failed to finalize 2")
+		assert.Equal(t, []int{0, 1, 2, 3}, finalizeSeq)
+		for i := 0; i < 4; i++ {
+			_, ok := cache.GetIfPresent(i)
+			assert.False(t, ok)
+		}
+
+		// still usable with full capacity
+		for i := 4; i < 8; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, []int{0, 1, 2, 3}, finalizeSeq)
+		assert.NoError(t, cache.Clear())
+	})
+
+	t.Run("test len and usage", func(t *testing.T) {
+		cache := cacheBuilder.WithLazyScavenger(func(key int) int64 {
+			return int64(key)
+		}, 10).Build()
+
+		assert.Equal(t, 0, cache.Len())
+		assert.EqualValues(t, 0, cache.Usage())
+		for i := 1; i <= 4; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 4, cache.Len())
+		assert.EqualValues(t, 10, cache.Usage())
+		// 1, 2 and 3 are evicted
+		assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+		assert.Equal(t, 2, cache.Len())
+		assert.EqualValues(t, 9, cache.Usage())
+
+		cache = cacheBuilder.WithCapacity(10).Build()
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 10, cache.Len())
+		assert.EqualValues(t, 10, cache.Usage())
+
+		// concurrent loads
+		cache = cacheBuilder.WithCapacity(10).Build()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					assert.NoError(t, cache.Do(j, func(v int) error { return nil }))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 10, cache.Len())
+		assert.EqualValues(t, 10, cache.Usage())
+
+		// expired entries are not counted, though they occupy until reclaimed
+		clock := newFakeClock()
+		cache = NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			if key < 2 {
+				return key, time.Second, true
+			}
+			return key, 0, true
+		}).WithCapacity(10).WithClock(clock).Build()
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 4, cache.Len())
+		clock.Advance(2 * time.Second)
+		assert.Equal(t, 2, cache.Len())
+		assert.EqualValues(t, 4, cache.Usage())
+	})
+
+	t.Run("test error loader", func(t *testing.T) {
+		errTimeout := errors.New("timeout")
+		fail := true
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			loadCnt++
+			if key < 0 {
+				return 0, ErrNoSuchItem
+			}
+			if fail {
+				return 0, errTimeout
+			}
+			return key, nil
+		}).Build()
+
+		assert.Equal(t, ErrNoSuchItem, cache.Do(-1, func(v int) error { return nil }))
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), errTimeout)
+		// failure is not cached
+		fail = false
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		assert.Equal(t, 3, loadCnt)
+	})
+
+	t.Run("test loader chain", func(t *testing.T) {
+		errPeer := errors.New("peer down")
+		tried := make([]string, 0)
+		local := map[int]int{1: 10}
+		origin := map[int]int{1: 100, 2: 200}
+		lookup := func(name string, store map[int]int) func(int) (int, error) {
+			return func(key int) (int, error) {
+				tried = append(tried, name)
+				if v, ok := store[key]; ok {
+					return v, nil
+				}
+				return 0, ErrNoSuchItem
+			}
+		}
+		cache := NewCacheBuilder[int, int]().WithLoaderChain(lookup("local", local), func(key int) (int, error) {
+			tried = append(tried, "peer")
+			return 0, errPeer
+		}, lookup("origin", origin)).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 10, v)
+			return nil
+		}))
+		assert.NoError(t, cache.Do(2, func(v int) error {
+			assert.Equal(t, 200, v)
+			return nil
+		}))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, []string{"local", "local", "peer", "origin"}, tried)
+
+		// the real failures are combined, a key not found anywhere else is not reported as missing
+		err := cache.Do(3, func(v int) error { return nil })
+		assert.ErrorIs(t, err, errPeer)
+		assert.NotErrorIs(t, err, ErrNoSuchItem)
+
+		cache = NewCacheBuilder[int, int]().WithLoaderChain(lookup("local", local), lookup("origin", origin)).Build()
+		assert.Equal(t, ErrNoSuchItem, cache.Do(3, func(v int) error { return nil }))
+	})
+
+	t.Run("test loader chain canceled", func(t *testing.T) {
+		loading := make(chan struct{})
+		proceed := make(chan struct{})
+		chained := make(chan struct{}, 1)
+		cache := NewCacheBuilder[int, int]().WithLoaderChain(func(key int) (int, error) {
+			close(loading)
+			<-proceed
+			return 0, ErrNoSuchItem
+		}, func(key int) (int, error) {
+			chained <- struct{}{}
+			return key, nil
+		}).Build()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-loading
+			cancel()
+		}()
+		assert.ErrorIs(t, cache.DoCtx(ctx, 1, func(v int) error { return nil }), context.Canceled)
+		// all the waiters have gone, the chain stops before the next loader
+		close(proceed)
+		select {
+		case <-chained:
+			t.Fatal("the next loader is called")
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
+	t.Run("test validator", func(t *testing.T) {
+		errTruncated := errors.New("truncated")
+		loads := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithBatchLoader(func(keys []int) (map[int]int, error) {
+			loads.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			values := make(map[int]int, len(keys))
+			for _, key := range keys {
+				values[key] = key
+			}
+			return values, nil
+		}).WithValidator(func(key, value int) error {
+			if value < 0 {
+				return errTruncated
+			}
+			return nil
+		}).Build()
+
+		// the waiters share the rejection of a single load
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.ErrorIs(t, cache.Do(-1, func(v int) error { return nil }), errTruncated)
+			}()
+		}
+		wg.Wait()
+		assert.False(t, cache.Contains(-1))
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.True(t, cache.Contains(1))
+
+		// batch loaded values are validated key by key
+		loads.Store(0)
+		err := cache.DoMany([]int{-2, 2}, func(values map[int]int) error { return nil })
+		assert.Equal(t, KeyErrors[int]{-2: errTruncated}, err)
+		assert.EqualValues(t, 1, loads.Load())
+		assert.False(t, cache.Contains(-2))
+		assert.True(t, cache.Contains(2))
+	})
+
+	t.Run("test cache when", func(t *testing.T) {
+		finalized := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCacheWhen(func(key, value int) bool {
+			return value%2 == 0
+		}).WithFinalizer(func(key, value int) error {
+			finalized = append(finalized, key)
+			return nil
+		}).WithCapacity(2).Build()
+
+		assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		// odd values are handed to the caller without taking room
+		var got int
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			got = v
+			return nil
+		}))
+		assert.Equal(t, 1, got)
+		assert.ElementsMatch(t, []int{0, 2}, cache.SnapshotKeys())
+		assert.Equal(t, []int{1}, finalized)
+
+		assert.NoError(t, cache.Put(3, 3))
+		assert.True(t, cache.Contains(3))
+	})
+
+	t.Run("test do result", func(t *testing.T) {
+		cache := cacheBuilder.WithCapacity(10).Build()
+		r, err := DoResult(cache, 2, func(v int) (string, error) {
+			return strings.Repeat("x", v), nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "xx", r)
+
+		theErr := errors.New("error")
+		_, err = DoResult(cache, 2, func(v int) (string, error) {
+			return "", theErr
+		})
+		assert.Equal(t, theErr, err)
+	})
+
+	t.Run("test range", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			if key == 0 {
+				return key, 10 * time.Millisecond, true
+			}
+			return key, 0, true
+		}).WithCapacity(10).WithClock(clock).Build()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(20 * time.Millisecond)
+
+		keys := make([]int, 0)
+		cache.Range(func(key, value int) bool {
+			assert.Equal(t, key, value)
+			keys = append(keys, key)
+			// fn is free to modify the cache
+			cache.Remove(key)
+			return true
+		})
+		// expired 0 is skipped, 1 is the most recently used
+		assert.Equal(t, []int{2, 3, 4, 1}, keys)
+		// expired 0 is not counted
+		assert.Equal(t, 0, cache.Len())
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		cnt := 0
+		cache.Range(func(key, value int) bool {
+			cnt++
+			return cnt < 2
+		})
+		assert.Equal(t, 2, cnt)
+		// entries are unpinned after iteration
+		assert.NoError(t, cache.Clear())
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("test resize", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(5).Build()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+
+		// shrink evicts in lru order, pinned entries are kept
+		cache.Pin(0)
+		cache.Resize(2)
+		assert.Equal(t, []int{1, 2, 3}, finalizeSeq)
+		assert.EqualValues(t, 2, cache.Usage())
+		assert.EqualValues(t, 3, cache.Stats().Evictions)
+		cache.Unpin(0)
+
+		// grow allows more entries without eviction
+		cache.Resize(4)
+		for i := 5; i < 7; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 4, cache.Len())
+		assert.Equal(t, []int{1, 2, 3}, finalizeSeq)
+
+		// no room for the pinned ones
+		err := cache.Do(0, func(v int) error {
+			cache.Resize(1)
+			return cache.Do(7, func(v int) error { return nil })
+		})
+		assert.Equal(t, ErrNotEnoughSpace, err)
+		assert.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("test resize below pinned usage", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithCapacity(2).Build()
+		assert.NoError(t, cache.Put(1, 1))
+		assert.NoError(t, cache.Put(2, 2))
+		cache.Pin(1)
+		cache.Pin(2)
+		cache.Resize(1)
+		assert.EqualValues(t, 2, cache.Usage())
+
+		// a failed replace keeps the usage of the old value
+		assert.Equal(t, ErrNotEnoughSpace, cache.Put(1, 10))
+		assert.Equal(t, 2, cache.Len())
+		assert.EqualValues(t, 2, cache.Usage())
+		value, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+	})
+
+	t.Run("test eviction veto", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		vetoed := map[int]bool{1: true}
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithEvictionVeto(func(key, value int) bool {
+			return vetoed[key]
+		}).WithCapacity(2).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		// 1 is vetoed, the next victim is evicted
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, []int{2}, finalizeSeq)
+
+		vetoed[3] = true
+		assert.Equal(t, ErrNotEnoughSpace, cache.Do(4, func(v int) error { return nil }))
+		assert.Equal(t, []int{2}, finalizeSeq)
+
+		delete(vetoed, 1)
+		assert.NoError(t, cache.Do(4, func(v int) error { return nil }))
+		assert.Equal(t, []int{2, 1}, finalizeSeq)
+	})
+
+	t.Run("test contains", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(2).Build()
+
+		assert.False(t, cache.Contains(1))
+		assert.Equal(t, 0, cache.Len())
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		cache.ResetStats()
+
+		assert.True(t, cache.Contains(1))
+		assert.Equal(t, Stats{}, cache.Stats())
+		// 1 is still the least recently used
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, []int{1}, finalizeSeq)
+		assert.False(t, cache.Contains(1))
+	})
+
+	t.Run("test panic", func(t *testing.T) {
+		theErr := errors.New("error")
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			switch key {
+			case 1:
+				panic("loader")
+			case 2:
+				panic(theErr)
+			}
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			if key == 3 {
+				panic("finalizer")
+			}
+			return nil
+		}).WithCapacity(2).Build()
+
+		for i := 0; i < 2; i++ {
+			err := cache.Do(1, func(v int) error { return nil })
+			assert.ErrorIs(t, err, ErrPanicked)
+			assert.Contains(t, err.Error(), "loader")
+		}
+		err := cache.Do(2, func(v int) error { return nil })
+		assert.ErrorIs(t, err, theErr)
+		assert.True(t, errors.Is(err, ErrPanicked))
+		var panicErr *PanicError
+		assert.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, theErr, panicErr.Value)
+		assert.EqualValues(t, 3, cache.Stats().LoadFailures)
+
+		// the cache is still usable
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(4, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+		assert.Equal(t, 2, cache.Len())
+		assert.NoError(t, cache.Put(3, 3))
+		assert.ErrorIs(t, cache.Clear(), ErrPanicked)
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("test close", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		theErr := errors.New("error")
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			if key == 2 {
+				return theErr
+			}
+			return nil
+		}).WithActiveExpiration(time.Millisecond).Build()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+
+		assert.ErrorIs(t, cache.Close(), theErr)
+		// finalized in lru order
+		assert.Equal(t, []int{1, 2, 0}, finalizeSeq)
+		assert.Equal(t, 0, cache.Len())
+		assert.Equal(t, ErrCacheClosed, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, ErrCacheClosed, cache.Put(1, 1))
+		assert.Equal(t, ErrCacheClosed, cache.DoMany([]int{1}, func(map[int]int) error { return nil }))
+		assert.NoError(t, cache.Close())
+		assert.Equal(t, []int{1, 2, 0}, finalizeSeq)
+	})
+
+	t.Run("test load observer", func(t *testing.T) {
+		clock := newFakeClock()
+		durations := make([]time.Duration, 0)
+		errs := make([]error, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			clock.Advance(time.Duration(key) * time.Millisecond)
+			return key, key > 0
+		}).WithLoadObserver(func(d time.Duration, err error) {
+			durations = append(durations, d)
+			errs = append(errs, err)
+		}).WithClock(clock).Build()
+
+		assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+		assert.Equal(t, ErrNoSuchItem, cache.Do(0, func(v int) error { return nil }))
+		assert.Equal(t, []time.Duration{5 * time.Millisecond, 0}, durations)
+		assert.Equal(t, []error{nil, ErrNoSuchItem}, errs)
+	})
+
+	t.Run("test load negative", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			if key < 0 {
+				return 0, false
+			}
+			return key, true
+		}).Build()
+		err := cache.Do(0, func(v int) error {
+			return nil
+		})
+		assert.NoError(t, err)
+		err = cache.Do(-1, func(v int) error {
+			return nil
+		})
+		assert.Equal(t, ErrNoSuchItem, err)
+	})
+
+	t.Run("test second level", func(t *testing.T) {
+		loadCnt := 0
+		l2Finalized := make([]int, 0)
+		l2 := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, key < 10
+		}).WithFinalizer(func(key, value int) error {
+			l2Finalized = append(l2Finalized, key)
+			return nil
+		}).WithCapacity(4).Build()
+
+		l1Loaded := make([]int, 0)
+		l1Finalized := make([]int, 0)
+		l1 := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			l1Loaded = append(l1Loaded, key)
+			return key, key < 20
+		}).WithFinalizer(func(key, value int) error {
+			l1Finalized = append(l1Finalized, key)
+			return nil
+		}).WithSecondLevel(l2).WithCapacity(2).Build()
+
+		for _, key := range []int{1, 2, 3, 1} {
+			assert.NoError(t, l1.Do(key, func(v int) error {
+				assert.Equal(t, key, v)
+				return nil
+			}))
+		}
+		// 1 was evicted from l1 by 3 and promoted again from l2 without loading
+		assert.Equal(t, 3, loadCnt)
+		assert.Equal(t, []int{1, 2}, l1Finalized)
+		assert.Empty(t, l2Finalized)
+		assert.Equal(t, 2, l1.Len())
+		assert.Equal(t, 3, l2.Len())
+
+		// the loader of l1 is the fallback of items missing in l2
+		assert.NoError(t, l1.Do(15, func(v int) error { return nil }))
+		assert.Equal(t, []int{15}, l1Loaded)
+		assert.Equal(t, ErrNoSuchItem, l1.Do(25, func(v int) error { return nil }))
+		assert.Equal(t, 3, l2.Len())
+	})
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	t.Run("test expire", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := 0
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key + loadCnt, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, value)
+			return nil
+		}).WithTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+		err := cache.Do(1, func(v int) error {
+			assert.Equal(t, 2, v)
+			return nil
+		})
+		assert.NoError(t, err)
+		err = cache.Do(1, func(v int) error {
+			assert.Equal(t, 2, v)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, loadCnt)
+
+		clock.Advance(60 * time.Millisecond)
+		err = cache.Do(1, func(v int) error {
+			assert.Equal(t, 3, v)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, loadCnt)
+		assert.Equal(t, []int{2}, finalizeSeq)
+	})
+
+	t.Run("test per-entry ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := make(map[int]int)
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			loadCnt[key]++
+			// key 0 uses default ttl, key 1 expires soon, key 2 is bounded by the default ttl.
+			ttls := []time.Duration{0, 30 * time.Millisecond, time.Hour}
+			return key, ttls[key], true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithTTL(100 * time.Millisecond).WithClock(clock).Build()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		clock.Advance(50 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 1}, loadCnt)
+		assert.Equal(t, []int{1}, finalizeSeq)
+
+		clock.Advance(70 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, map[int]int{0: 2, 1: 3, 2: 2}, loadCnt)
+		assert.Equal(t, []int{1, 0, 1, 2}, finalizeSeq)
+	})
+
+	t.Run("test refresh ahead", func(t *testing.T) {
+		clock := newFakeClock()
+		version := new(atomic.Int32)
+		fail := new(atomic.Bool)
+		refreshed := make(chan struct{}, 1)
+		finalized := make(chan int, 10)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			defer func() {
+				select {
+				case refreshed <- struct{}{}:
+				default:
+				}
+			}()
+			if fail.Load() {
+				return 0, false
+			}
+			return int(version.Add(1)), true
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- value
+			return nil
+		}).WithTTL(200 * time.Millisecond).WithRefreshAhead(150 * time.Millisecond).WithClock(clock).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		<-refreshed
+		clock.Advance(60 * time.Millisecond)
+		// the current value is served, and refreshed in background
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		<-refreshed
+		assert.Eventually(t, func() bool {
+			v, _ := cache.GetIfPresent(1)
+			return v == 2
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 1, <-finalized)
+
+		// failed refresh keeps the current value
+		fail.Store(true)
+		clock.Advance(60 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 2, v)
+			return nil
+		}))
+		<-refreshed
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 2, v)
+			return nil
+		}))
+		assert.EqualValues(t, 2, version.Load())
+	})
+
+	t.Run("test conditional refresh", func(t *testing.T) {
+		clock := newFakeClock()
+		var mu sync.Mutex
+		loadCnt := make(map[int]int)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			loadCnt[key]++
+			return key, true
+		}).WithTTL(200 * time.Millisecond).WithRefreshAhead(150 * time.Millisecond).WithConditionalRefresh(2).
+			WithClock(clock).Build()
+		loads := func(key int) int {
+			mu.Lock()
+			defer mu.Unlock()
+			return loadCnt[key]
+		}
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		clock.Advance(60 * time.Millisecond)
+		// the third hit of 1 makes it hot enough
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		}
+		assert.Eventually(t, func() bool { return loads(1) == 2 }, time.Second, time.Millisecond)
+
+		// 2 is cold, it expires without refreshed
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, 1, loads(2))
+		clock.Advance(140 * time.Millisecond)
+		assert.False(t, cache.Contains(2))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, 2, loads(2))
+	})
+
+	t.Run("test ttl jitter", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := make(map[int]int)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt[key]++
+			return key, true
+		}).WithTTL(100 * time.Millisecond).WithTTLJitter(0.5).WithClock(clock).Build()
+		shard := cache.(*lruCache[int, int])
+
+		// key 0 gets the shortest ttl, key 1 the longest
+		for i := 0; i < 2; i++ {
+			shard.random = func() float64 { return float64(i) }
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		info, _ := cache.EntryInfo(0)
+		assert.Equal(t, 50*time.Millisecond, info.ExpireAt.Sub(info.InsertedAt))
+		info, _ = cache.EntryInfo(1)
+		assert.Equal(t, 150*time.Millisecond, info.ExpireAt.Sub(info.InsertedAt))
+
+		shard.random = func() float64 { return 0.5 }
+		clock.Advance(60 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, map[int]int{0: 2, 1: 1}, loadCnt)
+		clock.Advance(100 * time.Millisecond)
+		assert.False(t, cache.Contains(1))
+	})
+
+	t.Run("test early expiration", func(t *testing.T) {
+		clock := newFakeClock()
+		version := new(atomic.Int32)
+		loaded := make(chan struct{}, 1)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			defer func() { loaded <- struct{}{} }()
+			// a load takes 10ms
+			clock.Advance(10 * time.Millisecond)
+			return int(version.Add(1)), true
+		}).WithTTL(100 * time.Millisecond).WithEarlyExpiration(1).WithClock(clock).Build()
+		random := 0.5
+		cache.(*lruCache[int, int]).random = func() float64 { return random }
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		<-loaded
+		// 10ms * -ln(0.5) is about 7ms, too far from the expiry
+		clock.Advance(90 * time.Millisecond)
+		assert.NoError(t, cache.DoWithSource(1, func(v int, source Source) error {
+			assert.Equal(t, Hit, source)
+			return nil
+		}))
+		// luckier
+		random = 0.1
+		assert.NoError(t, cache.DoWithSource(1, func(v int, source Source) error {
+			assert.Equal(t, 1, v)
+			assert.Equal(t, Refreshed, source)
+			return nil
+		}))
+		<-loaded
+		assert.Eventually(t, func() bool {
+			v, _ := cache.GetIfPresent(1)
+			return v == 2
+		}, time.Second, time.Millisecond)
+		assert.EqualValues(t, 2, version.Load())
+	})
+
+	t.Run("test stale while revalidate", func(t *testing.T) {
+		clock := newFakeClock()
+		version := new(atomic.Int32)
+		block := new(atomic.Bool)
+		unblock := make(chan struct{})
+		loaded := make(chan struct{}, 1)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			if block.Load() {
+				<-unblock
+			}
+			defer func() { loaded <- struct{}{} }()
+			return int(version.Add(1)), true
+		}).WithTTL(50 * time.Millisecond).WithStaleWhileRevalidate(100 * time.Millisecond).WithClock(clock).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		<-loaded
+
+		// stale value is served while reloading in background
+		block.Store(true)
+		clock.Advance(60 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(1, func(v int) error {
+				assert.Equal(t, 1, v)
+				return nil
+			}))
+		}
+		_, ok := cache.GetIfPresent(1)
+		assert.False(t, ok)
+		close(unblock)
+		<-loaded
+		assert.Eventually(t, func() bool {
+			v, _ := cache.GetIfPresent(1)
+			return v == 2
+		}, time.Second, time.Millisecond)
+		assert.EqualValues(t, 2, version.Load())
+
+		// out of grace, Do blocks on the load
+		block.Store(false)
+		clock.Advance(200 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 3, v)
+			return nil
+		}))
+	})
+
+	t.Run("test stale if error", func(t *testing.T) {
+		clock := newFakeClock()
+		loadErr := errors.New("load error")
+		fail := new(atomic.Bool)
+		gone := new(atomic.Bool)
+		version := 0
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			if fail.Load() {
+				return 0, loadErr
+			}
+			if gone.Load() {
+				return 0, ErrNoSuchItem
+			}
+			version++
+			return version, nil
+		}).WithTTL(50 * time.Millisecond).WithStaleIfError(100 * time.Millisecond).WithClock(clock).Build()
+
+		// no prior value
+		fail.Store(true)
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), loadErr)
+
+		fail.Store(false)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		fail.Store(true)
+		clock.Advance(60 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		assert.EqualValues(t, 2, cache.Stats().LoadFailures)
+
+		// out of the window
+		clock.Advance(100 * time.Millisecond)
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), loadErr)
+
+		fail.Store(false)
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 2, v)
+			return nil
+		}))
+
+		// not found is never served stale
+		gone.Store(true)
+		clock.Advance(60 * time.Millisecond)
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrNoSuchItem)
+	})
+
+	t.Run("test negative caching", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := 0
+		exists := false
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, exists || key == 0
+		}).WithNegativeCaching(50 * time.Millisecond).WithNegativeCapacity(2).WithClock(clock).Build()
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, ErrNoSuchItem, cache.Do(1, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 1, loadCnt)
+		assert.Equal(t, 0, cache.Len())
+
+		// tombstones expire
+		clock.Advance(60 * time.Millisecond)
+		assert.Equal(t, ErrNoSuchItem, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, 2, loadCnt)
+
+		// the oldest tombstone is dropped beyond capacity
+		assert.Equal(t, ErrNoSuchItem, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, ErrNoSuchItem, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, ErrNoSuchItem, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, 5, loadCnt)
+
+		// put and remove forget the key
+		exists = true
+		assert.NoError(t, cache.Put(1, 10))
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 10, v)
+			return nil
+		}))
+		assert.False(t, cache.Remove(3))
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, 6, loadCnt)
+	})
+
+	t.Run("test sliding ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithSlidingTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		// each hit renews the expiry
+		for i := 0; i < 5; i++ {
+			clock.Advance(40 * time.Millisecond)
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		}
+		assert.Equal(t, 1, loadCnt)
+
+		clock.Advance(50 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, 2, loadCnt)
+	})
+
+	t.Run("test sliding ttl bounded by ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithTTL(100 * time.Millisecond).WithSlidingTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(40 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(40 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, 1, loadCnt)
+		// renewed to 130ms, but the ttl ends at 100ms
+		clock.Advance(20 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, 2, loadCnt)
+	})
+
+	t.Run("test entry info", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithTTL(time.Minute).WithClock(clock).Build()
+
+		_, ok := cache.EntryInfo(1)
+		assert.False(t, ok)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(time.Second)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		_, _ = cache.GetIfPresent(1)
+		clock.Advance(time.Second)
+		cache.ResetStats()
+
+		info, ok := cache.EntryInfo(1)
+		assert.True(t, ok)
+		assert.Equal(t, Info{
+			InsertedAt: time.Unix(0, 0),
+			LastAccess: time.Unix(1, 0),
+			Accesses:   2,
+			Cost:       1,
+			ExpireAt:   time.Unix(60, 0),
+			InsertSeq:  1,
+		}, info)
+		// read only
+		assert.Equal(t, Stats{}, cache.Stats())
+		info, _ = cache.EntryInfo(1)
+		assert.EqualValues(t, 2, info.Accesses)
+
+		clock.Advance(time.Minute)
+		_, ok = cache.EntryInfo(1)
+		assert.False(t, ok)
+	})
+
+	t.Run("test touch", func(t *testing.T) {
+		clock := newFakeClock()
+		loadCnt := 0
 		finalizeSeq := make([]int, 0)
-		sumCapacity := 20 // inserting 1 to 19, capacity is set to sum of 20, expecting (19) at last.
-		cache := cacheBuilder.WithLazyScavenger(func(key int) int64 {
-			return int64(key)
-		}, int64(sumCapacity)).WithFinalizer(func(key, value int) error {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
 			finalizeSeq = append(finalizeSeq, key)
 			return nil
-		}).Build()
+		}).WithCapacity(2).WithSlidingTTL(50 * time.Millisecond).WithClock(clock).Build()
 
-		for i := 0; i < 20; i++ {
-			err := cache.Do(i, func(v int) error {
-				assert.Equal(t, i, v)
-				return nil
-			})
-			assert.NoError(t, err)
+		assert.False(t, cache.Touch(1))
+		assert.Equal(t, 0, loadCnt)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+
+		// touch renews the sliding ttl
+		clock.Advance(40 * time.Millisecond)
+		assert.True(t, cache.Touch(1))
+		clock.Advance(40 * time.Millisecond)
+		assert.True(t, cache.Touch(1))
+		assert.False(t, cache.Touch(2))
+
+		// and the recency, 2 is evicted
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, []int{2}, finalizeSeq)
+		assert.Equal(t, 3, loadCnt)
+	})
+
+	t.Run("test remaining ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			return key, time.Duration(key) * time.Millisecond, true
+		}).WithSlidingTTL(80 * time.Millisecond).WithClock(clock).Build()
+
+		_, ok := cache.TTL(1)
+		assert.False(t, ok)
+		assert.NoError(t, cache.Do(100, func(v int) error { return nil }))
+
+		ttl, ok := cache.TTL(100)
+		assert.True(t, ok)
+		assert.Equal(t, 80*time.Millisecond, ttl)
+		clock.Advance(30 * time.Millisecond)
+		ttl, _ = cache.TTL(100)
+		assert.Equal(t, 50*time.Millisecond, ttl)
+		// renewed by the hit, up to the per-entry ttl
+		assert.NoError(t, cache.Do(100, func(v int) error { return nil }))
+		ttl, _ = cache.TTL(100)
+		assert.Equal(t, 70*time.Millisecond, ttl)
+		clock.Advance(70 * time.Millisecond)
+		_, ok = cache.TTL(100)
+		assert.False(t, ok)
+
+		noTTL := NewCacheBuilder[int, int]().Build()
+		assert.NoError(t, noTTL.Put(1, 1))
+		ttl, ok = noTTL.TTL(1)
+		assert.True(t, ok)
+		assert.Equal(t, NoExpiration, ttl)
+	})
+
+	t.Run("test expired item occupies capacity", func(t *testing.T) {
+		clock := newFakeClock()
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(2).WithTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
 		}
-		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}, finalizeSeq)
-		err := cache.Do(100, func(v int) error {
+		clock.Advance(60 * time.Millisecond)
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, []int{0}, finalizeSeq)
+	})
+
+	t.Run("test expire while pinned", func(t *testing.T) {
+		clock := newFakeClock()
+		finalized := make(chan int, 2)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- key
+			return nil
+		}).WithTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+		err := cache.Do(1, func(v int) error {
+			clock.Advance(60 * time.Millisecond)
+			// The expired item is replaced while pinned, it must not be finalized until doer returns.
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+			assert.Len(t, finalized, 0)
 			return nil
 		})
-		assert.Equal(t, ErrNotEnoughSpace, err)
+		assert.NoError(t, err)
+		assert.Len(t, finalized, 1)
 	})
+}
 
-	t.Run("test load negative", func(t *testing.T) {
+func TestLRUCacheDoCtx(t *testing.T) {
+	t.Run("test cancel waiting for loading", func(t *testing.T) {
+		loading := make(chan struct{})
+		unblock := make(chan struct{})
+		loaded := make(chan struct{})
+		finalizeSeq := make([]int, 0)
 		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
-			if key < 0 {
-				return 0, false
+			if key == 1 {
+				close(loading)
+				<-unblock
+				defer close(loaded)
 			}
 			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(1).Build()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-loading
+			cancel()
+		}()
+		err := cache.DoCtx(ctx, 1, func(v int) error { return nil })
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// The abandoned load still fills the cache, and is not left pinned.
+		close(unblock)
+		<-loaded
+		assert.Eventually(t, func() bool {
+			_, ok := cache.GetIfPresent(1)
+			return ok
+		}, time.Second, time.Millisecond)
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, []int{1}, finalizeSeq)
+	})
+
+	t.Run("test canceled context", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
 		}).Build()
-		err := cache.Do(0, func(v int) error {
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// hit is still served
+		assert.NoError(t, cache.DoCtx(ctx, 1, func(v int) error { return nil }))
+		assert.ErrorIs(t, cache.DoCtx(ctx, 2, func(v int) error { return nil }), context.Canceled)
+	})
+
+	t.Run("test deadline", func(t *testing.T) {
+		unblock := make(chan struct{})
+		defer close(unblock)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			<-unblock
+			return key, true
+		}).Build()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, cache.DoCtx(ctx, 1, func(v int) error { return nil }), context.DeadlineExceeded)
+	})
+
+	type ctxKey struct{}
+	waiters := func(cache Cache[int, int], key int) func() bool {
+		return func() bool {
+			c := cache.(*lruCache[int, int])
+			c.rwlock.Lock()
+			defer c.rwlock.Unlock()
+			call, ok := c.loads[key]
+			return ok && call.waiters == 2
+		}
+	}
+
+	t.Run("test context loader merges waiters", func(t *testing.T) {
+		loading := make(chan struct{})
+		unblock := make(chan struct{})
+		cache := NewCacheBuilder[int, int]().WithContextLoader(func(ctx context.Context, key int) (int, error) {
+			close(loading)
+			<-unblock
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			assert.Equal(t, "trace", ctx.Value(ctxKey{}))
+			return int(time.Until(deadline) / time.Minute), nil
+		}).Build()
+
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "trace"), time.Minute)
+		defer cancel()
+		later, cancelLater := context.WithTimeout(context.Background(), time.Hour)
+		defer cancelLater()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			<-loading
+			assert.NoError(t, cache.DoCtx(later, 1, func(v int) error { return nil }))
+		}()
+		go func() {
+			assert.Eventually(t, waiters(cache, 1), time.Second, time.Millisecond)
+			close(unblock)
+		}()
+		// the load lasts until the latest deadline of the waiters, with the values of the first one
+		assert.NoError(t, cache.DoCtx(ctx, 1, func(v int) error {
+			assert.Equal(t, 59, v)
 			return nil
+		}))
+		<-done
+	})
+
+	t.Run("test context loader canceled once waiters gone", func(t *testing.T) {
+		loads := new(atomic.Int32)
+		gaveUp := make(chan error, 1)
+		cache := NewCacheBuilder[int, int]().WithContextLoader(func(ctx context.Context, key int) (int, error) {
+			if loads.Add(1) > 1 {
+				return key, nil
+			}
+			<-ctx.Done()
+			gaveUp <- ctx.Err()
+			return 0, ctx.Err()
+		}).Build()
+
+		first, cancelFirst := context.WithCancel(context.Background())
+		second, cancelSecond := context.WithCancel(context.Background())
+		errs := make(chan error, 2)
+		for _, ctx := range []context.Context{first, second} {
+			go func(ctx context.Context) {
+				errs <- cache.DoCtx(ctx, 1, func(v int) error { return nil })
+			}(ctx)
+		}
+		assert.Eventually(t, waiters(cache, 1), time.Second, time.Millisecond)
+
+		cancelFirst()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+		select {
+		case <-gaveUp:
+			t.Fatal("canceled with a waiter left")
+		case <-time.After(10 * time.Millisecond):
+		}
+		cancelSecond()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+		assert.ErrorIs(t, <-gaveUp, context.Canceled)
+
+		// a new load is started instead of joining the canceled one
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		assert.EqualValues(t, 2, loads.Load())
+	})
+
+	t.Run("test context loader released once loaded", func(t *testing.T) {
+		released := make(chan error, 1)
+		cache := NewCacheBuilder[int, int]().WithContextLoader(func(ctx context.Context, key int) (int, error) {
+			// left waiting on the context after the loader returns
+			go func() {
+				<-ctx.Done()
+				released <- ctx.Err()
+			}()
+			return key, nil
+		}).Build()
+
+		// no deadline, the context is canceled once loaded
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.ErrorIs(t, <-released, context.Canceled)
+
+		// the deadline still ends it
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.NoError(t, cache.DoCtx(ctx, 2, func(v int) error { return nil }))
+		select {
+		case <-released:
+			t.Fatal("released before the deadline")
+		default:
+		}
+		assert.ErrorIs(t, <-released, context.DeadlineExceeded)
+	})
+
+	t.Run("test context loader abandoned on timeout returns", func(t *testing.T) {
+		hold := make(chan struct{})
+		returned := make(chan error, 1)
+		cache := NewCacheBuilder[int, int]().WithContextLoader(func(ctx context.Context, key int) (int, error) {
+			<-hold
+			<-ctx.Done()
+			returned <- ctx.Err()
+			return 0, ctx.Err()
+		}).WithLoadTimeout(10 * time.Millisecond).Build()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		assert.ErrorIs(t, cache.DoCtx(ctx, 1, func(v int) error { return nil }), ErrLoadTimeout)
+		// the loader goes on after abandoned, and still sees its context done
+		close(hold)
+		select {
+		case err := <-returned:
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(time.Second):
+			t.Fatal("the abandoned loader never returns")
+		}
+	})
+}
+
+func TestLRUCacheDoWithSource(t *testing.T) {
+	clock := newFakeClock()
+	refreshed := make(chan struct{}, 1)
+	version := new(atomic.Int32)
+	for name, builder := range map[string]*CacheBuilder[int, int]{
+		"lru":     NewCacheBuilder[int, int](),
+		"sharded": NewCacheBuilder[int, int]().WithShards(4),
+	} {
+		t.Run("test "+name, func(t *testing.T) {
+			cache := builder.WithLoader(func(key int) (int, bool) {
+				defer func() {
+					select {
+					case refreshed <- struct{}{}:
+					default:
+					}
+				}()
+				return int(version.Add(1)), true
+			}).WithTTL(200 * time.Millisecond).WithRefreshAhead(150 * time.Millisecond).WithClock(clock).Build()
+
+			sources := make([]Source, 0)
+			do := func() {
+				assert.NoError(t, cache.DoWithSource(1, func(v int, source Source) error {
+					sources = append(sources, source)
+					return nil
+				}))
+			}
+			do()
+			<-refreshed
+			do()
+			clock.Advance(60 * time.Millisecond)
+			do()
+			<-refreshed
+			assert.Equal(t, []Source{Loaded, Hit, Refreshed}, sources)
+			assert.Equal(t, "Refreshed", Refreshed.String())
+
+			assert.ErrorIs(t, cache.DoWithSource(1, func(v int, source Source) error {
+				return ErrNoSuchItem
+			}), ErrNoSuchItem)
 		})
-		assert.NoError(t, err)
-		err = cache.Do(-1, func(v int) error {
+	}
+}
+
+func TestLRUCacheMaxConcurrentLoads(t *testing.T) {
+	running := new(atomic.Int32)
+	peak := new(atomic.Int32)
+	unblock := make(chan struct{})
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-unblock
+		return key, true
+	}).WithCapacity(100).WithShards(4).WithMaxConcurrentLoads(2).Build()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			assert.NoError(t, cache.Do(key, func(v int) error { return nil }))
+		}(i % 10)
+	}
+	// the loads beyond the slots give up waiting at the deadline
+	for running.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, cache.DoCtx(ctx, 100, func(v int) error { return nil }), context.DeadlineExceeded)
+
+	close(unblock)
+	wg.Wait()
+	assert.EqualValues(t, 2, peak.Load())
+	assert.Equal(t, 10, cache.Len())
+}
+
+func TestLRUCacheMaxPendingLoads(t *testing.T) {
+	started := make(chan int, 10)
+	unblock := make(chan struct{})
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		started <- key
+		<-unblock
+		return key, true
+	}).WithCapacity(100).WithShards(4).WithMaxPendingLoads(2).Build()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			assert.NoError(t, cache.Do(key, func(v int) error { return nil }))
+		}(i % 2)
+	}
+	<-started
+	<-started
+	// the waiters of the same key count once, a new key is overloaded
+	assert.ErrorIs(t, cache.Do(2, func(v int) error { return nil }), ErrOverloaded)
+	err := cache.DoMany([]int{3}, func(values map[int]int) error { return nil })
+	assert.ErrorIs(t, err.(KeyErrors[int])[3], ErrOverloaded)
+	// hits are served as usual
+	assert.NoError(t, cache.Put(4, 4))
+	assert.NoError(t, cache.Do(4, func(v int) error { return nil }))
+
+	close(unblock)
+	wg.Wait()
+	assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+	assert.EqualValues(t, 0, cache.(*shardedCache[int, int]).shards[0].pendingLoads.Load())
+}
+
+func TestLRUCacheLoadRetry(t *testing.T) {
+	t.Run("test retry", func(t *testing.T) {
+		calls := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			if calls.Add(1) < 3 {
+				return 0, errors.Newf("failure %d", calls.Load())
+			}
+			return key, nil
+		}).WithLoadRetry(3, time.Millisecond).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, cache.Do(1, func(v int) error {
+					assert.Equal(t, 1, v)
+					return nil
+				}))
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 3, calls.Load())
+		assert.Equal(t, 1, cache.Len())
+		assert.EqualValues(t, 1, cache.Stats().LoadSuccesses)
+	})
+
+	t.Run("test exhausted", func(t *testing.T) {
+		calls := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			return 0, errors.Newf("failure %d", calls.Add(1))
+		}).WithLoadRetry(2, time.Millisecond).Build()
+
+		err := cache.Do(1, func(v int) error { return nil })
+		assert.EqualError(t, err, "failure 3")
+		assert.EqualValues(t, 1, cache.Stats().LoadFailures)
+	})
+
+	t.Run("test no such item", func(t *testing.T) {
+		calls := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			calls.Add(1)
+			return 0, false
+		}).WithLoadRetry(2, time.Millisecond).Build()
+
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrNoSuchItem)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("test deadline", func(t *testing.T) {
+		calls := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			return 0, errors.Newf("failure %d", calls.Add(1))
+		}).WithLoadRetry(5, time.Hour).Build()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		// the backoff is beyond the deadline, so no retry
+		assert.EqualError(t, cache.DoCtx(ctx, 1, func(v int) error { return nil }), "failure 1")
+		assert.EqualValues(t, 1, calls.Load())
+	})
+}
+
+func TestLRUCacheLoadTimeout(t *testing.T) {
+	t.Run("test load timeout", func(t *testing.T) {
+		hang := new(atomic.Bool)
+		hang.Store(true)
+		unblock := make(chan struct{})
+		finalized := make(chan int, 1)
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			if hang.Load() {
+				<-unblock
+				return -key, nil
+			}
+			return key, nil
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- value
+			return nil
+		}).WithLoadTimeout(10 * time.Millisecond).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrLoadTimeout)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 0, cache.Len())
+
+		// the abandoned value is finalized once returned
+		close(unblock)
+		assert.Equal(t, -1, <-finalized)
+		assert.Equal(t, 0, cache.Len())
+
+		// retry cleanly
+		hang.Store(false)
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 1, v)
+			return nil
+		}))
+		assert.EqualValues(t, 1, cache.Stats().LoadSuccesses)
+	})
+
+	t.Run("test context loader", func(t *testing.T) {
+		gaveUp := make(chan error, 1)
+		causes := make(chan RemovalCause, 1)
+		cache := NewCacheBuilder[int, int]().WithContextLoader(func(ctx context.Context, key int) (int, error) {
+			<-ctx.Done()
+			gaveUp <- ctx.Err()
+			return key, nil
+		}).WithEvictionListener(func(key, value int, cause RemovalCause) {
+			causes <- cause
+		}).WithLoadTimeout(10 * time.Millisecond).Build()
+
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrLoadTimeout)
+		assert.ErrorIs(t, <-gaveUp, context.DeadlineExceeded)
+		assert.Equal(t, Abandoned, <-causes)
+		assert.False(t, cache.Contains(1))
+	})
+
+	t.Run("test batch load timeout", func(t *testing.T) {
+		unblock := make(chan struct{})
+		finalized := make(chan int, 2)
+		cache := NewCacheBuilder[int, int]().WithBatchLoader(func(keys []int) (map[int]int, error) {
+			<-unblock
+			values := make(map[int]int)
+			for _, key := range keys {
+				values[key] = key
+			}
+			return values, nil
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- value
+			return nil
+		}).WithLoadTimeout(10 * time.Millisecond).Build()
+
+		err := cache.DoMany([]int{1, 2}, func(values map[int]int) error {
+			assert.Empty(t, values)
 			return nil
 		})
-		assert.Equal(t, ErrNoSuchItem, err)
+		var keyErrs KeyErrors[int]
+		assert.True(t, errors.As(err, &keyErrs))
+		assert.ErrorIs(t, keyErrs[1], ErrLoadTimeout)
+		close(unblock)
+		assert.ElementsMatch(t, []int{1, 2}, []int{<-finalized, <-finalized})
+	})
+}
+
+func TestLRUCacheWaitTimeout(t *testing.T) {
+	newCache := func(timeout time.Duration) Cache[int, int] {
+		return NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(1).WithWaitTimeout(timeout).Build()
+	}
+	// hold occupies the only slot with key 1 until release is closed
+	hold := func(cache Cache[int, int]) (release chan struct{}, done chan error) {
+		holding := make(chan struct{})
+		release = make(chan struct{})
+		done = make(chan error, 1)
+		go func() {
+			done <- cache.Do(1, func(v int) error {
+				close(holding)
+				<-release
+				return nil
+			})
+		}()
+		<-holding
+		return release, done
+	}
+
+	t.Run("test wait for space", func(t *testing.T) {
+		cache := newCache(time.Minute)
+		release, done := hold(cache)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+		}()
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.NoError(t, <-done)
+		_, ok := cache.GetIfPresent(1)
+		assert.False(t, ok)
+	})
+
+	t.Run("test wait for unpin", func(t *testing.T) {
+		cache := newCache(time.Minute)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		cache.Pin(1)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cache.Unpin(1)
+		}()
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+	})
+
+	t.Run("test wait timeout", func(t *testing.T) {
+		cache := newCache(10 * time.Millisecond)
+		release, done := hold(cache)
+		defer func() {
+			close(release)
+			assert.NoError(t, <-done)
+		}()
+		assert.ErrorIs(t, cache.Do(2, func(v int) error { return nil }), ErrNotEnoughSpace)
+	})
+
+	t.Run("test cancel waiting for space", func(t *testing.T) {
+		cache := newCache(time.Minute)
+		release, done := hold(cache)
+		defer func() {
+			close(release)
+			assert.NoError(t, <-done)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, cache.DoCtx(ctx, 2, func(v int) error { return nil }), context.DeadlineExceeded)
+	})
+
+	t.Run("test zero timeout", func(t *testing.T) {
+		cache := newCache(0)
+		release, done := hold(cache)
+		defer func() {
+			close(release)
+			assert.NoError(t, <-done)
+		}()
+		start := time.Now()
+		assert.ErrorIs(t, cache.Do(2, func(v int) error { return nil }), ErrNotEnoughSpace)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("test entry heavier than capacity", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithLazyScavenger(func(key int) int64 {
+			return int64(key)
+		}, 10).WithWaitTimeout(time.Minute).Build()
+		assert.ErrorIs(t, cache.Do(11, func(v int) error { return nil }), ErrNotEnoughSpace)
 	})
 }
 
@@ -152,6 +2270,120 @@ func TestLRUCacheConcurrency(t *testing.T) {
 		wg.Wait()
 	})
 
+	t.Run("test resize while loading", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(10).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					if i == 0 {
+						cache.Resize(int64(j%10 + 1))
+						continue
+					}
+					err := cache.Do(j, func(v int) error { return nil })
+					assert.True(t, err == nil || errors.Is(err, ErrNotEnoughSpace))
+				}
+			}(i)
+		}
+		wg.Wait()
+		cache.Resize(5)
+		assert.LessOrEqual(t, cache.Usage(), int64(5))
+	})
+
+	t.Run("test not enough space deterministic", func(t *testing.T) {
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithCapacity(1).Build()
+
+		err := cache.Do(1000, func(v int) error {
+			// key 1000 holds the only slot while doer is running
+			return cache.Do(1001, func(v int) error { return nil })
+		})
+		assert.Equal(t, ErrNotEnoughSpace, err)
+		assert.Equal(t, 1, loadCnt)
+		assert.NoError(t, cache.Do(1001, func(v int) error { return nil }))
+	})
+
+	t.Run("test entry heavier than capacity", func(t *testing.T) {
+		loadCnt := 0
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt++
+			return key, true
+		}).WithLazyScavenger(func(key int) int64 {
+			return int64(key)
+		}, 10).Build()
+
+		// fails fast without loading even when the cache is empty
+		assert.Equal(t, ErrNotEnoughSpace, cache.Do(11, func(v int) error { return nil }))
+		assert.Equal(t, 0, loadCnt)
+	})
+
+	t.Run("test single flight", func(t *testing.T) {
+		loadCnt := new(atomic.Int32)
+		unblock := make(chan struct{})
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt.Add(1)
+			if key == 1 {
+				<-unblock
+			}
+			return key, key > 0
+		}).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, cache.Do(1, func(v int) error {
+					assert.Equal(t, 1, v)
+					return nil
+				}))
+			}()
+		}
+		// Loading other keys is not blocked by the in-flight load of key 1.
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		close(unblock)
+		wg.Wait()
+		assert.EqualValues(t, 2, loadCnt.Load())
+	})
+
+	t.Run("test single flight error", func(t *testing.T) {
+		loadCnt := new(atomic.Int32)
+		unblock := make(chan struct{})
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loadCnt.Add(1)
+			<-unblock
+			return 0, false
+		}).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, ErrNoSuchItem, cache.Do(1, func(v int) error { return nil }))
+			}()
+		}
+		// wait for all callers to join the in-flight load
+		lru := cache.(*lruCache[int, int])
+		assert.Eventually(t, func() bool {
+			lru.rwlock.Lock()
+			defer lru.rwlock.Unlock()
+			call, ok := lru.loads[1]
+			return ok && call.waiters == 10
+		}, time.Second, time.Millisecond)
+		close(unblock)
+		wg.Wait()
+		assert.EqualValues(t, 1, loadCnt.Load())
+	})
+
 	t.Run("test not enough space", func(t *testing.T) {
 		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
 			return key, true
@@ -176,3 +2408,18 @@ func TestLRUCacheConcurrency(t *testing.T) {
 		assert.Equal(t, ErrNotEnoughSpace, err)
 	})
 }
+
+func benchmarkPopulate(b *testing.B, initialCapacity int) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cache := NewCacheBuilder[int, int]().WithCapacity(10000).WithInitialCapacity(initialCapacity).Build()
+		for j := 0; j < 10000; j++ {
+			cache.Put(j, j)
+		}
+	}
+}
+
+func BenchmarkPopulate(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkPopulate(b, 0) })
+	b.Run("pre-sized", func(b *testing.B) { benchmarkPopulate(b, 10000) })
+}
@@ -0,0 +1,160 @@
+// Package cachemetrics exports the statistics of util/cache as Prometheus metrics,
+// so that the cache package itself does not depend on Prometheus.
+package cachemetrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+const (
+	namespace = "milvus"
+	subsystem = "cache"
+)
+
+// StatsSource is the part of cache.Cache read by the collector.
+type StatsSource interface {
+	Stats() cache.Stats
+	Len() int
+	Usage() int64
+}
+
+// Source is the part of cache.Cache read by the collector of NewCacheCollector, every cache.Cache is one.
+type Source interface {
+	StatsSource
+	HitRatio() float64
+	LoadLatency() cache.LoadLatency
+}
+
+// hitRatioSource is a StatsSource which tells its hit ratio too, it is exported as a gauge if so.
+type hitRatioSource interface {
+	HitRatio() float64
+}
+
+// Collector collects the statistics of a cache along with a histogram of load durations.
+//
+//	The counters follow Stats of the cache, ResetStats of the cache is seen as a counter reset by Prometheus.
+//	The hit ratio gauge follows HitRatio of the cache, the hit ratio over all time is derived from the rates of hits and misses.
+//	Register it once, it is read safely while the cache is in use.
+type Collector struct {
+	mu     sync.RWMutex
+	source StatsSource
+	// latency is the load latency of the cache, the histogram is built from it instead of ObserveLoad if set
+	latency func() cache.LoadLatency
+
+	hits          *prometheus.Desc
+	misses        *prometheus.Desc
+	evictions     *prometheus.Desc
+	loadSuccesses *prometheus.Desc
+	loadFailures  *prometheus.Desc
+	hardLimitHits *prometheus.Desc
+	entries       *prometheus.Desc
+	usage         *prometheus.Desc
+	hitRatio      *prometheus.Desc
+	loadDuration  prometheus.Histogram
+}
+
+// NewCacheCollector creates a collector of source labeled with labels, which tells the cache apart from others.
+//
+//	The load durations are read from LoadLatency of the cache, so there is nothing else to wire,
+//	neither Watch nor the load observer is needed.
+func NewCacheCollector(source Source, labels prometheus.Labels) *Collector {
+	c := NewCollector(labels)
+	c.source = source
+	c.latency = source.LoadLatency
+	return c
+}
+
+// NewCollector creates a collector labeled with labels, which tells the cache apart from others.
+//
+//	Set ObserveLoad as the load observer of the cache, and Watch the cache built, or use NewCacheCollector instead.
+func NewCollector(labels prometheus.Labels) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, labels)
+	}
+	return &Collector{
+		hits:          desc("hits_total", "number of cache hits"),
+		misses:        desc("misses_total", "number of cache misses"),
+		evictions:     desc("evictions_total", "number of entries evicted"),
+		loadSuccesses: desc("load_successes_total", "number of successful loads"),
+		loadFailures:  desc("load_failures_total", "number of failed loads"),
+		hardLimitHits: desc("hard_limit_hits_total", "number of inserts evicting entries inline at the capacity"),
+		entries:       desc("entries", "number of entries cached"),
+		usage:         desc("usage", "occupation of entries cached"),
+		hitRatio:      desc("hit_ratio", "ratio of hits over the recent lookups"),
+		loadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "load_duration_seconds",
+			Help:        "duration of loader calls",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 16), // 0.5ms ~ 16s
+		}),
+	}
+}
+
+// Watch sets the cache to collect statistics from.
+func (c *Collector) Watch(source StatsSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.source = source
+}
+
+// ObserveLoad records a loader call, it is a cache.LoadObserver.
+func (c *Collector) ObserveLoad(d time.Duration, err error) {
+	c.loadDuration.Observe(d.Seconds())
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.loadSuccesses
+	ch <- c.loadFailures
+	ch <- c.hardLimitHits
+	ch <- c.entries
+	ch <- c.usage
+	ch <- c.hitRatio
+	c.loadDuration.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	source := c.source
+	c.mu.RUnlock()
+
+	if source != nil {
+		stats := source.Stats()
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+		ch <- prometheus.MustNewConstMetric(c.loadSuccesses, prometheus.CounterValue, float64(stats.LoadSuccesses))
+		ch <- prometheus.MustNewConstMetric(c.loadFailures, prometheus.CounterValue, float64(stats.LoadFailures))
+		ch <- prometheus.MustNewConstMetric(c.hardLimitHits, prometheus.CounterValue, float64(stats.HardLimitHits))
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(source.Len()))
+		ch <- prometheus.MustNewConstMetric(c.usage, prometheus.GaugeValue, float64(source.Usage()))
+		if source, ok := source.(hitRatioSource); ok {
+			ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, source.HitRatio())
+		}
+	}
+	if c.latency != nil {
+		ch <- c.latencyHistogram(c.latency())
+		return
+	}
+	c.loadDuration.Collect(ch)
+}
+
+// latencyHistogram converts the load latency of the cache, whose buckets are not cumulative, to the histogram.
+func (c *Collector) latencyHistogram(latency cache.LoadLatency) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(cache.LoadLatencyBuckets))
+	count := uint64(0)
+	for i, bound := range cache.LoadLatencyBuckets {
+		count += uint64(latency.Buckets[i])
+		buckets[bound.Seconds()] = count
+	}
+	return prometheus.MustNewConstHistogram(c.loadDuration.Desc(), uint64(latency.Count), latency.Sum.Seconds(), buckets)
+}
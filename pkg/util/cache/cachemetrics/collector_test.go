@@ -0,0 +1,127 @@
+package cachemetrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector(prometheus.Labels{"name": "test"})
+	c := cache.NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, key >= 0
+	}).WithLoadObserver(collector.ObserveLoad).WithCapacity(2).Build()
+	collector.Watch(c)
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	for _, key := range []int{1, 1, 2, 3, -1} {
+		c.Do(key, func(v int) error { return nil })
+	}
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP milvus_cache_hits_total number of cache hits
+# TYPE milvus_cache_hits_total counter
+milvus_cache_hits_total{name="test"} 1
+# HELP milvus_cache_misses_total number of cache misses
+# TYPE milvus_cache_misses_total counter
+milvus_cache_misses_total{name="test"} 4
+# HELP milvus_cache_evictions_total number of entries evicted
+# TYPE milvus_cache_evictions_total counter
+milvus_cache_evictions_total{name="test"} 1
+# HELP milvus_cache_load_failures_total number of failed loads
+# TYPE milvus_cache_load_failures_total counter
+milvus_cache_load_failures_total{name="test"} 1
+# HELP milvus_cache_hard_limit_hits_total number of inserts evicting entries inline at the capacity
+# TYPE milvus_cache_hard_limit_hits_total counter
+milvus_cache_hard_limit_hits_total{name="test"} 1
+# HELP milvus_cache_entries number of entries cached
+# TYPE milvus_cache_entries gauge
+milvus_cache_entries{name="test"} 2
+`), "milvus_cache_hits_total", "milvus_cache_misses_total", "milvus_cache_evictions_total",
+		"milvus_cache_load_failures_total", "milvus_cache_hard_limit_hits_total", "milvus_cache_entries")
+	assert.NoError(t, err)
+
+	// collect while the cache is in use
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					_, err := registry.Gather()
+					assert.NoError(t, err)
+					continue
+				}
+				c.Do(j, func(v int) error { return nil })
+			}
+		}(i)
+	}
+	wg.Wait()
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	found := false
+	for _, family := range families {
+		if family.GetName() == "milvus_cache_load_duration_seconds" {
+			found = true
+			loads := c.Stats().LoadSuccesses + c.Stats().LoadFailures
+			assert.EqualValues(t, loads, family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestCacheCollector(t *testing.T) {
+	c := cache.NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, key >= 0
+	}).WithCapacity(2).Build()
+	// nothing else to wire
+	collector := NewCacheCollector(c, prometheus.Labels{"name": "test"})
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	for _, key := range []int{1, 1, 2, 3, -1} {
+		c.Do(key, func(v int) error { return nil })
+	}
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP milvus_cache_hits_total number of cache hits
+# TYPE milvus_cache_hits_total counter
+milvus_cache_hits_total{name="test"} 1
+# HELP milvus_cache_misses_total number of cache misses
+# TYPE milvus_cache_misses_total counter
+milvus_cache_misses_total{name="test"} 4
+# HELP milvus_cache_entries number of entries cached
+# TYPE milvus_cache_entries gauge
+milvus_cache_entries{name="test"} 2
+`), "milvus_cache_hits_total", "milvus_cache_misses_total", "milvus_cache_entries")
+	assert.NoError(t, err)
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		switch family.GetName() {
+		case "milvus_cache_hit_ratio":
+			metrics[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		case "milvus_cache_load_duration_seconds":
+			histogram := family.GetMetric()[0].GetHistogram()
+			metrics[family.GetName()] = float64(histogram.GetSampleCount())
+			buckets := histogram.GetBucket()
+			assert.Len(t, buckets, len(cache.LoadLatencyBuckets))
+			// the loads are fast, all of them fall in every bucket
+			assert.EqualValues(t, histogram.GetSampleCount(), buckets[len(buckets)-1].GetCumulativeCount())
+		}
+	}
+	assert.InDelta(t, c.HitRatio(), metrics["milvus_cache_hit_ratio"], 0.01)
+	assert.Greater(t, metrics["milvus_cache_hit_ratio"], 0.0)
+	loads := c.Stats().LoadSuccesses + c.Stats().LoadFailures
+	assert.EqualValues(t, loads, metrics["milvus_cache_load_duration_seconds"])
+}
@@ -0,0 +1,54 @@
+// Package cachetracing adapts an OpenTelemetry tracer to the tracer of util/cache,
+// so that the cache package itself does not depend on OpenTelemetry.
+package cachetracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+// Tracer starts the spans of a cache with an OpenTelemetry tracer, pass it to WithTracer of the cache builder.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ cache.Tracer = (*Tracer)(nil)
+
+// NewTracer creates a Tracer starting spans with tracer.
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, cache.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, spanAdapter{span: span}
+}
+
+type spanAdapter struct {
+	span trace.Span
+}
+
+func (s spanAdapter) SetAttribute(key string, value any) {
+	switch v := value.(type) {
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	}
+}
+
+// End records err as the status of the span if it is not nil.
+func (s spanAdapter) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,59 @@
+package cachetracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	c := cache.NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+		if key < 0 {
+			return 0, errors.New("mocked")
+		}
+		return key, nil
+	}).WithTracer(NewTracer(provider.Tracer("test"))).WithCapacity(10).Build()
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	t.Run("test miss and hit", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, c.DoCtx(context.Background(), 1, func(v int) error { return nil }))
+		}
+		spans := recorder.Ended()
+		assert.Len(t, spans, 3)
+		load, miss, hit := spans[0], spans[1], spans[2]
+		assert.Equal(t, "cache.load", load.Name())
+		assert.Equal(t, miss.SpanContext().SpanID(), load.Parent().SpanID())
+		assert.Equal(t, "cache.Do", miss.Name())
+		assert.False(t, attrs(miss)["cache.hit"].AsBool())
+		assert.Equal(t, "cache.Do", hit.Name())
+		assert.True(t, attrs(hit)["cache.hit"].AsBool())
+		assert.NotEmpty(t, attrs(hit)["cache.key"].AsString())
+		assert.Equal(t, attrs(miss)["cache.key"], attrs(hit)["cache.key"])
+	})
+
+	t.Run("test error", func(t *testing.T) {
+		assert.Error(t, c.DoCtx(context.Background(), -1, func(v int) error { return nil }))
+		spans := recorder.Ended()
+		do := spans[len(spans)-1]
+		assert.Equal(t, codes.Error, do.Status().Code)
+		assert.Equal(t, codes.Error, spans[len(spans)-2].Status().Code)
+	})
+}
@@ -0,0 +1,14 @@
+package cache
+
+import "time"
+
+// Clock tells the time for the cache to judge expiry, it is replaceable for deterministic tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
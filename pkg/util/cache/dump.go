@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// dumpTop is the number of entries listed by Dump.
+	dumpTop = 10
+	// summaryLen is the max length of a value summarized by default.
+	summaryLen = 64
+)
+
+// summarize prints v by %v, cut to summaryLen bytes.
+func summarize[V any](v V) string {
+	s := fmt.Sprint(v)
+	if len(s) > summaryLen {
+		s = strings.ToValidUTF8(s[:summaryLen], "") + "..."
+	}
+	return s
+}
+
+func (c *lruCache[K, V]) Dump() string {
+	var sb strings.Builder
+	c.DumpTo(&sb, dumpTop)
+	return sb.String()
+}
+
+func (c *lruCache[K, V]) DumpTo(w io.Writer, top int) error {
+	var buf bytes.Buffer
+	c.dump(&buf, "cache", top)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dump prints the cache named name to buf, within a single lock.
+func (c *lruCache[K, V]) dump(buf *bytes.Buffer, name string, top int) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.drainReads()
+	now := c.clock.Now()
+	fmt.Fprintf(buf, "%s: entries=%d capacity=%d usage=%d loading=%d\n",
+		name, len(c.items), c.scavenger.capacity, c.scavenger.Size(), len(c.loads))
+	fmt.Fprintf(buf, "  policy: %s\n", c.evictor.describe())
+
+	// Keep the top most recent entries by insertion sort, as top is small.
+	recent := make([]*cacheItem[K, V], 0, top)
+	for _, item := range c.items {
+		if top <= 0 {
+			break
+		}
+		if len(recent) == top && !item.lastAccess.After(recent[top-1].lastAccess) {
+			continue
+		}
+		if len(recent) < top {
+			recent = append(recent, nil)
+		}
+		i := len(recent) - 1
+		for ; i > 0 && item.lastAccess.After(recent[i-1].lastAccess); i-- {
+			recent[i] = recent[i-1]
+		}
+		recent[i] = item
+	}
+	if len(recent) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "  top %d of %d entries by recency:\n", len(recent), len(c.items))
+	for _, item := range recent {
+		ttl := "never"
+		if !item.expireAt.IsZero() {
+			ttl = item.expireAt.Sub(now).String()
+			if item.expired(now) {
+				ttl = "expired"
+			}
+		}
+		fmt.Fprintf(buf, "    %v: cost=%d ttl=%s idle=%s value=%s\n",
+			item.key, item.cost, ttl, now.Sub(item.lastAccess).Truncate(time.Millisecond), c.summarizer(item.Value()))
+	}
+}
+
+func (c *shardedCache[K, V]) Dump() string {
+	var sb strings.Builder
+	c.DumpTo(&sb, dumpTop)
+	return sb.String()
+}
+
+func (c *shardedCache[K, V]) DumpTo(w io.Writer, top int) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "cache: shards=%d entries=%d usage=%d\n", len(c.shards), c.Len(), c.Usage())
+	for i, shard := range c.shards {
+		shard.dump(&buf, fmt.Sprintf("shard %d", i), top)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
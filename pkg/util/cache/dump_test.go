@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDump(t *testing.T) {
+	t.Run("test dump", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, string]().WithCapacity(10).WithTTL(time.Minute).WithClock(clock).Build()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, cache.Put(i, strings.Repeat("x", i*50)))
+			clock.Advance(time.Second)
+		}
+		assert.True(t, cache.Touch(0))
+
+		var sb strings.Builder
+		assert.NoError(t, cache.DumpTo(&sb, 3))
+		assert.Equal(t, []string{
+			"cache: entries=5 capacity=10 usage=5 loading=0",
+			"  policy: lru entries=5",
+			"  top 3 of 5 entries by recency:",
+			"    0: cost=1 ttl=55s idle=0s value=",
+			"    4: cost=1 ttl=59s idle=1s value=" + strings.Repeat("x", 64) + "...",
+			"    3: cost=1 ttl=58s idle=2s value=" + strings.Repeat("x", 64) + "...",
+		}, strings.Split(strings.TrimSpace(sb.String()), "\n"))
+		assert.Contains(t, cache.Dump(), "top 5 of 5 entries")
+	})
+
+	t.Run("test summarizer", func(t *testing.T) {
+		cache := NewCacheBuilder[int, []byte]().WithSummarizer(func(v []byte) string {
+			return fmt.Sprintf("%d bytes", len(v))
+		}).WithEvictionPolicy(SLRU(0.5)).WithShards(2).Build()
+		assert.NoError(t, cache.Put(1, make([]byte, 1<<20)))
+
+		dump := cache.Dump()
+		assert.Contains(t, dump, "cache: shards=2 entries=1 usage=1")
+		assert.Contains(t, dump, "policy: slru probation=")
+		assert.Contains(t, dump, "1: cost=1 ttl=never")
+		assert.Contains(t, dump, "value=1048576 bytes")
+	})
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"math"
+	"time"
+)
+
+// expireEarly tells whether an entry with remaining ttl is taken as expired early, by XFetch.
+//
+//	It holds with the probability of delta*beta*-ln(rand) >= remaining, delta being the recent load duration,
+//	so the closer to the expiry and the slower to load, the more likely an entry is refreshed early.
+func (c *lruCache[K, V]) expireEarly(remaining time.Duration) bool {
+	if c.earlyBeta <= 0 {
+		return false
+	}
+	delta := c.loadDelta.Load()
+	if delta <= 0 {
+		return false
+	}
+	return float64(delta)*c.earlyBeta*-math.Log(c.random()) >= float64(remaining)
+}
+
+// observeDelta folds a load duration into the moving average of load durations, weighing it 1/8.
+//
+//	Updates racing with each other may be lost, which is fine for an estimate.
+func (c *lruCache[K, V]) observeDelta(d time.Duration) {
+	if c.earlyBeta <= 0 {
+		return
+	}
+	delta := c.loadDelta.Load()
+	if delta == 0 {
+		c.loadDelta.Store(int64(d))
+		return
+	}
+	c.loadDelta.Store(delta + (int64(d)-delta)/8)
+}
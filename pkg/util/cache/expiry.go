@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// reapBatch is the max number of entries reaped within a lock, so that Do is not blocked long by reaping.
+const reapBatch = 128
+
+// expiryQueue orders entries with an expiry by it, the nearest first.
+//
+//	A nil expiryQueue tracks nothing. It is not thread safe, the owner cache holds the lock.
+type expiryQueue[K comparable, V any] struct {
+	items expiryHeap[K, V]
+}
+
+func newExpiryQueue[K comparable, V any]() *expiryQueue[K, V] {
+	return &expiryQueue[K, V]{}
+}
+
+func (q *expiryQueue[K, V]) push(item *cacheItem[K, V]) {
+	if q == nil || item.expireAt.IsZero() {
+		return
+	}
+	heap.Push(&q.items, item)
+}
+
+// fix restores the order after the expiry of item is changed.
+func (q *expiryQueue[K, V]) fix(item *cacheItem[K, V]) {
+	if q == nil || item.expiryIndex < 0 {
+		return
+	}
+	heap.Fix(&q.items, item.expiryIndex)
+}
+
+func (q *expiryQueue[K, V]) remove(item *cacheItem[K, V]) {
+	if q == nil || item.expiryIndex < 0 {
+		return
+	}
+	heap.Remove(&q.items, item.expiryIndex)
+}
+
+// peek returns the entry expiring first, or nil if there is none.
+func (q *expiryQueue[K, V]) peek() *cacheItem[K, V] {
+	if q == nil || len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0]
+}
+
+func (q *expiryQueue[K, V]) len() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.items)
+}
+
+type expiryHeap[K comparable, V any] []*cacheItem[K, V]
+
+func (h expiryHeap[K, V]) Len() int { return len(h) }
+
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].expireAt.Before(h[j].expireAt) }
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryIndex = i
+	h[j].expiryIndex = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	item := x.(*cacheItem[K, V])
+	item.expiryIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.expiryIndex = -1
+	*h = old[:n-1]
+	return item
+}
+
+// reapEvery reaps expired entries every interval.
+func (c *lruCache[K, V]) reapEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.reap()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reap removes the expired entries, the ones which could still be served stale are kept.
+func (c *lruCache[K, V]) reap() {
+	grace := c.staleGrace
+	if c.staleIfError > grace {
+		grace = c.staleIfError
+	}
+	for {
+		c.rwlock.Lock()
+		// Take the clock on each batch, as the time goes by meanwhile.
+		deadline := c.clock.Now().Add(-grace)
+		n := 0
+		for ; n < reapBatch; n++ {
+			item := c.expiry.peek()
+			if item == nil || !item.expired(deadline) {
+				break
+			}
+			c.removeItem(item, Expired)
+			c.stats.evictions.Inc()
+		}
+		if n < reapBatch {
+			c.evictIdle(0, nil)
+		}
+		c.rwlock.Unlock()
+		if n < reapBatch {
+			return
+		}
+	}
+}
+
+// evictIdle removes the unpinned entries not accessed for the idle timeout among the first limit victims,
+// all the victims if limit is not positive, keep is never removed. It returns the number removed.
+// The caller must hold the lock.
+func (c *lruCache[K, V]) evictIdle(limit int, keep *cacheItem[K, V]) int {
+	if c.idleTimeout <= 0 {
+		return 0
+	}
+	since := c.clock.Now().Add(-c.idleTimeout)
+	toEvict := make([]*cacheItem[K, V], 0)
+	visited := 0
+	c.evictor.victims(func(n *policyNode) bool {
+		if limit > 0 && visited >= limit {
+			return false
+		}
+		visited++
+		item := n.owner.(*cacheItem[K, V])
+		if item != keep && item.lastAccess.Before(since) && c.evictable(item) {
+			toEvict = append(toEvict, item)
+		}
+		return true
+	})
+	for _, item := range toEvict {
+		c.removeItem(item, Expired)
+		c.stats.evictions.Inc()
+	}
+	return len(toEvict)
+}
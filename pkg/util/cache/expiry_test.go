@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryQueue(t *testing.T) {
+	now := time.Now()
+	newItem := func(key int, ttl time.Duration) *cacheItem[int, int] {
+		item := newCacheItem[int, int](key, key)
+		if ttl > 0 {
+			item.expireAt = now.Add(ttl)
+		}
+		return item
+	}
+
+	q := newExpiryQueue[int, int]()
+	items := []*cacheItem[int, int]{newItem(1, 3*time.Second), newItem(2, time.Second), newItem(3, 2*time.Second), newItem(4, 0)}
+	for _, item := range items {
+		q.push(item)
+	}
+	// entries never expire are not tracked
+	assert.Equal(t, 3, q.len())
+	assert.Equal(t, -1, items[3].expiryIndex)
+	assert.Equal(t, 2, q.peek().key)
+
+	items[1].expireAt = now.Add(4 * time.Second)
+	q.fix(items[1])
+	assert.Equal(t, 3, q.peek().key)
+
+	q.remove(items[2])
+	assert.Equal(t, -1, items[2].expiryIndex)
+	assert.Equal(t, 1, q.peek().key)
+	q.remove(items[2])
+	assert.Equal(t, 2, q.len())
+
+	var nilQueue *expiryQueue[int, int]
+	nilQueue.push(items[0])
+	assert.Nil(t, nilQueue.peek())
+	assert.Equal(t, 0, nilQueue.len())
+}
+
+func TestActiveExpiration(t *testing.T) {
+	t.Run("test reap", func(t *testing.T) {
+		clock := newFakeClock()
+		finalized := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+			return key, time.Duration(key) * time.Millisecond, true
+		}).WithFinalizer(func(key, value int) error {
+			finalized = append(finalized, key)
+			return nil
+		}).WithCapacity(1000).WithActiveExpiration(time.Hour).WithClock(clock).Build()
+		lru := cache.(*lruCache[int, int])
+
+		for i := 300; i > 0; i-- {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.NoError(t, cache.Put(0, 0))
+
+		clock.Advance(200 * time.Millisecond)
+		lru.reap()
+		assert.Len(t, finalized, 200)
+		assert.Equal(t, 1, finalized[0])
+		// entries without expiry are never reaped
+		assert.Equal(t, 101, cache.Len())
+		assert.Equal(t, 100, lru.expiry.len())
+		assert.EqualValues(t, 200, cache.Stats().Evictions)
+
+		assert.True(t, cache.Remove(250))
+		assert.Equal(t, 99, lru.expiry.len())
+		assert.NoError(t, cache.Clear())
+		assert.Equal(t, 0, lru.expiry.len())
+	})
+
+	t.Run("test reap with sliding ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithSlidingTTL(50 * time.Millisecond).WithActiveExpiration(time.Hour).WithClock(clock).Build()
+		lru := cache.(*lruCache[int, int])
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		clock.Advance(40 * time.Millisecond)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(20 * time.Millisecond)
+		lru.reap()
+		_, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("test keep stale", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithTTL(50 * time.Millisecond).WithStaleWhileRevalidate(50 * time.Millisecond).
+			WithActiveExpiration(time.Hour).WithClock(clock).Build()
+		lru := cache.(*lruCache[int, int])
+
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(60 * time.Millisecond)
+		lru.reap()
+		assert.Equal(t, 1, cache.Len())
+		clock.Advance(50 * time.Millisecond)
+		lru.reap()
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("test idle timeout", func(t *testing.T) {
+		clock := newFakeClock()
+		removals := make([]removal, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithEvictionListener(func(key, value int, cause RemovalCause) {
+			removals = append(removals, removal{key, value, cause})
+		}).WithIdleTimeout(time.Minute).WithActiveExpiration(time.Hour).WithClock(clock).Build()
+		lru := cache.(*lruCache[int, int])
+
+		for i := 1; i <= 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		clock.Advance(50 * time.Second)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		clock.Advance(20 * time.Second)
+		// an idle entry is still served until evicted
+		assert.True(t, cache.Contains(2))
+		cache.Pin(3)
+		lru.reap()
+		assert.Equal(t, []removal{{2, 2, Expired}}, removals)
+		assert.Equal(t, 2, cache.Len())
+		cache.Unpin(3)
+
+		// evicted lazily by inserts
+		assert.NoError(t, cache.Put(4, 4))
+		assert.Equal(t, []removal{{2, 2, Expired}, {3, 3, Expired}}, removals)
+		clock.Advance(time.Minute)
+		assert.NoError(t, cache.Put(4, 40))
+		assert.Equal(t, []removal{{2, 2, Expired}, {3, 3, Expired}, {1, 1, Expired}, {4, 4, Replaced}}, removals)
+	})
+
+	t.Run("test background reaper", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithTTL(time.Millisecond).WithActiveExpiration(time.Millisecond).Build()
+		defer cache.Close()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Eventually(t, func() bool {
+			return cache.Len() == 0
+		}, time.Second, time.Millisecond)
+	})
+}
@@ -0,0 +1,80 @@
+package cache
+
+import "sync"
+
+// finalizeQueueSize is the number of entries queued for the async finalizer, beyond which finalizing blocks.
+const finalizeQueueSize = 1024
+
+// finalizerPool runs finalizers by workers off the caller, it is shared by the shards.
+//
+//	A nil finalizerPool runs nothing, finalizers are called in place then.
+type finalizerPool struct {
+	// mu is held shared to submit, exclusively to close
+	mu     sync.RWMutex
+	closed bool
+	queue  chan func()
+	wg     sync.WaitGroup
+}
+
+func newFinalizerPool(workers int) *finalizerPool {
+	if workers <= 0 {
+		return nil
+	}
+	p := &finalizerPool{queue: make(chan func(), finalizeQueueSize)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for finalize := range p.queue {
+				finalize()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues finalize, it returns false if the pool is nil or closed, the caller should run it in place then.
+func (p *finalizerPool) submit(finalize func()) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	p.queue <- finalize
+	return true
+}
+
+// trySubmit is submit without blocking, it returns false if the queue is full as well.
+func (p *finalizerPool) trySubmit(finalize func()) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queue <- finalize:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain stops accepting and waits for the queued to finish.
+func (p *finalizerPool) drain() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncFinalizer(t *testing.T) {
+	for _, shards := range []int{1, 2} {
+		var mu sync.Mutex
+		finalized := make([]int, 0)
+		unblock := make(chan struct{})
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			<-unblock
+			mu.Lock()
+			defer mu.Unlock()
+			finalized = append(finalized, key)
+			return nil
+		}).WithCapacity(2).WithShards(shards).WithAsyncFinalizer(2).Build()
+
+		// evictions do not wait for the finalizer, and give back the capacity right away
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.LessOrEqual(t, cache.Usage(), int64(2))
+		assert.NoError(t, cache.Put(100, 100))
+		assert.True(t, cache.Remove(100))
+
+		time.AfterFunc(10*time.Millisecond, func() { close(unblock) })
+		// Close finalizes the remaining and drains the queue
+		assert.NoError(t, cache.Close())
+		mu.Lock()
+		assert.Len(t, finalized, 11)
+		mu.Unlock()
+	}
+
+	t.Run("test pinned after close", func(t *testing.T) {
+		finalized := make(chan int, 1)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalized <- key
+			return nil
+		}).WithAsyncFinalizer(1).Build()
+
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.NoError(t, cache.Close())
+			assert.Len(t, finalized, 0)
+			return nil
+		}))
+		assert.Equal(t, 1, <-finalized)
+	})
+}
+
+func TestFinalizerRetry(t *testing.T) {
+	t.Run("test retry", func(t *testing.T) {
+		calls := 0
+		failed := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			calls++
+			if key == 1 || calls%2 == 1 {
+				return errors.New("transient")
+			}
+			return nil
+		}).WithFinalizerRetry(2, time.Millisecond).WithFinalizerErrorHandler(func(key, value int, err error) {
+			failed = append(failed, key)
+		}).Build()
+
+		assert.NoError(t, cache.Put(2, 2))
+		assert.True(t, cache.Remove(2))
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, failed)
+
+		// exhausted retries are reported, and the error is still returned
+		assert.NoError(t, cache.Put(1, 1))
+		assert.EqualError(t, cache.Clear(), "transient")
+		assert.Equal(t, 5, calls)
+		assert.Equal(t, []int{1}, failed)
+	})
+
+	t.Run("test no retry", func(t *testing.T) {
+		calls := 0
+		cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			calls++
+			return errors.New("failure")
+		}).Build()
+		assert.NoError(t, cache.Put(1, 1))
+		assert.EqualError(t, cache.Clear(), "failure")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("test async", func(t *testing.T) {
+		failed := make(chan error, 1)
+		cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			return errors.New("failure")
+		}).WithFinalizerRetry(1, 0).WithFinalizerErrorHandler(func(key, value int, err error) {
+			failed <- err
+		}).WithAsyncFinalizer(1).Build()
+		assert.NoError(t, cache.Put(1, 1))
+		assert.NoError(t, cache.Close())
+		assert.EqualError(t, <-failed, "failure")
+	})
+}
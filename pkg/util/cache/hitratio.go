@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// defaultHitRatioHalfLife is the half-life of HitRatio unless set by WithHitRatioWindow.
+const defaultHitRatioHalfLife = time.Minute
+
+// hitRatio tracks the hits and lookups decayed exponentially by their age.
+//
+//	A lookup only bumps the pending counters, which are folded into the decayed ones every 1/8 half-life
+//	by whichever lookup gets the lock first, or on read, so that the hot path never waits.
+type hitRatio struct {
+	halfLife time.Duration
+	// pending counters since the last fold at lastFold, in unix nanoseconds
+	hits     atomic.Int64
+	lookups  atomic.Int64
+	lastFold atomic.Int64
+
+	mu             sync.Mutex
+	decayedHits    float64
+	decayedLookups float64
+}
+
+func newHitRatio(halfLife time.Duration, now time.Time) *hitRatio {
+	if halfLife <= 0 {
+		halfLife = defaultHitRatioHalfLife
+	}
+	r := &hitRatio{halfLife: halfLife}
+	r.lastFold.Store(now.UnixNano())
+	return r
+}
+
+func (r *hitRatio) record(hit bool, now time.Time) {
+	if hit {
+		r.hits.Inc()
+	}
+	r.lookups.Inc()
+	if now.UnixNano()-r.lastFold.Load() >= int64(r.halfLife/8) && r.mu.TryLock() {
+		r.fold(now)
+		r.mu.Unlock()
+	}
+}
+
+// fold decays the counters to now and adds the pending ones, the caller must hold mu.
+func (r *hitRatio) fold(now time.Time) {
+	elapsed := now.UnixNano() - r.lastFold.Load()
+	decay := math.Exp2(-float64(elapsed) / float64(r.halfLife))
+	lookups, hits := r.lookups.Swap(0), r.hits.Swap(0)
+	r.decayedHits = r.decayedHits*decay + float64(hits)
+	r.decayedLookups = r.decayedLookups*decay + float64(lookups)
+	r.lastFold.Store(now.UnixNano())
+}
+
+// weights returns the decayed hits and lookups as of now.
+func (r *hitRatio) weights(now time.Time) (float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fold(now)
+	return r.decayedHits, r.decayedLookups
+}
+
+// ratioOf returns hits over lookups, 0 if there is no lookup.
+func ratioOf(hits, lookups float64) float64 {
+	if lookups <= 0 {
+		return 0
+	}
+	return hits / lookups
+}
+
+func (c *lruCache[K, V]) HitRatio() float64 {
+	return ratioOf(c.hitRatio.weights(c.clock.Now()))
+}
+
+func (c *shardedCache[K, V]) HitRatio() float64 {
+	var hits, lookups float64
+	for _, shard := range c.shards {
+		h, l := shard.hitRatio.weights(shard.clock.Now())
+		hits, lookups = hits+h, lookups+l
+	}
+	return ratioOf(hits, lookups)
+}
@@ -0,0 +1,43 @@
+package cache
+
+import "sync"
+
+// keyLocks serializes the writes of the same key, so that a slow write of one key never holds the cache lock
+// and blocks the others.
+//
+//	The lock of a key is taken before the cache lock and never while holding it. The zero value is ready to use.
+type keyLocks[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*keyLock
+}
+
+// keyLock is the lock of a key, dropped once no one holds or waits for it.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function unlocking it.
+func (l *keyLocks[K]) lock(key K) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[K]*keyLock)
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if kl.refs--; kl.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyLocks(t *testing.T) {
+	t.Run("test serialize same key", func(t *testing.T) {
+		var locks keyLocks[int]
+		unlock := locks.lock(1)
+		locked := make(chan struct{})
+		go func() {
+			defer close(locked)
+			locks.lock(1)()
+		}()
+		select {
+		case <-locked:
+			t.Fatal("key locked twice")
+		case <-time.After(10 * time.Millisecond):
+		}
+		// other keys are not blocked
+		locks.lock(2)()
+		unlock()
+		<-locked
+		assert.Empty(t, locks.locks)
+	})
+
+	t.Run("test write through ordered", func(t *testing.T) {
+		var mu sync.Mutex
+		store := make(map[int]int)
+		cache := NewCacheBuilder[int, int]().WithWriteThrough(func(key, value int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			store[key] = value
+			return nil
+		}).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					assert.NoError(t, cache.Put(j%4, i*100+j))
+				}
+			}(i)
+		}
+		wg.Wait()
+		// the store and the cache agree on the last write of each key
+		for key, value := range store {
+			v, ok := cache.GetIfPresent(key)
+			assert.True(t, ok)
+			assert.Equal(t, value, v)
+		}
+	})
+
+	t.Run("test slow write blocks no other key", func(t *testing.T) {
+		writing := make(chan struct{})
+		unblock := make(chan struct{})
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithWriteThrough(func(key, value int) error {
+			close(writing)
+			<-unblock
+			return nil
+		}).Build()
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+
+		swapped := make(chan bool)
+		go func() {
+			swapped <- cache.CompareAndSwap(1, 1, 10, func(a, b int) bool { return a == b })
+		}()
+		<-writing
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		close(unblock)
+		assert.True(t, <-swapped)
+		v, ok := cache.GetIfPresent(1)
+		assert.True(t, ok)
+		assert.Equal(t, 10, v)
+	})
+}
+
+// benchmarkCrossKey measures Do of hot keys, alongside slow swaps of another key if slowWrite is set.
+func benchmarkCrossKey(b *testing.B, slowWrite bool) {
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithWriteThrough(func(key, value int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}).WithCapacity(1024).Build()
+	for i := 0; i < 512; i++ {
+		cache.Do(i, func(v int) error { return nil })
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if slowWrite {
+		cache.Put(-1, 0)
+		go func() {
+			for v := 0; ; v++ {
+				select {
+				case <-done:
+					return
+				default:
+					cache.CompareAndSwap(-1, v, v+1, func(a, b int) bool { return a == b })
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Do(i%512, func(v int) error { return nil })
+			i++
+		}
+	})
+}
+
+func BenchmarkCrossKey(b *testing.B) {
+	b.Run("idle", func(b *testing.B) { benchmarkCrossKey(b, false) })
+	b.Run("slow write", func(b *testing.B) { benchmarkCrossKey(b, true) })
+}
@@ -0,0 +1,45 @@
+package cache
+
+// RemovalCause tells why an entry left the cache.
+type RemovalCause int32
+
+const (
+	// Evicted means the entry is evicted to make room, or it does not fit in the cache at all.
+	Evicted RemovalCause = iota + 1
+	// Expired means the entry is dropped after its ttl or idle timeout elapsed.
+	Expired
+	// Replaced means the value is replaced by Put or by a refresh.
+	Replaced
+	// Explicit means the entry is removed by Remove or Clear.
+	Explicit
+	// Abandoned means the value is returned by the loader after the load timed out, or after the load is abandoned
+	// by its waiters and superseded by a new one, it is never cached.
+	Abandoned
+)
+
+func (c RemovalCause) String() string {
+	switch c {
+	case Evicted:
+		return "Evicted"
+	case Expired:
+		return "Expired"
+	case Replaced:
+		return "Replaced"
+	case Explicit:
+		return "Explicit"
+	case Abandoned:
+		return "Abandoned"
+	default:
+		return "Unknown"
+	}
+}
+
+// Eviction is an entry left the cache, published to the channel of WithEvictionChannel.
+type Eviction[K comparable, V any] struct {
+	Key   K
+	Value V
+	Cause RemovalCause
+}
+
+// EvictionListener is notified of every entry leaving the cache along with the cause, right after the finalizer.
+type EvictionListener[K comparable, V any] func(key K, value V, cause RemovalCause)
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type removal struct {
+	key   int
+	value int
+	cause RemovalCause
+}
+
+func TestEvictionListener(t *testing.T) {
+	clock := newFakeClock()
+	removals := make([]removal, 0)
+	finalized := 0
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalized++
+		return nil
+	}).WithEvictionListener(func(key, value int, cause RemovalCause) {
+		removals = append(removals, removal{key, value, cause})
+	}).WithCapacity(2).WithTTL(50 * time.Millisecond).WithClock(clock).Build()
+
+	assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+	assert.NoError(t, cache.Put(1, 10))
+	assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+	assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+	assert.True(t, cache.Remove(2))
+	clock.Advance(60 * time.Millisecond)
+	assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+	assert.NoError(t, cache.Clear())
+
+	assert.Equal(t, []removal{
+		{1, 1, Replaced},
+		{1, 10, Evicted},
+		{2, 2, Explicit},
+		{3, 3, Expired},
+		{3, 3, Explicit},
+	}, removals)
+	assert.Equal(t, 5, finalized)
+	assert.Equal(t, "Expired", Expired.String())
+}
+
+func TestEvictionChannel(t *testing.T) {
+	ch := make(chan Eviction[int, int], 2)
+	cache := NewCacheBuilder[int, int]().WithEvictionChannel(ch).WithCapacity(2).WithShards(2).Build()
+
+	assert.NoError(t, cache.Put(1, 1))
+	assert.NoError(t, cache.Put(1, 10))
+	assert.True(t, cache.Remove(1))
+	assert.Equal(t, Eviction[int, int]{Key: 1, Value: 1, Cause: Replaced}, <-ch)
+	assert.Equal(t, Eviction[int, int]{Key: 1, Value: 10, Cause: Explicit}, <-ch)
+
+	// nobody consumes, the evictions beyond the channel capacity are dropped without blocking
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, cache.Put(1, i))
+	}
+	assert.Len(t, ch, 2)
+	assert.EqualValues(t, 2, cache.Stats().DroppedEvictions)
+	assert.Equal(t, 0, (<-ch).Value)
+}
+
+func TestPostEvictionHook(t *testing.T) {
+	for name, shards := range map[string]int{"lru": 1, "sharded": 2} {
+		t.Run("test post eviction hook "+name, func(t *testing.T) {
+			var mu sync.Mutex
+			finalized := make(map[int]bool)
+			removals := make([]removal, 0)
+			cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+				mu.Lock()
+				defer mu.Unlock()
+				finalized[key] = true
+				return nil
+			}).WithPostEvictionHook(func(key int, cause RemovalCause) {
+				mu.Lock()
+				defer mu.Unlock()
+				// called after the finalizer
+				removals = append(removals, removal{key, 0, cause})
+				if !finalized[key] {
+					t.Errorf("key %d not finalized before the hook", key)
+				}
+				if key == 1 {
+					panic("hook failure")
+				}
+			}).WithCapacity(4).WithShards(shards).Build()
+
+			assert.NoError(t, cache.Put(1, 1))
+			assert.NoError(t, cache.Put(1, 10))
+			assert.NoError(t, cache.Put(2, 2))
+			assert.True(t, cache.Remove(2))
+			// the cache survives the panic of the hook
+			assert.NoError(t, cache.Close())
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.ElementsMatch(t, []removal{
+				{1, 0, Replaced},
+				{2, 0, Explicit},
+				{1, 0, Explicit},
+			}, removals)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// loadContext is the context of a load shared by its waiters, it carries the values of the context starting the load.
+//
+//	Its deadline is the latest one of the waiters, there is none if a waiter has no deadline. It is canceled once
+//	all the waiters are gone, unless one of them could never go, i.e. its context is never done, or the load is a refresh.
+type loadContext struct {
+	// values only
+	context.Context
+
+	mu          sync.Mutex
+	done        chan struct{}
+	err         error
+	deadline    time.Time
+	hasDeadline bool
+	timer       *time.Timer
+	// pinned tells whether a waiter never goes away
+	pinned bool
+}
+
+func newLoadContext(ctx context.Context) *loadContext {
+	lc := &loadContext{Context: ctx, done: make(chan struct{})}
+	lc.hasDeadline = true
+	lc.join(ctx)
+	return lc
+}
+
+// join merges the deadline of a new waiter, the first waiter is merged by newLoadContext.
+func (lc *loadContext) join(ctx context.Context) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if ctx.Done() == nil {
+		lc.pinned = true
+	}
+	if lc.err != nil || !lc.hasDeadline {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		lc.hasDeadline = false
+		if lc.timer != nil {
+			lc.timer.Stop()
+		}
+		return
+	}
+	if !lc.deadline.IsZero() && !deadline.After(lc.deadline) {
+		return
+	}
+	lc.deadline = deadline
+	if lc.timer == nil {
+		lc.timer = time.AfterFunc(time.Until(deadline), func() { lc.cancel(context.DeadlineExceeded) })
+	} else {
+		lc.timer.Reset(time.Until(deadline))
+	}
+}
+
+// abandonable tells whether the load could be canceled once its waiters are gone.
+func (lc *loadContext) abandonable() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return !lc.pinned
+}
+
+// cancel makes the context done with err, it is a no-op if done already.
+func (lc *loadContext) cancel(err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.err != nil {
+		return
+	}
+	lc.err = err
+	close(lc.done)
+	if lc.timer != nil {
+		lc.timer.Stop()
+	}
+}
+
+// stop ends the context once the load returns, so that whatever the loader left waiting on it is released.
+//
+//	It is canceled right away if there is no deadline, or else it is left to the deadline timer, so that a loader
+//	abandoned on timeout still sees DeadlineExceeded as its own deadline passes, rather than Canceled before that.
+func (lc *loadContext) stop() {
+	lc.mu.Lock()
+	hasDeadline := lc.hasDeadline
+	lc.mu.Unlock()
+	if !hasDeadline {
+		lc.cancel(context.Canceled)
+	}
+}
+
+func (lc *loadContext) Deadline() (time.Time, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.deadline, lc.hasDeadline
+}
+
+func (lc *loadContext) Done() <-chan struct{} {
+	return lc.done
+}
+
+func (lc *loadContext) Err() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.err
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// KeyErrors reports the keys DoMany failed to get, with the error of each.
+type KeyErrors[K comparable] map[K]error
+
+func (e KeyErrors[K]) Error() string {
+	msgs := make([]string, 0, len(e))
+	for key, err := range e {
+		msgs = append(msgs, fmt.Sprintf("key %v: %s", key, err.Error()))
+	}
+	return fmt.Sprintf("failed to get %d keys: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// orNil returns e if there is any key failed, or else nil, so that no error is a nil interface.
+func (e KeyErrors[K]) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (c *lruCache[K, V]) DoMany(keys []K, fn func(map[K]V) error) error {
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	items, errs := c.pinMany(keys)
+	return doMany(items, errs, fn, c.unpin, c.copier)
+}
+
+// pinMany pins the items of keys, the missing ones are loaded concurrently, or in batch with the batch loader.
+func (c *lruCache[K, V]) pinMany(keys []K) (map[K]*cacheItem[K, V], KeyErrors[K]) {
+	items := make(map[K]*cacheItem[K, V], len(keys))
+	errs := make(KeyErrors[K])
+	missing := make(map[K]struct{})
+
+	// Peek all the keys within a single lock.
+	c.rwlock.Lock()
+	for _, key := range keys {
+		if _, ok := items[key]; ok {
+			continue
+		}
+		c.recordAccess(key)
+		if item := c.lockfreePeek(key); item != nil {
+			items[key] = item
+		} else {
+			missing[key] = struct{}{}
+		}
+	}
+	c.rwlock.Unlock()
+	for _, item := range items {
+		c.stats.hits.Inc()
+		c.refreshIfNeeded(item)
+	}
+	if c.batchLoader != nil {
+		c.batchPin(missing, items, errs)
+		return items, errs
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for key := range missing {
+		wg.Add(1)
+		go func(key K) {
+			defer wg.Done()
+			// Missed by the peek above already, which has recorded the access.
+			item, err := c.loadAndPin(context.Background(), key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err
+			} else {
+				items[key] = item
+			}
+		}(key)
+	}
+	wg.Wait()
+	return items, errs
+}
+
+// batchPin loads the missing keys with a single call of the batch loader, the keys being loaded by others are joined.
+func (c *lruCache[K, V]) batchPin(missing map[K]struct{}, items map[K]*cacheItem[K, V], errs KeyErrors[K]) {
+	calls := make(map[K]*loadCall[K, V], len(missing))
+	keys := make([]K, 0, len(missing))
+	c.rwlock.Lock()
+	for key := range missing {
+		c.stats.misses.Inc()
+		if c.closed.Load() {
+			errs[key] = ErrCacheClosed
+			continue
+		}
+		// The key may be loaded by others since peek.
+		if item := c.lockfreePeek(key); item != nil {
+			items[key] = item
+			continue
+		}
+		if err := c.lockfreeRememberedFailure(key); err != nil {
+			errs[key] = err
+			continue
+		}
+		call, loading := c.inflightLoad(key)
+		if !loading {
+			var err error
+			if call, err = c.startLoad(context.Background(), key); err != nil {
+				errs[key] = err
+				continue
+			}
+			keys = append(keys, key)
+		} else {
+			call.ctx.join(context.Background())
+		}
+		call.waiters++
+		calls[key] = call
+	}
+	c.rwlock.Unlock()
+
+	if len(keys) > 0 {
+		c.batchLoad(keys, calls)
+	}
+
+	for key, call := range calls {
+		<-call.done
+		if call.err != nil {
+			errs[key] = call.err
+		} else {
+			items[key] = call.item
+		}
+	}
+}
+
+// batchLoad loads keys with a single call of the batch loader, and settles their load calls.
+func (c *lruCache[K, V]) batchLoad(keys []K, calls map[K]*loadCall[K, V]) {
+	var values map[K]V
+	err := c.acquireLoad(context.Background())
+	if err == nil {
+		err = ErrLoaderUnavailable
+		if probe, ok := c.breaker.allow(); ok {
+			values, err = c.batchLoadWithTimeout(keys)
+			c.breaker.report(probe, err)
+		}
+		c.releaseLoad()
+	}
+	invalid := make(map[K]error)
+	for key, value := range values {
+		if verr := c.validate(key, value); verr != nil {
+			invalid[key] = verr
+		}
+	}
+
+	c.rwlock.Lock()
+	var victims []*cacheItem[K, V]
+	defer func() { c.finalizeVictims(victims) }()
+	defer func() {
+		for _, key := range keys {
+			close(calls[key].done)
+		}
+	}()
+	defer c.rwlock.Unlock()
+	for _, key := range keys {
+		value, ok := values[key]
+		keyErr := err
+		if keyErr == nil && !ok {
+			keyErr = ErrNoSuchItem
+		}
+		if verr, ok := invalid[key]; ok && keyErr == nil {
+			keyErr = verr
+		}
+		c.finishLoad(key, calls[key], value, 0, keyErr)
+	}
+	victims = c.takeVictims()
+}
+
+// doMany calls fn with the values of pinned items, copied by copier if set, and unpins them after,
+// the error of fn takes precedence over the failures of keys.
+func doMany[K comparable, V any](items map[K]*cacheItem[K, V], errs KeyErrors[K], fn func(map[K]V) error, unpin func(*cacheItem[K, V]), copier func(V) V) error {
+	values := make(map[K]V, len(items))
+	for key, item := range items {
+		values[key] = item.Value()
+		if copier != nil {
+			values[key] = copier(values[key])
+		}
+	}
+	defer func() {
+		for _, item := range items {
+			unpin(item)
+		}
+	}()
+
+	if err := fn(values); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *shardedCache[K, V]) DoMany(keys []K, fn func(map[K]V) error) error {
+	if c.shards[0].closed.Load() {
+		return ErrCacheClosed
+	}
+	byShard := make(map[*lruCache[K, V]][]K)
+	for _, key := range keys {
+		shard := c.shard(key)
+		byShard[shard] = append(byShard[shard], key)
+	}
+
+	items := make(map[K]*cacheItem[K, V], len(keys))
+	owners := make(map[*cacheItem[K, V]]*lruCache[K, V], len(keys))
+	errs := make(KeyErrors[K])
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for shard, keys := range byShard {
+		wg.Add(1)
+		go func(shard *lruCache[K, V], keys []K) {
+			defer wg.Done()
+			shardItems, shardErrs := shard.pinMany(keys)
+			mu.Lock()
+			defer mu.Unlock()
+			for key, item := range shardItems {
+				items[key] = item
+				owners[item] = shard
+			}
+			for key, err := range shardErrs {
+				errs[key] = err
+			}
+		}(shard, keys)
+	}
+	wg.Wait()
+	return doMany(items, errs, fn, func(item *cacheItem[K, V]) {
+		owners[item].unpin(item)
+	}, c.shards[0].copier)
+}
+
+func (c *lruCache[K, V]) GetAllPresent(keys []K) map[K]V {
+	values := make(map[K]V, len(keys))
+	c.getAllPresent(keys, values)
+	return values
+}
+
+// getAllPresent puts the cached values of keys into values within a single lock.
+func (c *lruCache[K, V]) getAllPresent(keys []K, values map[K]V) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	now := c.clock.Now()
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		c.recordAccess(key)
+		item, ok := c.items[key]
+		if !ok || item.expired(now) {
+			c.stats.misses.Inc()
+			continue
+		}
+		c.access(item, now)
+		c.stats.hits.Inc()
+		values[key] = c.copy(item.value)
+	}
+}
+
+func (c *shardedCache[K, V]) GetAllPresent(keys []K) map[K]V {
+	byShard := make(map[*lruCache[K, V]][]K)
+	for _, key := range keys {
+		shard := c.shard(key)
+		byShard[shard] = append(byShard[shard], key)
+	}
+	values := make(map[K]V, len(keys))
+	for shard, keys := range byShard {
+		shard.getAllPresent(keys, values)
+	}
+	return values
+}
+
+func (c *lruCache[K, V]) Warmup(keys []K, concurrency int) error {
+	return warmup[K, V](c, keys, concurrency)
+}
+
+func (c *shardedCache[K, V]) Warmup(keys []K, concurrency int) error {
+	return warmup[K, V](c, keys, concurrency)
+}
+
+// warmup loads keys by Do with bounded concurrency, a key repeated is loaded once.
+func warmup[K comparable, V any](c Cache[K, V], keys []K, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(KeyErrors[K])
+	sem := make(chan struct{}, concurrency)
+	seen := make(map[K]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key K) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.Do(key, func(V) error { return nil }); err != nil {
+				mu.Lock()
+				errs[key] = err
+				mu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
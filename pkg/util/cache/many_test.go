@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoMany(t *testing.T) {
+	loadErr := errors.New("load error")
+	newBuilder := func(loadCnt *atomic.Int32) *CacheBuilder[int, int] {
+		return NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			loadCnt.Add(1)
+			switch {
+			case key < 0:
+				return 0, ErrNoSuchItem
+			case key >= 100:
+				return 0, loadErr
+			}
+			return key * 10, nil
+		}).WithCapacity(10)
+	}
+
+	for name, shards := range map[string]int{"lru": 1, "sharded": 4} {
+		t.Run("test do many "+name, func(t *testing.T) {
+			loadCnt := new(atomic.Int32)
+			cache := newBuilder(loadCnt).WithShards(shards).Build()
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+
+			called := 0
+			err := cache.DoMany([]int{1, 2, 3, 3}, func(values map[int]int) error {
+				called++
+				assert.Equal(t, map[int]int{1: 10, 2: 20, 3: 30}, values)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 1, called)
+			assert.EqualValues(t, 3, loadCnt.Load())
+			assert.Equal(t, 3, cache.Len())
+
+			// partial failures are reported by key
+			err = cache.DoMany([]int{1, -1, 100}, func(values map[int]int) error {
+				assert.Equal(t, map[int]int{1: 10}, values)
+				return nil
+			})
+			var keyErrs KeyErrors[int]
+			assert.True(t, errors.As(err, &keyErrs))
+			assert.Len(t, keyErrs, 2)
+			assert.ErrorIs(t, keyErrs[-1], ErrNoSuchItem)
+			assert.ErrorIs(t, keyErrs[100], loadErr)
+
+			// error of fn takes precedence
+			fnErr := errors.New("fn error")
+			assert.Equal(t, fnErr, cache.DoMany([]int{1, 100}, func(values map[int]int) error {
+				return fnErr
+			}))
+
+			// entries are unpinned after
+			for i := 10; i < 20; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			_, ok := cache.GetIfPresent(1)
+			assert.False(t, ok)
+		})
+	}
+
+	t.Run("test batch loader", func(t *testing.T) {
+		batches := make([][]int, 0)
+		loadErr := errors.New("load error")
+		cache := NewCacheBuilder[int, int]().WithBatchLoader(func(keys []int) (map[int]int, error) {
+			sorted := append([]int{}, keys...)
+			sort.Ints(sorted)
+			batches = append(batches, sorted)
+			values := make(map[int]int)
+			for _, key := range keys {
+				if key >= 100 {
+					return nil, loadErr
+				}
+				if key >= 0 {
+					values[key] = key * 10
+				}
+			}
+			return values, nil
+		}).WithCapacity(10).Build()
+
+		// Do loads with a single key
+		assert.NoError(t, cache.Do(1, func(v int) error {
+			assert.Equal(t, 10, v)
+			return nil
+		}))
+		assert.Equal(t, ErrNoSuchItem, cache.Do(-1, func(v int) error { return nil }))
+		assert.Equal(t, [][]int{{1}, {-1}}, batches)
+
+		batches = batches[:0]
+		err := cache.DoMany([]int{1, 2, 3, -2}, func(values map[int]int) error {
+			assert.Equal(t, map[int]int{1: 10, 2: 20, 3: 30}, values)
+			return nil
+		})
+		var keyErrs KeyErrors[int]
+		assert.True(t, errors.As(err, &keyErrs))
+		assert.Equal(t, KeyErrors[int]{-2: ErrNoSuchItem}, keyErrs)
+		// only the missing keys are loaded, with a single call
+		assert.Equal(t, [][]int{{-2, 2, 3}}, batches)
+
+		// an error fails all keys of the call
+		err = cache.DoMany([]int{4, 100}, func(values map[int]int) error {
+			assert.Empty(t, values)
+			return nil
+		})
+		assert.True(t, errors.As(err, &keyErrs))
+		assert.Len(t, keyErrs, 2)
+		assert.ErrorIs(t, keyErrs[4], loadErr)
+		assert.EqualValues(t, 3, cache.Stats().LoadSuccesses)
+	})
+}
+
+func TestGetAllPresent(t *testing.T) {
+	for name, shards := range map[string]int{"lru": 1, "sharded": 4} {
+		t.Run("test get all present "+name, func(t *testing.T) {
+			loadCnt := new(atomic.Int32)
+			clock := newFakeClock()
+			cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+				loadCnt.Add(1)
+				return key * 10, nil
+			}).WithTTL(time.Second).WithClock(clock).WithShards(shards).Build()
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+			clock.Advance(time.Second / 2)
+			assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+			assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+
+			assert.Equal(t, map[int]int{1: 10, 2: 20, 3: 30}, cache.GetAllPresent([]int{1, 2, 3, 3, 4}))
+			assert.EqualValues(t, 3, loadCnt.Load())
+			assert.EqualValues(t, 3, cache.Stats().Hits)
+			assert.EqualValues(t, 4, cache.Stats().Misses)
+
+			// 1 expires
+			clock.Advance(time.Second / 2)
+			assert.Equal(t, map[int]int{2: 20, 3: 30}, cache.GetAllPresent([]int{1, 2, 3}))
+			assert.Empty(t, cache.GetAllPresent(nil))
+		})
+	}
+}
+
+func TestPutAll(t *testing.T) {
+	for name, shards := range map[string]int{"lru": 1, "sharded": 2} {
+		t.Run("test put all "+name, func(t *testing.T) {
+			finalized := make([]int, 0)
+			var mu sync.Mutex
+			cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+				mu.Lock()
+				defer mu.Unlock()
+				finalized = append(finalized, value)
+				return nil
+			}).WithCapacity(4).WithShards(shards).Build()
+
+			assert.NoError(t, cache.PutAll(map[int]int{1: 1, 2: 2}))
+			assert.NoError(t, cache.PutAll(map[int]int{2: 20}))
+			v, _ := cache.GetIfPresent(2)
+			assert.Equal(t, 20, v)
+			assert.Equal(t, []int{2}, finalized)
+
+			// too many to fit at all, each key is either put or reported
+			items := make(map[int]int)
+			for i := 10; i < 20; i++ {
+				items[i] = i
+			}
+			err := cache.PutAll(items)
+			if shards == 1 {
+				assert.Equal(t, ErrNotEnoughSpace, err)
+				assert.ElementsMatch(t, []int{1, 2}, cache.SnapshotKeys())
+				assert.Equal(t, []int{2}, finalized)
+			} else {
+				var keyErrs KeyErrors[int]
+				assert.True(t, errors.As(err, &keyErrs))
+				for key := range items {
+					_, failed := keyErrs[key]
+					assert.NotEqual(t, failed, cache.Contains(key))
+				}
+			}
+
+			assert.NoError(t, cache.Close())
+			assert.Equal(t, ErrCacheClosed, cache.PutAll(map[int]int{1: 1}))
+		})
+	}
+
+	t.Run("test pinned", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithCapacity(2).Build()
+		assert.NoError(t, cache.Put(1, 1))
+		_, release, err := cache.Acquire(1)
+		assert.NoError(t, err)
+		defer release()
+
+		// only one of the two fits beside the pinned one
+		err = cache.PutAll(map[int]int{2: 2, 3: 3})
+		var keyErrs KeyErrors[int]
+		assert.True(t, errors.As(err, &keyErrs))
+		assert.Len(t, keyErrs, 1)
+		for _, err := range keyErrs {
+			assert.Equal(t, ErrNotEnoughSpace, err)
+		}
+		assert.Equal(t, 2, cache.Len())
+	})
+
+	t.Run("test write through", func(t *testing.T) {
+		writeErr := errors.New("write error")
+		written := make(map[int]int)
+		cache := NewCacheBuilder[int, int]().WithWriteThrough(func(key, value int) error {
+			if key < 0 {
+				return writeErr
+			}
+			written[key] = value
+			return nil
+		}).Build()
+		err := cache.PutAll(map[int]int{1: 1, -1: -1})
+		assert.Equal(t, KeyErrors[int]{-1: writeErr}, err)
+		assert.Equal(t, map[int]int{1: 1}, written)
+		assert.ElementsMatch(t, []int{1}, cache.SnapshotKeys())
+	})
+}
+
+func TestWarmup(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		running := new(atomic.Int32)
+		peak := new(atomic.Int32)
+		loads := new(atomic.Int32)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			loads.Add(1)
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return key, key%10 != 0
+		}).WithCapacity(100).WithShards(shards).Build()
+
+		keys := make([]int, 0)
+		for i := 1; i <= 30; i++ {
+			keys = append(keys, i, i)
+		}
+		err := cache.Warmup(keys, 3)
+		var keyErrs KeyErrors[int]
+		assert.True(t, errors.As(err, &keyErrs))
+		assert.Len(t, keyErrs, 3)
+		assert.ErrorIs(t, keyErrs[10], ErrNoSuchItem)
+		assert.EqualValues(t, 30, loads.Load())
+		assert.LessOrEqual(t, peak.Load(), int32(3))
+		assert.Equal(t, 27, cache.Len())
+		assert.NoError(t, cache.Warmup([]int{1, 2}, 0))
+		assert.EqualValues(t, 30, loads.Load())
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+// namespaceUsage accounts the usage of each namespace against its quota.
+//
+//	A nil namespaceUsage accounts nothing. It is not thread safe, the owner cache holds the lock.
+type namespaceUsage struct {
+	// quota returns the quota of a namespace, <= 0 means unlimited
+	quota func(namespace string) int64
+	usage map[string]int64
+}
+
+func newNamespaceUsage(quota func(namespace string) int64) *namespaceUsage {
+	if quota == nil {
+		quota = func(string) int64 { return 0 }
+	}
+	return &namespaceUsage{
+		quota: quota,
+		usage: make(map[string]int64),
+	}
+}
+
+func (u *namespaceUsage) add(namespace string, cost int64) {
+	if u == nil {
+		return
+	}
+	if u.usage[namespace] += cost; u.usage[namespace] == 0 {
+		delete(u.usage, namespace)
+	}
+}
+
+// over tells whether the namespace is over its quota with extra added to its usage.
+func (u *namespaceUsage) over(namespace string, extra int64) bool {
+	quota := u.quota(namespace)
+	return quota > 0 && u.usage[namespace]+extra > quota
+}
+
+// pickOverQuota picks victims of the namespaces over their quota in eviction order, the namespace of key counts cost in,
+// until collector is done. The caller must hold the lock.
+func (c *lruCache[K, V]) pickOverQuota(key K, cost int64, collector func(int64) bool) ([]*cacheItem[K, V], bool) {
+	namespace := c.namespacer(key)
+	planned := make(map[string]int64)
+	toEvict := make([]*cacheItem[K, V], 0)
+	done := false
+	c.evictor.victims(func(n *policyNode) bool {
+		evictItem := n.owner.(*cacheItem[K, V])
+		if !c.evictable(evictItem) || evictItem.key == key {
+			return true
+		}
+		extra := -planned[evictItem.namespace]
+		if evictItem.namespace == namespace {
+			extra += cost
+		}
+		if !c.namespaces.over(evictItem.namespace, extra) {
+			return true
+		}
+		planned[evictItem.namespace] += evictItem.cost
+		toEvict = append(toEvict, evictItem)
+		done = collector(evictItem.cost)
+		return !done
+	})
+	return toEvict, done
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	tenant := func(key string) string {
+		return strings.SplitN(key, "/", 2)[0]
+	}
+
+	t.Run("test noisy namespace", func(t *testing.T) {
+		evicted := make([]string, 0)
+		cache := NewCacheBuilder[string, int]().WithEvictionListener(func(key string, value int, cause RemovalCause) {
+			evicted = append(evicted, key)
+		}).WithNamespacer(tenant).WithNamespaceQuota(func(namespace string) int64 {
+			return 5
+		}).WithCapacity(10).Build()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Put(fmt.Sprintf("a/%d", i), i))
+		}
+		// b may grow beyond its quota while there is room
+		for i := 0; i < 7; i++ {
+			assert.NoError(t, cache.Put(fmt.Sprintf("b/%d", i), i))
+		}
+		assert.EqualValues(t, 7, cache.(*lruCache[string, int]).namespaces.usage["b"])
+
+		// b is over quota, it gives up its entries though a is older
+		for i := 7; i < 10; i++ {
+			assert.NoError(t, cache.Put(fmt.Sprintf("b/%d", i), i))
+		}
+		assert.Equal(t, []string{"b/0", "b/1", "b/2"}, evicted)
+		for i := 0; i < 3; i++ {
+			assert.True(t, cache.Contains(fmt.Sprintf("a/%d", i)))
+		}
+
+		// a within its quota takes back room from b
+		assert.NoError(t, cache.Put("a/3", 3))
+		assert.Equal(t, "b/3", evicted[3])
+		assert.EqualValues(t, 4, cache.(*lruCache[string, int]).namespaces.usage["a"])
+		assert.EqualValues(t, 6, cache.(*lruCache[string, int]).namespaces.usage["b"])
+
+		// the pinned entries over quota fall back to the eviction order
+		for i := 4; i < 10; i++ {
+			cache.Pin(fmt.Sprintf("b/%d", i))
+		}
+		assert.NoError(t, cache.Put("a/4", 4))
+		assert.Equal(t, "a/0", evicted[4])
+
+		cache.Clear()
+		assert.Empty(t, cache.(*lruCache[string, int]).namespaces.usage)
+	})
+
+	t.Run("test unlimited", func(t *testing.T) {
+		evicted := make([]string, 0)
+		cache := NewCacheBuilder[string, int]().WithEvictionListener(func(key string, value int, cause RemovalCause) {
+			evicted = append(evicted, key)
+		}).WithNamespacer(tenant).WithCapacity(2).Build()
+		assert.NoError(t, cache.Put("a/0", 0))
+		assert.NoError(t, cache.Put("b/0", 0))
+		assert.NoError(t, cache.Put("b/1", 1))
+		assert.Equal(t, []string{"a/0"}, evicted)
+	})
+
+	t.Run("test sharded", func(t *testing.T) {
+		cache := NewCacheBuilder[string, int]().WithNamespacer(tenant).WithNamespaceQuota(func(namespace string) int64 {
+			return 2
+		}).WithCapacity(40).WithShards(4).Build()
+		quotas := make([]int64, 0)
+		for _, shard := range cache.(*shardedCache[string, int]).shards {
+			quotas = append(quotas, shard.namespaces.quota("a"))
+		}
+		assert.Equal(t, []int64{1, 1, 1, 1}, quotas)
+	})
+}
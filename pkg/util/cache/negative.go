@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// negativeCache remembers keys not found by the loader for a ttl, up to capacity keys, or the keys failed to load
+// along with their errors.
+//
+//	The oldest tombstone is dropped once the capacity is reached, so that probing random keys takes bounded memory.
+//	A nil negativeCache remembers nothing. It is not thread safe, the owner cache holds the lock.
+type negativeCache[K comparable] struct {
+	ttl      time.Duration
+	capacity int
+	entries  map[K]*list.Element
+	// tombstones in order of insertion, the oldest at front
+	order *list.List
+}
+
+type tombstone[K comparable] struct {
+	key      K
+	expireAt time.Time
+	// err is the load error remembered, nil for a key not found
+	err error
+}
+
+func newNegativeCache[K comparable](ttl time.Duration, capacity int) *negativeCache[K] {
+	if ttl <= 0 || capacity <= 0 {
+		return nil
+	}
+	return &negativeCache[K]{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// contains tests if key has an unexpired tombstone, an expired one is dropped.
+func (n *negativeCache[K]) contains(key K, now time.Time) bool {
+	return n.lookup(key, now) != nil
+}
+
+// lookup returns the unexpired tombstone of key, or nil if there is none, an expired one is dropped.
+func (n *negativeCache[K]) lookup(key K, now time.Time) *tombstone[K] {
+	if n == nil {
+		return nil
+	}
+	elem, ok := n.entries[key]
+	if !ok {
+		return nil
+	}
+	stone := elem.Value.(*tombstone[K])
+	if !now.Before(stone.expireAt) {
+		n.remove(key)
+		return nil
+	}
+	return stone
+}
+
+func (n *negativeCache[K]) add(key K, now time.Time) {
+	n.addError(key, now, nil)
+}
+
+// addError remembers key along with the error failing its load.
+func (n *negativeCache[K]) addError(key K, now time.Time, err error) {
+	if n == nil {
+		return
+	}
+	n.remove(key)
+	for n.order.Len() >= n.capacity {
+		n.remove(n.order.Front().Value.(*tombstone[K]).key)
+	}
+	n.entries[key] = n.order.PushBack(&tombstone[K]{key: key, expireAt: now.Add(n.ttl), err: err})
+}
+
+func (n *negativeCache[K]) remove(key K) {
+	if n == nil {
+		return
+	}
+	if elem, ok := n.entries[key]; ok {
+		n.order.Remove(elem)
+		delete(n.entries, key)
+	}
+}
+
+func (n *negativeCache[K]) clear() {
+	if n == nil {
+		return
+	}
+	n.entries = make(map[K]*list.Element)
+	n.order.Init()
+}
+
+func (n *negativeCache[K]) len() int {
+	if n == nil {
+		return 0
+	}
+	return n.order.Len()
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegativeCache(t *testing.T) {
+	t.Run("test expire", func(t *testing.T) {
+		now := time.Now()
+		n := newNegativeCache[int](time.Second, 10)
+		n.add(1, now)
+		assert.True(t, n.contains(1, now.Add(999*time.Millisecond)))
+		assert.False(t, n.contains(1, now.Add(time.Second)))
+		assert.Equal(t, 0, n.len())
+	})
+
+	t.Run("test capacity", func(t *testing.T) {
+		now := time.Now()
+		n := newNegativeCache[int](time.Second, 2)
+		for i := 0; i < 100; i++ {
+			n.add(i, now)
+			assert.LessOrEqual(t, n.len(), 2)
+		}
+		assert.False(t, n.contains(97, now))
+		assert.True(t, n.contains(98, now))
+		assert.True(t, n.contains(99, now))
+
+		// re-adding renews the tombstone
+		n.add(98, now)
+		n.add(100, now)
+		assert.True(t, n.contains(98, now))
+		assert.False(t, n.contains(99, now))
+	})
+
+	t.Run("test remove and clear", func(t *testing.T) {
+		now := time.Now()
+		n := newNegativeCache[int](time.Second, 10)
+		n.add(1, now)
+		n.add(2, now)
+		n.remove(1)
+		assert.False(t, n.contains(1, now))
+		n.clear()
+		assert.False(t, n.contains(2, now))
+		assert.Equal(t, 0, n.len())
+	})
+
+	t.Run("test disabled", func(t *testing.T) {
+		n := newNegativeCache[int](0, 10)
+		assert.Nil(t, n)
+		n.add(1, time.Now())
+		assert.False(t, n.contains(1, time.Now()))
+		assert.Equal(t, 0, n.len())
+	})
+}
+
+func TestErrorTTL(t *testing.T) {
+	clock := newFakeClock()
+	backendErr := errors.New("backend down")
+	loads := 0
+	found := true
+	failing := true
+	cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+		loads++
+		if !found {
+			return 0, ErrNoSuchItem
+		}
+		if failing {
+			return 0, backendErr
+		}
+		return key, nil
+	}).WithErrorTTL(time.Second).WithNegativeCaching(time.Second).WithClock(clock).Build()
+	doer := func(v int) error { return nil }
+
+	t.Run("test remember error", func(t *testing.T) {
+		err := cache.Do(1, doer)
+		assert.ErrorIs(t, err, backendErr)
+		assert.NotErrorIs(t, err, ErrCachedFailure)
+
+		// the error is re-returned without loading, and told from the live one
+		err = cache.Do(1, doer)
+		assert.ErrorIs(t, err, backendErr)
+		assert.ErrorIs(t, err, ErrCachedFailure)
+		assert.NotErrorIs(t, err, ErrNoSuchItem)
+		assert.Equal(t, 1, loads)
+	})
+
+	t.Run("test overwritten by success", func(t *testing.T) {
+		failing = false
+		clock.Advance(time.Second)
+		assert.NoError(t, cache.Do(1, doer))
+		assert.Equal(t, 2, loads)
+		assert.True(t, cache.Remove(1))
+		assert.NoError(t, cache.Do(1, doer))
+		assert.Equal(t, 3, loads)
+	})
+
+	t.Run("test not found", func(t *testing.T) {
+		found = false
+		err := cache.Do(2, doer)
+		assert.ErrorIs(t, err, ErrNoSuchItem)
+		err = cache.Do(2, doer)
+		assert.ErrorIs(t, err, ErrNoSuchItem)
+		assert.NotErrorIs(t, err, ErrCachedFailure)
+		assert.Equal(t, 4, loads)
+	})
+
+	t.Run("test forgotten by put", func(t *testing.T) {
+		found, failing = true, true
+		assert.ErrorIs(t, cache.Do(3, doer), backendErr)
+		assert.NoError(t, cache.Put(3, 30))
+		assert.True(t, cache.Remove(3))
+		failing = false
+		assert.NoError(t, cache.Do(3, doer))
+		assert.Equal(t, 6, loads)
+	})
+}
@@ -0,0 +1,417 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+)
+
+// Policy decides in which order entries are evicted when the cache runs out of room.
+//
+//	The built-in policies are LRU, which is the default, FIFO, LFU, CLOCK, SLRU, ARC and SampledLRU.
+//	The policies evict reproducibly, the same operations evict the same entries in the same order, unless an admission
+//	policy or a ttl jitter, which are random, is set. When the policy's metric ties, the entry which reached the tied value first
+//	is evicted first, so the entries never hit are evicted in the order inserted, the smaller Info.InsertSeq first.
+//	E.g. LFU evicts the least recently hit of the entries with the same frequency, and the earliest inserted of the ones never hit,
+//	SLRU and ARC evict the earliest inserted of the entries in the probationary segment or in T1 never hit.
+//	SampledLRU draws its samples from a random source of a fixed seed.
+type Policy interface {
+	// newEvictor creates the evictor of a cache of capacity.
+	newEvictor(capacity int64) evictor
+}
+
+// evictor tracks entries of a cache for its policy, it is always called with the cache lock held.
+type evictor interface {
+	// add records a newly inserted entry.
+	add(n *policyNode)
+	// access records a hit of an entry.
+	access(n *policyNode)
+	// remove forgets an entry.
+	remove(n *policyNode)
+	// victims calls f with entries in eviction order until f returns false, it must not add or remove entries.
+	victims(f func(n *policyNode) bool)
+	// describe summarizes the internals for Dump.
+	describe() string
+}
+
+// policyNode is the per-entry bookkeeping shared by all policies.
+type policyNode struct {
+	owner  any // the *cacheItem this node belongs to
+	elem   *list.Element
+	bucket *list.Element // frequency bucket of lfu
+	// referenced is the reference bit of clock
+	referenced bool
+	// cost is the cost of the entry, protected tells whether it is in the protected segment of slru or in t2 of arc
+	cost      int64
+	protected bool
+	// hash is the hash of the key for a keyedEvictor, evicted tells whether the entry is removed for room
+	hash    uint64
+	evicted bool
+	// index is the position in the entries of sampled lru, stamp is the tick of the last access
+	index int
+	stamp uint64
+}
+
+type lruPolicy struct{}
+
+// LRU evicts the least recently used entry first.
+func LRU() Policy {
+	return lruPolicy{}
+}
+
+func (lruPolicy) newEvictor(int64) evictor {
+	return &lruEvictor{accessList: list.New()}
+}
+
+type lruEvictor struct {
+	accessList *list.List
+}
+
+func (e *lruEvictor) add(n *policyNode) {
+	n.elem = e.accessList.PushFront(n)
+}
+
+func (e *lruEvictor) access(n *policyNode) {
+	e.accessList.MoveToFront(n.elem)
+}
+
+func (e *lruEvictor) remove(n *policyNode) {
+	e.accessList.Remove(n.elem)
+}
+
+func (e *lruEvictor) describe() string {
+	return fmt.Sprintf("lru entries=%d", e.accessList.Len())
+}
+
+func (e *lruEvictor) victims(f func(n *policyNode) bool) {
+	for p := e.accessList.Back(); p != nil; p = p.Prev() {
+		if !f(p.Value.(*policyNode)) {
+			return
+		}
+	}
+}
+
+type fifoPolicy struct{}
+
+// FIFO evicts the earliest inserted entry first, hits reorder nothing, so it suits caches written once.
+//
+//	An entry replaced by Put or a reload counts as inserted anew.
+func FIFO() Policy {
+	return fifoPolicy{}
+}
+
+func (fifoPolicy) newEvictor(int64) evictor {
+	return &fifoEvictor{insertList: list.New()}
+}
+
+type fifoEvictor struct {
+	insertList *list.List
+}
+
+func (e *fifoEvictor) add(n *policyNode) {
+	n.elem = e.insertList.PushFront(n)
+}
+
+func (e *fifoEvictor) access(*policyNode) {}
+
+func (e *fifoEvictor) remove(n *policyNode) {
+	e.insertList.Remove(n.elem)
+}
+
+func (e *fifoEvictor) describe() string {
+	return fmt.Sprintf("fifo entries=%d", e.insertList.Len())
+}
+
+func (e *fifoEvictor) victims(f func(n *policyNode) bool) {
+	for p := e.insertList.Back(); p != nil; p = p.Prev() {
+		if !f(p.Value.(*policyNode)) {
+			return
+		}
+	}
+}
+
+type lfuPolicy struct{}
+
+// LFU evicts the least frequently used entry first, entries with the same frequency are evicted in LRU order.
+func LFU() Policy {
+	return lfuPolicy{}
+}
+
+func (lfuPolicy) newEvictor(int64) evictor {
+	return &lfuEvictor{buckets: list.New()}
+}
+
+// lfuEvictor keeps buckets of entries in ascending frequency, so that both access and eviction are O(1).
+type lfuEvictor struct {
+	// the value is *lfuBucket
+	buckets *list.List
+}
+
+type lfuBucket struct {
+	freq    int64
+	entries *list.List
+}
+
+func (e *lfuEvictor) add(n *policyNode) {
+	front := e.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = e.buckets.PushFront(&lfuBucket{freq: 1, entries: list.New()})
+	}
+	e.pushTo(front, n)
+}
+
+func (e *lfuEvictor) access(n *policyNode) {
+	cur := n.bucket
+	freq := cur.Value.(*lfuBucket).freq + 1
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != freq {
+		next = e.buckets.InsertAfter(&lfuBucket{freq: freq, entries: list.New()}, cur)
+	}
+	e.remove(n)
+	e.pushTo(next, n)
+}
+
+func (e *lfuEvictor) remove(n *policyNode) {
+	bucket := n.bucket.Value.(*lfuBucket)
+	bucket.entries.Remove(n.elem)
+	if bucket.entries.Len() == 0 {
+		e.buckets.Remove(n.bucket)
+	}
+	n.bucket, n.elem = nil, nil
+}
+
+func (e *lfuEvictor) pushTo(bucket *list.Element, n *policyNode) {
+	n.bucket = bucket
+	n.elem = bucket.Value.(*lfuBucket).entries.PushFront(n)
+}
+
+func (e *lfuEvictor) describe() string {
+	if front := e.buckets.Front(); front != nil {
+		back := e.buckets.Back().Value.(*lfuBucket)
+		return fmt.Sprintf("lfu buckets=%d freq=%d..%d", e.buckets.Len(), front.Value.(*lfuBucket).freq, back.freq)
+	}
+	return "lfu buckets=0"
+}
+
+func (e *lfuEvictor) victims(f func(n *policyNode) bool) {
+	for b := e.buckets.Front(); b != nil; b = b.Next() {
+		entries := b.Value.(*lfuBucket).entries
+		for p := entries.Back(); p != nil; p = p.Prev() {
+			if !f(p.Value.(*policyNode)) {
+				return
+			}
+		}
+	}
+}
+
+type clockPolicy struct{}
+
+// CLOCK approximates LRU by a reference bit per entry, a hit sets the bit without reordering entries.
+//
+//	Entries are kept in a ring swept by a hand, an entry referenced is passed over with its bit cleared,
+//	the first one unreferenced is evicted. New entries are put right behind the hand, so they are swept the last.
+func CLOCK() Policy {
+	return clockPolicy{}
+}
+
+func (clockPolicy) newEvictor(int64) evictor {
+	return &clockEvictor{ring: list.New()}
+}
+
+// clockEvictor sweeps only once a victim offered is evicted for room, so that listing the victims changes nothing,
+// e.g. by Range, or by a scavenge not evicting at last. The victims skipped, e.g. pinned, are passed over by the sweep.
+type clockEvictor struct {
+	ring *list.List
+	// hand is the next entry to sweep, nil if the ring is empty
+	hand *list.Element
+	// listed tells the victims are listed since the last add or access, the hand sweeps to the ones evicted meanwhile
+	listed bool
+}
+
+func (e *clockEvictor) add(n *policyNode) {
+	n.referenced = false
+	e.listed = false
+	if e.hand == nil {
+		n.elem = e.ring.PushBack(n)
+		e.hand = n.elem
+		return
+	}
+	n.elem = e.ring.InsertBefore(n, e.hand)
+}
+
+func (e *clockEvictor) access(n *policyNode) {
+	n.referenced = true
+	e.listed = false
+}
+
+func (e *clockEvictor) remove(n *policyNode) {
+	if e.listed && n.evicted {
+		e.sweep(n)
+	}
+	if e.hand == n.elem {
+		e.hand = e.next(n.elem)
+		if e.hand == n.elem {
+			e.hand = nil
+		}
+	}
+	e.ring.Remove(n.elem)
+	n.elem = nil
+}
+
+// sweep moves the hand to the victim n, clearing the bits of the entries passed.
+//
+//	n is offered after a full lap if it is referenced, all bits are cleared then.
+func (e *clockEvictor) sweep(n *policyNode) {
+	if n.referenced {
+		for elem := e.ring.Front(); elem != nil; elem = elem.Next() {
+			elem.Value.(*policyNode).referenced = false
+		}
+	} else {
+		for elem := e.hand; elem != n.elem; elem = e.next(elem) {
+			elem.Value.(*policyNode).referenced = false
+		}
+	}
+	e.hand = n.elem
+}
+
+// next returns the entry after elem in the ring.
+func (e *clockEvictor) next(elem *list.Element) *list.Element {
+	if next := elem.Next(); next != nil {
+		return next
+	}
+	return e.ring.Front()
+}
+
+func (e *clockEvictor) describe() string {
+	referenced := 0
+	for elem := e.ring.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(*policyNode).referenced {
+			referenced++
+		}
+	}
+	return fmt.Sprintf("clock entries=%d referenced=%d", e.ring.Len(), referenced)
+}
+
+// victims offers the unreferenced entries in the order swept from the hand, and then the referenced ones,
+// which would be evicted after a full lap of the hand clearing all bits.
+func (e *clockEvictor) victims(f func(n *policyNode) bool) {
+	e.listed = e.ring.Len() > 0
+	for _, lap := range []bool{false, true} {
+		elem := e.hand
+		for i, size := 0, e.ring.Len(); i < size; i++ {
+			n := elem.Value.(*policyNode)
+			elem = e.next(elem)
+			if n.referenced != lap {
+				continue
+			}
+			if !f(n) {
+				return
+			}
+		}
+	}
+}
+
+// keyedEvictor is an evictor which identifies entries by the hashes of their keys, policyNode.hash is set for it.
+type keyedEvictor interface {
+	keyed()
+}
+
+// presizer is an evictor which could reserve room for n entries up front.
+type presizer interface {
+	presize(n int)
+}
+
+// resizer is an evictor sized to the capacity, it is told of Resize.
+type resizer interface {
+	resize(capacity int64)
+}
+
+type slruPolicy struct {
+	protectedFraction float64
+}
+
+// SLRU segments entries into a probationary and a protected segment, both in LRU order, so that a scan could not flush the hot entries.
+//
+//	New entries are put in the probationary segment, and promoted to the protected one on the next hit.
+//	The protected segment takes up to protectedFraction of the capacity, beyond that its least recently used entries
+//	are demoted back to the probationary segment. Entries are evicted from the probationary segment first.
+//	The fraction is clamped to [0, 1], 0.8 is the common choice.
+func SLRU(protectedFraction float64) Policy {
+	return slruPolicy{protectedFraction: math.Max(0, math.Min(protectedFraction, 1))}
+}
+
+func (p slruPolicy) newEvictor(capacity int64) evictor {
+	e := &slruEvictor{
+		fraction:  p.protectedFraction,
+		probation: list.New(),
+		protected: list.New(),
+	}
+	e.resize(capacity)
+	return e
+}
+
+type slruEvictor struct {
+	fraction float64
+	// the protected segment takes up to maxProtected in cost, protectedCost is its current cost
+	maxProtected  int64
+	protectedCost int64
+	probation     *list.List
+	protected     *list.List
+}
+
+func (e *slruEvictor) add(n *policyNode) {
+	n.protected = false
+	n.elem = e.probation.PushFront(n)
+}
+
+func (e *slruEvictor) access(n *policyNode) {
+	if n.protected {
+		e.protected.MoveToFront(n.elem)
+		return
+	}
+	e.probation.Remove(n.elem)
+	n.protected = true
+	n.elem = e.protected.PushFront(n)
+	e.protectedCost += n.cost
+	e.demote()
+}
+
+func (e *slruEvictor) remove(n *policyNode) {
+	if n.protected {
+		e.protected.Remove(n.elem)
+		e.protectedCost -= n.cost
+	} else {
+		e.probation.Remove(n.elem)
+	}
+	n.elem = nil
+}
+
+// demote moves the least recently used protected entries to the probationary segment until the protected one fits.
+func (e *slruEvictor) demote() {
+	for e.protectedCost > e.maxProtected {
+		n := e.protected.Remove(e.protected.Back()).(*policyNode)
+		e.protectedCost -= n.cost
+		n.protected = false
+		n.elem = e.probation.PushFront(n)
+	}
+}
+
+func (e *slruEvictor) resize(capacity int64) {
+	e.maxProtected = int64(float64(capacity) * e.fraction)
+	e.demote()
+}
+
+func (e *slruEvictor) describe() string {
+	return fmt.Sprintf("slru probation=%d protected=%d protected cost=%d/%d",
+		e.probation.Len(), e.protected.Len(), e.protectedCost, e.maxProtected)
+}
+
+func (e *slruEvictor) victims(f func(n *policyNode) bool) {
+	for _, segment := range []*list.List{e.probation, e.protected} {
+		for p := segment.Back(); p != nil; p = p.Prev() {
+			if !f(p.Value.(*policyNode)) {
+				return
+			}
+		}
+	}
+}
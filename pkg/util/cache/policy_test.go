@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFIFOPolicy(t *testing.T) {
+	finalizeSeq := make([]int, 0)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalizeSeq = append(finalizeSeq, key)
+		return nil
+	}).WithCapacity(3).WithEvictionPolicy(FIFO()).Build()
+
+	// hits on 0 do not save it
+	for _, i := range []int{0, 1, 2, 0, 0, 3, 4} {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	assert.Equal(t, []int{0, 1}, finalizeSeq)
+
+	// a replaced entry goes to the back of the line
+	assert.NoError(t, cache.Put(2, 20))
+	assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+	assert.Equal(t, []int{0, 1, 2, 3}, finalizeSeq)
+	assert.ElementsMatch(t, []int{2, 4, 5}, cache.SnapshotKeys())
+}
+
+func TestLFUPolicy(t *testing.T) {
+	t.Run("test evict least frequently used", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(3).WithEvictionPolicy(LFU()).Build()
+
+		// key 0 and 1 are hot, the rest are one-shot.
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+			assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		}
+		for i := 2; i < 6; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, []int{2, 3, 4}, finalizeSeq)
+
+		// key 1 is still cached
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		assert.Equal(t, []int{2, 3, 4}, finalizeSeq)
+	})
+
+	t.Run("test ties evicted in lru order", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(3).WithEvictionPolicy(LFU()).Build()
+
+		for _, i := range []int{0, 1, 2, 1, 0, 2} {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		// all of 0, 1, 2 have frequency 2, key 1 is the least recently used.
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.Equal(t, []int{1}, finalizeSeq)
+	})
+}
+
+func TestCLOCKPolicy(t *testing.T) {
+	newCache := func(finalizeSeq *[]int) Cache[int, int] {
+		return NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			*finalizeSeq = append(*finalizeSeq, key)
+			return nil
+		}).WithCapacity(3).WithEvictionPolicy(CLOCK()).Build()
+	}
+
+	t.Run("test second chance", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := newCache(&finalizeSeq)
+		for _, i := range []int{0, 1, 2, 0} {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		// listing the entries sweeps nothing
+		cache.Range(func(key, value int) bool { return true })
+		assert.Len(t, cache.SnapshotKeys(), 3)
+
+		// 0 is passed over once with its bit cleared
+		for i := 3; i < 6; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, []int{1, 2, 0}, finalizeSeq)
+	})
+
+	t.Run("test full lap", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := newCache(&finalizeSeq)
+		for _, i := range []int{0, 1, 2, 1, 2, 0} {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		// all referenced, the hand clears all bits and evicts where it starts
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.NoError(t, cache.Do(4, func(v int) error { return nil }))
+		assert.Equal(t, []int{0, 1}, finalizeSeq)
+
+		assert.True(t, cache.Remove(2))
+		assert.True(t, cache.Remove(3))
+		assert.True(t, cache.Remove(4))
+		assert.NoError(t, cache.Do(5, func(v int) error { return nil }))
+		assert.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("test pinned at hand", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := newCache(&finalizeSeq)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		err := cache.Do(0, func(v int) error {
+			// the hand clears the bit of 0 and comes back to it
+			for i := 3; i < 5; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			assert.Equal(t, []int{1, 2}, finalizeSeq)
+			// 0 at the hand is pinned, the hand passes over it to 3
+			return cache.Do(5, func(v int) error { return nil })
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, finalizeSeq)
+
+		// 0 is behind the hand now, 4 next to the hand is evicted
+		assert.NoError(t, cache.Do(6, func(v int) error { return nil }))
+		assert.Equal(t, []int{1, 2, 3, 4}, finalizeSeq)
+	})
+}
+
+func TestSLRUPolicy(t *testing.T) {
+	finalizeSeq := make([]int, 0)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalizeSeq = append(finalizeSeq, key)
+		return nil
+	}).WithCapacity(4).WithEvictionPolicy(SLRU(0.5)).Build()
+	do := func(keys ...int) {
+		for _, key := range keys {
+			assert.NoError(t, cache.Do(key, func(v int) error { return nil }))
+		}
+	}
+
+	t.Run("test scan resistance", func(t *testing.T) {
+		// 0 and 1 are promoted by the second hit
+		do(0, 1, 0, 1)
+		do(10, 11, 12, 13, 14)
+		assert.Equal(t, []int{10, 11, 12}, finalizeSeq)
+		assert.True(t, cache.Contains(0))
+		assert.True(t, cache.Contains(1))
+	})
+
+	t.Run("test demote", func(t *testing.T) {
+		finalizeSeq = finalizeSeq[:0]
+		// promoting 13 demotes 0, the least recently used protected entry, to the front of probation
+		do(13, 15)
+		assert.Equal(t, []int{14}, finalizeSeq)
+		do(16, 17)
+		assert.Equal(t, []int{14, 0, 15}, finalizeSeq)
+		assert.True(t, cache.Contains(1))
+		assert.True(t, cache.Contains(13))
+	})
+
+	t.Run("test resize", func(t *testing.T) {
+		finalizeSeq = finalizeSeq[:0]
+		// the protected segment shrinks along, demoting 1 which is evicted first then
+		cache.Resize(2)
+		assert.Equal(t, []int{16, 17}, finalizeSeq)
+		do(18)
+		assert.Equal(t, []int{16, 17, 1}, finalizeSeq)
+		assert.True(t, cache.Contains(13))
+	})
+}
+
+func TestARCPolicy(t *testing.T) {
+	finalizeSeq := make([]int, 0)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalizeSeq = append(finalizeSeq, key)
+		return nil
+	}).WithCapacity(4).WithEvictionPolicy(ARC()).Build()
+	do := func(keys ...int) {
+		for _, key := range keys {
+			assert.NoError(t, cache.Do(key, func(v int) error { return nil }))
+		}
+	}
+
+	t.Run("test scan resistance", func(t *testing.T) {
+		// 0 and 1 are frequent, the scan only churns the recent list
+		do(0, 1, 0, 1)
+		do(10, 11, 12)
+		assert.Equal(t, []int{10}, finalizeSeq)
+		assert.True(t, cache.Contains(0))
+		assert.True(t, cache.Contains(1))
+	})
+
+	t.Run("test adapt", func(t *testing.T) {
+		finalizeSeq = finalizeSeq[:0]
+		// the ghost hit on 10 grows the recent target, so 13 evicts from the frequent list
+		do(10)
+		assert.Equal(t, []int{11}, finalizeSeq)
+		do(13)
+		assert.Equal(t, []int{11, 0}, finalizeSeq)
+
+		// the ghost hit on 0 shrinks it back
+		do(0)
+		assert.Equal(t, []int{11, 0, 12}, finalizeSeq)
+		do(14)
+		assert.Equal(t, []int{11, 0, 12, 13}, finalizeSeq)
+	})
+
+	t.Run("test removed not remembered", func(t *testing.T) {
+		finalizeSeq = finalizeSeq[:0]
+		assert.True(t, cache.Remove(14))
+		// 14 comes back as a recent entry, and is the one evicted for 15
+		do(14, 15)
+		assert.Equal(t, []int{14, 14}, finalizeSeq)
+		assert.ElementsMatch(t, []int{0, 1, 10, 15}, cache.SnapshotKeys())
+	})
+}
+
+func TestSampledLRUPolicy(t *testing.T) {
+	newCache := func(samples int, finalizeSeq *[]int) Cache[int, int] {
+		return NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			*finalizeSeq = append(*finalizeSeq, key)
+			return nil
+		}).WithCapacity(4).WithEvictionPolicy(SampledLRU(samples)).Build()
+	}
+
+	t.Run("test sample all", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := newCache(4, &finalizeSeq)
+		// sampling all the entries is exact lru
+		for _, i := range []int{0, 1, 2, 3, 0, 2, 4, 5} {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Equal(t, []int{1, 3}, finalizeSeq)
+		assert.ElementsMatch(t, []int{0, 2, 4, 5}, cache.SnapshotKeys())
+	})
+
+	t.Run("test sample one", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := newCache(0, &finalizeSeq)
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.Len(t, finalizeSeq, 96)
+		assert.Equal(t, 4, cache.Len())
+
+		// the entries are all visited when listed
+		keys := make([]int, 0)
+		cache.Range(func(key, value int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		assert.ElementsMatch(t, cache.SnapshotKeys(), keys)
+		assert.Len(t, keys, 4)
+	})
+}
+
+func TestEvictionTies(t *testing.T) {
+	t.Run("test lfu insert order", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(3).WithEvictionPolicy(LFU()).Build()
+		for _, i := range []int{2, 0, 1} {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		// replacing 2 inserts it anew, so all never hit, 0 is the earliest inserted
+		assert.NoError(t, cache.Put(2, 20))
+		seqs := make(map[int]uint64)
+		for _, i := range []int{0, 1, 2} {
+			info, ok := cache.EntryInfo(i)
+			assert.True(t, ok)
+			seqs[i] = info.InsertSeq
+		}
+		assert.Equal(t, map[int]uint64{0: 2, 1: 3, 2: 4}, seqs)
+
+		assert.NoError(t, cache.Put(3, 3))
+		assert.NoError(t, cache.Put(4, 4))
+		assert.Equal(t, []int{2, 0, 1}, finalizeSeq)
+	})
+
+	t.Run("test lfu same frequency", func(t *testing.T) {
+		finalizeSeq := make([]int, 0)
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(3).WithEvictionPolicy(LFU()).Build()
+		for _, i := range []int{0, 1, 2, 1, 0, 2} {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		// all hit once, 1 is the first hit
+		assert.NoError(t, cache.Put(3, 3))
+		assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+		assert.NoError(t, cache.Put(4, 4))
+		assert.Equal(t, []int{1, 0}, finalizeSeq)
+	})
+
+	for name, policy := range map[string]Policy{"slru": SLRU(0.8), "arc": ARC()} {
+		t.Run("test "+name+" insert order", func(t *testing.T) {
+			finalizeSeq := make([]int, 0)
+			cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithFinalizer(func(key, value int) error {
+				finalizeSeq = append(finalizeSeq, key)
+				return nil
+			}).WithCapacity(4).WithEvictionPolicy(policy).Build()
+			for _, i := range []int{2, 0, 3, 1} {
+				assert.NoError(t, cache.Put(i, i))
+			}
+			// 3 is hit, the others never hit are evicted in the order inserted
+			assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+			for i := 4; i < 7; i++ {
+				assert.NoError(t, cache.Put(i, i))
+			}
+			assert.Equal(t, []int{2, 0, 1}, finalizeSeq)
+		})
+	}
+
+	t.Run("test sampled lru reproducible", func(t *testing.T) {
+		evict := func() []int {
+			finalizeSeq := make([]int, 0)
+			cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithFinalizer(func(key, value int) error {
+				finalizeSeq = append(finalizeSeq, key)
+				return nil
+			}).WithCapacity(8).WithEvictionPolicy(SampledLRU(2)).Build()
+			for i := 0; i < 64; i++ {
+				assert.NoError(t, cache.Do(i%24, func(v int) error { return nil }))
+			}
+			return finalizeSeq
+		}
+		finalizeSeq := evict()
+		assert.NotEmpty(t, finalizeSeq)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, finalizeSeq, evict())
+		}
+	})
+}
+
+func TestInitialCapacity(t *testing.T) {
+	for name, policy := range map[string]Policy{"lru": LRU(), "sampled lru": SampledLRU(4)} {
+		t.Run("test "+name, func(t *testing.T) {
+			cache := NewCacheBuilder[int, int]().WithCapacity(4).WithInitialCapacity(16).WithEvictionPolicy(policy).WithShards(2).Build()
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, cache.Put(i, i))
+			}
+			// pre-sizing beyond the capacity never lets more entries in
+			assert.LessOrEqual(t, cache.Len(), 4)
+		})
+	}
+}
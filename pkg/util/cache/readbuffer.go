@@ -0,0 +1,82 @@
+package cache
+
+import "sync"
+
+const (
+	// readBufferSize is the number of hits buffered before they are replayed to the policy.
+	readBufferSize = 64
+	// readStripes is the number of read buffers, keys are spread over them by hash to reduce contention.
+	readStripes = 16
+)
+
+// readBuffer collects the hits taken under the read lock, to be replayed to the policy under the write lock.
+//
+//	It is lossy, a full buffer is dropped if the write lock is busy, so the recency becomes approximate.
+type readBuffer[K comparable, V any] struct {
+	mu    sync.Mutex
+	items []*cacheItem[K, V]
+}
+
+func newReadBuffer[K comparable, V any]() *readBuffer[K, V] {
+	return &readBuffer[K, V]{items: make([]*cacheItem[K, V], 0, readBufferSize)}
+}
+
+// add buffers a hit of item, it returns the buffered hits once the buffer is full.
+func (b *readBuffer[K, V]) add(item *cacheItem[K, V]) []*cacheItem[K, V] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = append(b.items, item)
+	if len(b.items) < readBufferSize {
+		return nil
+	}
+	full := b.items
+	b.items = make([]*cacheItem[K, V], 0, readBufferSize)
+	return full
+}
+
+// take returns the buffered hits and empties the buffer.
+func (b *readBuffer[K, V]) take() []*cacheItem[K, V] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items := b.items
+	b.items = make([]*cacheItem[K, V], 0, readBufferSize)
+	return items
+}
+
+// peekShared is peek under the read lock for a hit, the hit is buffered rather than recorded to the policy.
+//
+//	It returns nil on a miss or an expired entry, which should be peeked again under the write lock.
+func (c *lruCache[K, V]) peekShared(key K) *cacheItem[K, V] {
+	c.rwlock.RLock()
+	item, ok := c.items[key]
+	if !ok || item.expired(c.clock.Now()) {
+		c.rwlock.RUnlock()
+		return nil
+	}
+	// Entries are evicted under the write lock only, so the item could not be evicted before pinned.
+	item.pinCount.Inc()
+	c.rwlock.RUnlock()
+
+	if full := c.reads[hashKey(c.seed, key)%readStripes].add(item); full != nil && c.rwlock.TryLock() {
+		c.replay(full)
+		c.rwlock.Unlock()
+	}
+	return item
+}
+
+// drainReads replays the buffered hits to the policy, the caller must hold the lock.
+func (c *lruCache[K, V]) drainReads() {
+	for _, reads := range c.reads {
+		c.replay(reads.take())
+	}
+}
+
+// replay records the hits of items still cached, the caller must hold the lock.
+func (c *lruCache[K, V]) replay(items []*cacheItem[K, V]) {
+	now := c.clock.Now()
+	for _, item := range items {
+		if c.items[item.key] == item {
+			c.access(item, now)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadMostly(t *testing.T) {
+	finalizeSeq := make([]int, 0)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalizeSeq = append(finalizeSeq, key)
+		return nil
+	}).WithCapacity(3).WithReadMostly().Build()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	// the buffered hit of 0 is replayed before picking the victim
+	assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+	assert.NoError(t, cache.Do(3, func(v int) error { return nil }))
+	assert.Equal(t, []int{1}, finalizeSeq)
+	info, _ := cache.EntryInfo(0)
+	assert.EqualValues(t, 1, info.Accesses)
+
+	// more than the capacity keys, with one pinned by each goroutine at most
+	assert.NoError(t, cache.Put(100, 100))
+	cache.Resize(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := (i + j) % 16
+				assert.NoError(t, cache.Do(key, func(v int) error {
+					assert.Equal(t, key, v)
+					return nil
+				}))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 10, cache.Len())
+	assert.EqualValues(t, 10, cache.Usage())
+	st := cache.Stats()
+	assert.EqualValues(t, 8005, st.Hits+st.Misses)
+}
+
+func benchmarkReadMostly(b *testing.B, readMostly bool) {
+	builder := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithCapacity(1024)
+	if readMostly {
+		builder = builder.WithReadMostly()
+	}
+	cache := builder.Build()
+	for i := 0; i < 512; i++ {
+		cache.Do(i, func(v int) error { return nil })
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Do(i%512, func(v int) error { return nil })
+			i++
+		}
+	})
+}
+
+func BenchmarkReadMostly(b *testing.B) {
+	b.Run("exclusive", func(b *testing.B) { benchmarkReadMostly(b, false) })
+	b.Run("read mostly", func(b *testing.B) { benchmarkReadMostly(b, true) })
+}
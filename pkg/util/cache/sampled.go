@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+type sampledLRUPolicy struct {
+	samples int
+}
+
+// SampledLRU approximates LRU as Redis does, evicting the least recently used one of samples random entries.
+//
+//	It keeps no access list, an access only stamps the entry, so it costs less than LRU for large caches, while
+//	a larger sample size gets closer to LRU at the cost of slower evictions. The sample size is at least 1,
+//	and 5 is the default of Redis.
+func SampledLRU(samples int) Policy {
+	if samples < 1 {
+		samples = 1
+	}
+	return sampledLRUPolicy{samples: samples}
+}
+
+func (p sampledLRUPolicy) newEvictor(int64) evictor {
+	return &sampledEvictor{samples: p.samples, rand: rand.New(rand.NewSource(sampleSeed))}
+}
+
+// sampleSeed seeds the samples, so that the evictions are reproducible.
+const sampleSeed = 1
+
+type sampledEvictor struct {
+	samples int
+	rand    *rand.Rand
+	nodes   []*policyNode
+	// tick stamps the accesses, the least recently used entry has the smallest stamp
+	tick uint64
+}
+
+func (e *sampledEvictor) presize(n int) {
+	e.nodes = make([]*policyNode, 0, n)
+}
+
+func (e *sampledEvictor) add(n *policyNode) {
+	n.index = len(e.nodes)
+	e.nodes = append(e.nodes, n)
+	e.access(n)
+}
+
+func (e *sampledEvictor) access(n *policyNode) {
+	e.tick++
+	n.stamp = e.tick
+}
+
+func (e *sampledEvictor) remove(n *policyNode) {
+	last := len(e.nodes) - 1
+	e.nodes[n.index] = e.nodes[last]
+	e.nodes[n.index].index = n.index
+	e.nodes[last] = nil
+	e.nodes = e.nodes[:last]
+}
+
+func (e *sampledEvictor) describe() string {
+	return fmt.Sprintf("sampled lru entries=%d samples=%d", len(e.nodes), e.samples)
+}
+
+// victims shuffles the entries lazily without moving them, each victim is the least recently used one of
+// the samples drawn from the entries not visited yet.
+func (e *sampledEvictor) victims(f func(n *policyNode) bool) {
+	// swapped maps the shuffled positions to the indexes of the entries
+	swapped := make(map[int]int)
+	at := func(i int) int {
+		if j, ok := swapped[i]; ok {
+			return j
+		}
+		return i
+	}
+	swap := func(i, j int) {
+		swapped[i], swapped[j] = at(j), at(i)
+	}
+	total := len(e.nodes)
+	for visited := 0; visited < total; visited++ {
+		samples := e.samples
+		if samples > total-visited {
+			samples = total - visited
+		}
+		oldest := visited
+		for i := visited; i < visited+samples; i++ {
+			swap(i, i+e.rand.Intn(total-i))
+			if e.nodes[at(i)].stamp < e.nodes[at(oldest)].stamp {
+				oldest = i
+			}
+		}
+		swap(visited, oldest)
+		if !f(e.nodes[at(visited)]) {
+			return
+		}
+	}
+}
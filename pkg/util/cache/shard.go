@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"time"
+
+	"github.com/milvus-io/milvus/pkg/util/merr"
+)
+
+// shardedCache partitions keys by hash across independent lru caches.
+type shardedCache[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*lruCache[K, V]
+	// hasher picks the shard of a key if set, instead of hashKey
+	hasher func(K) uint64
+}
+
+func newShardedCache[K comparable, V any](b *CacheBuilder[K, V]) *shardedCache[K, V] {
+	c := &shardedCache[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*lruCache[K, V], b.shards),
+		hasher: b.shardHasher,
+	}
+	for i := range c.shards {
+		c.shards[i] = newLRUCache(b, shardCapacity(b.capacity, len(c.shards), i))
+		if b.softLimit > 0 {
+			c.shards[i].softLimit = shardCapacity(b.softLimit, len(c.shards), i)
+		}
+		if quota := b.namespaceQuota; quota != nil && b.namespacer != nil {
+			i := i
+			c.shards[i].namespaces.quota = func(namespace string) int64 {
+				q := quota(namespace)
+				if q <= 0 {
+					return q
+				}
+				// A limited quota never becomes unlimited by splitting.
+				if q = shardCapacity(q, len(c.shards), i); q == 0 {
+					q = 1
+				}
+				return q
+			}
+		}
+	}
+	// The shards load from the same backend, so they share one circuit breaker and the load slots,
+	// they share the finalizer workers as well.
+	finalizers := newFinalizerPool(b.finalizeWorkers)
+	var hooks *finalizerPool
+	if b.postEviction != nil {
+		hooks = newFinalizerPool(1)
+	}
+	for _, shard := range c.shards {
+		shard.breaker = c.shards[0].breaker
+		shard.loadSlots = c.shards[0].loadSlots
+		shard.pendingLoads = c.shards[0].pendingLoads
+		shard.finalizers = finalizers
+		shard.hooks = hooks
+	}
+	if b.pressure != nil {
+		go watchPressure(b.pressure, c.shards[0].done, c.shards...)
+	}
+	return c
+}
+
+// shardCapacity returns the part of capacity for the i-th of n shards.
+func shardCapacity(capacity int64, n, i int) int64 {
+	// Spread the remainder over the leading shards, so that the capacities sum up to the configured one.
+	part := capacity / int64(n)
+	if int64(i) < capacity%int64(n) {
+		part++
+	}
+	return part
+}
+
+func (c *shardedCache[K, V]) shard(key K) *lruCache[K, V] {
+	return c.shards[c.hash(key)%uint64(len(c.shards))]
+}
+
+func (c *shardedCache[K, V]) hash(key K) uint64 {
+	if c.hasher != nil {
+		return c.hasher(key)
+	}
+	return hashKey(c.seed, key)
+}
+
+// hashKey hashes key evenly, integers are mixed without the seed.
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case int64:
+		return mix(uint64(k))
+	case int:
+		return mix(uint64(k))
+	case int32:
+		return mix(uint64(k))
+	case uint64:
+		return mix(k)
+	case string:
+		return maphash.String(seed, k)
+	default:
+		return maphash.String(seed, fmt.Sprint(key))
+	}
+}
+
+// mix is the finalizer of splitmix64, it spreads sequential integers evenly.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (c *shardedCache[K, V]) Do(key K, doer func(V) error) error {
+	return c.shard(key).Do(key, doer)
+}
+
+func (c *shardedCache[K, V]) DoCtx(ctx context.Context, key K, doer func(V) error) error {
+	return c.shard(key).DoCtx(ctx, key, doer)
+}
+
+func (c *shardedCache[K, V]) Acquire(key K) (V, func(), error) {
+	return c.shard(key).Acquire(key)
+}
+
+func (c *shardedCache[K, V]) Put(key K, value V) error {
+	return c.shard(key).Put(key, value)
+}
+
+func (c *shardedCache[K, V]) PutAll(items map[K]V) error {
+	if c.shards[0].closed.Load() {
+		return ErrCacheClosed
+	}
+	byShard := make(map[*lruCache[K, V]]map[K]V)
+	for key, value := range items {
+		shard := c.shard(key)
+		if byShard[shard] == nil {
+			byShard[shard] = make(map[K]V)
+		}
+		byShard[shard][key] = value
+	}
+	errs := make(KeyErrors[K])
+	for shard, items := range byShard {
+		err := shard.PutAll(items)
+		if keyErrs, ok := err.(KeyErrors[K]); ok {
+			for key, err := range keyErrs {
+				errs[key] = err
+			}
+		} else if err != nil {
+			for key := range items {
+				errs[key] = err
+			}
+		}
+	}
+	return errs.orNil()
+}
+
+func (c *shardedCache[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	return c.shard(key).CompareAndSwap(key, old, new, eq)
+}
+
+func (c *shardedCache[K, V]) Remove(key K) bool {
+	return c.shard(key).Remove(key)
+}
+
+func (c *shardedCache[K, V]) GetAndRemove(key K) (V, bool) {
+	return c.shard(key).GetAndRemove(key)
+}
+
+func (c *shardedCache[K, V]) Pin(key K) {
+	c.shard(key).Pin(key)
+}
+
+func (c *shardedCache[K, V]) Unpin(key K) {
+	c.shard(key).Unpin(key)
+}
+
+func (c *shardedCache[K, V]) InvalidateIf(pred func(key K, value V) bool) int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.InvalidateIf(pred)
+	}
+	return n
+}
+
+func (c *shardedCache[K, V]) Clear() error {
+	errs := make([]error, 0, len(c.shards))
+	for _, shard := range c.shards {
+		errs = append(errs, shard.Clear())
+	}
+	return merr.Combine(errs...)
+}
+
+func (c *shardedCache[K, V]) Close() error {
+	errs := make([]error, 0, len(c.shards))
+	for _, shard := range c.shards {
+		errs = append(errs, shard.close())
+	}
+	c.shards[0].finalizers.drain()
+	c.shards[0].hooks.drain()
+	return merr.Combine(errs...)
+}
+
+func (c *shardedCache[K, V]) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+func (c *shardedCache[K, V]) Usage() int64 {
+	usage := int64(0)
+	for _, shard := range c.shards {
+		usage += shard.Usage()
+	}
+	return usage
+}
+
+func (c *shardedCache[K, V]) Capacity() int64 {
+	capacity := int64(0)
+	for _, shard := range c.shards {
+		capacity += shard.Capacity()
+	}
+	return capacity
+}
+
+func (c *shardedCache[K, V]) Resize(capacity int64) {
+	for i, shard := range c.shards {
+		part := shardCapacity(capacity, len(c.shards), i)
+		if part == 0 && capacity > 0 {
+			// Never leave a shard without room.
+			part = 1
+		}
+		shard.Resize(part)
+	}
+}
+
+func (c *shardedCache[K, V]) GetIfPresent(key K) (V, bool) {
+	return c.shard(key).GetIfPresent(key)
+}
+
+func (c *shardedCache[K, V]) GetOrDefault(key K, def V) V {
+	return c.shard(key).GetOrDefault(key, def)
+}
+
+func (c *shardedCache[K, V]) Stats() Stats {
+	stats := Stats{}
+	for _, s := range c.ShardStats() {
+		stats = stats.merge(s)
+	}
+	return stats
+}
+
+func (c *shardedCache[K, V]) ShardStats() []Stats {
+	stats := make([]Stats, 0, len(c.shards))
+	for _, shard := range c.shards {
+		stats = append(stats, shard.Stats())
+	}
+	return stats
+}
+
+func (c *shardedCache[K, V]) StatsSnapshot() StatsSnapshot {
+	removals := make(map[RemovalCause]int64)
+	for _, shard := range c.shards {
+		shard.stats.addRemovals(removals)
+	}
+	s := newStatsSnapshot(c.Stats(), removals, c.LoadLatency())
+	s.TakenAt = c.shards[0].clock.Now()
+	for _, shard := range c.shards {
+		shard.rwlock.RLock()
+		s.Size += len(shard.items)
+		s.Usage += shard.scavenger.Size()
+		s.Capacity += shard.scavenger.capacity
+		shard.rwlock.RUnlock()
+	}
+	return s
+}
+
+func (c *shardedCache[K, V]) Contains(key K) bool {
+	return c.shard(key).Contains(key)
+}
+
+func (c *shardedCache[K, V]) Touch(key K) bool {
+	return c.shard(key).Touch(key)
+}
+
+func (c *shardedCache[K, V]) EntryInfo(key K) (Info, bool) {
+	return c.shard(key).EntryInfo(key)
+}
+
+func (c *shardedCache[K, V]) TotalCost() int64 {
+	cost := int64(0)
+	for _, shard := range c.shards {
+		cost += shard.TotalCost()
+	}
+	return cost
+}
+
+func (c *shardedCache[K, V]) GetCost(key K) (int64, bool) {
+	return c.shard(key).GetCost(key)
+}
+
+func (c *shardedCache[K, V]) TTL(key K) (time.Duration, bool) {
+	return c.shard(key).TTL(key)
+}
+
+func (c *shardedCache[K, V]) Range(fn func(key K, value V) bool) {
+	for _, shard := range c.shards {
+		if !shard.iterate(fn) {
+			return
+		}
+	}
+}
+
+func (c *shardedCache[K, V]) LoadLatency() LoadLatency {
+	var latency LoadLatency
+	for _, shard := range c.shards {
+		latency = latency.merge(shard.LoadLatency())
+	}
+	return latency
+}
+
+func (c *shardedCache[K, V]) ResetStats() {
+	for _, shard := range c.shards {
+		shard.ResetStats()
+	}
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCache(t *testing.T) {
+	t.Run("test capacity", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(10).WithShards(4).Build()
+
+		shards := cache.(*shardedCache[int, int]).shards
+		capacity := int64(0)
+		for _, shard := range shards {
+			capacity += shard.scavenger.capacity
+		}
+		assert.EqualValues(t, 10, capacity)
+
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error {
+				assert.Equal(t, i, v)
+				return nil
+			}))
+		}
+		assert.Equal(t, 10, cache.Len())
+		assert.EqualValues(t, 10, cache.Usage())
+		assert.EqualValues(t, 10, cache.TotalCost())
+		cost, ok := cache.GetCost(99)
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, cost)
+		stats := cache.Stats()
+		assert.EqualValues(t, 100, stats.Misses)
+		assert.EqualValues(t, 90, stats.Evictions)
+	})
+
+	t.Run("test shard stats", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(100).WithShards(4).Build()
+		for i := 0; i < 40; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		// only the shard of key 0 is hit
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(0, func(v int) error { return nil }))
+		}
+
+		shards := cache.(*shardedCache[int, int]).shards
+		stats := cache.ShardStats()
+		assert.Len(t, stats, 4)
+		sum := Stats{}
+		for i, s := range stats {
+			sum = sum.merge(s)
+			if shards[i] == cache.(*shardedCache[int, int]).shard(0) {
+				assert.EqualValues(t, 10, s.Hits)
+			} else {
+				assert.Zero(t, s.Hits)
+			}
+		}
+		assert.Equal(t, cache.Stats(), sum)
+		assert.EqualValues(t, 40, sum.Misses)
+
+		unsharded := NewCacheBuilder[int, int]().Build()
+		assert.Equal(t, []Stats{unsharded.Stats()}, unsharded.ShardStats())
+	})
+
+	t.Run("test shard hasher", func(t *testing.T) {
+		type key struct {
+			tenant int
+			id     string
+		}
+		cache := NewCacheBuilder[key, int]().WithLoader(func(k key) (int, bool) {
+			return k.tenant, true
+		}).WithShardHasher(func(k key) uint64 {
+			return uint64(k.tenant)
+		}).WithCapacity(100).WithShards(4).Build()
+		for tenant := 0; tenant < 4; tenant++ {
+			for i := 0; i <= tenant; i++ {
+				assert.NoError(t, cache.Do(key{tenant, fmt.Sprint(i)}, func(v int) error { return nil }))
+			}
+		}
+
+		// the keys of tenant i are all in shard i, and still looked up by equality
+		for i, s := range cache.ShardStats() {
+			assert.EqualValues(t, i+1, s.Misses)
+		}
+		for i, shard := range cache.(*shardedCache[key, int]).shards {
+			assert.Equal(t, i+1, shard.Len())
+		}
+		assert.True(t, cache.Contains(key{3, "3"}))
+		assert.False(t, cache.Contains(key{3, "4"}))
+	})
+
+	t.Run("test capacity below shards", func(t *testing.T) {
+		builder := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(2).WithShards(4)
+		cache := builder.Build()
+		assert.Len(t, cache.(*shardedCache[int, int]).shards, 2)
+		// every key has room
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.LessOrEqual(t, cache.Len(), 2)
+		assert.EqualValues(t, 2, cache.Capacity())
+
+		// a single shard
+		cache = builder.WithCapacity(1).Build()
+		_, sharded := cache.(*shardedCache[int, int])
+		assert.False(t, sharded)
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+
+		// the builder keeps the shards
+		cache = builder.WithCapacity(8).Build()
+		assert.Len(t, cache.(*shardedCache[int, int]).shards, 4)
+		cache.Resize(2)
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.EqualValues(t, 4, cache.Capacity())
+	})
+
+	t.Run("test resize", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(10).WithShards(4).Build()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+
+		cache.Resize(5)
+		capacity := int64(0)
+		for _, shard := range cache.(*shardedCache[int, int]).shards {
+			capacity += shard.scavenger.capacity
+			assert.LessOrEqual(t, shard.Usage(), shard.scavenger.capacity)
+		}
+		assert.EqualValues(t, 5, capacity)
+		assert.LessOrEqual(t, cache.Usage(), int64(5))
+	})
+
+	t.Run("test operations", func(t *testing.T) {
+		finalized := 0
+		cache := NewCacheBuilder[string, int]().WithFinalizer(func(key string, value int) error {
+			finalized++
+			return nil
+		}).WithCapacity(100).WithShards(8).Build()
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Put(fmt.Sprint(i), i))
+		}
+		v, ok := cache.GetIfPresent("3")
+		assert.True(t, ok)
+		assert.Equal(t, 3, v)
+		assert.True(t, cache.Remove("3"))
+		assert.Equal(t, 9, cache.Len())
+		visited := make(map[string]int)
+		cache.Range(func(key string, value int) bool {
+			visited[key] = value
+			return true
+		})
+		assert.Len(t, visited, 9)
+		cnt := 0
+		cache.Range(func(key string, value int) bool {
+			cnt++
+			return false
+		})
+		assert.Equal(t, 1, cnt)
+		assert.NoError(t, cache.Clear())
+		assert.Equal(t, 0, cache.Len())
+		assert.Equal(t, 10, finalized)
+
+		cache.ResetStats()
+		assert.Equal(t, Stats{}, cache.Stats())
+
+		assert.NoError(t, cache.Put("1", 1))
+		assert.NoError(t, cache.Close())
+		assert.Equal(t, 11, finalized)
+		assert.Equal(t, ErrCacheClosed, cache.Put("1", 1))
+		assert.Equal(t, ErrCacheClosed, cache.DoMany([]string{"1"}, func(map[string]int) error { return nil }))
+		assert.NoError(t, cache.Close())
+	})
+
+	t.Run("test race condition", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(40).WithShards(4).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					assert.NoError(t, cache.Do(j, func(v int) error { return nil }))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func benchmarkConcurrency(b *testing.B, shards int) {
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithCapacity(1024).WithShards(shards).Build()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Do(i%512, func(v int) error { return nil })
+			i++
+		}
+	})
+}
+
+func BenchmarkConcurrency(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkConcurrency(b, 1) })
+	b.Run("sharded", func(b *testing.B) { benchmarkConcurrency(b, 16) })
+}
@@ -0,0 +1,160 @@
+package cache
+
+import "time"
+
+// Entry is a copy of a cached entry taken by Snapshot, to be restored by WithRestore.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+	// ExpireAt is when the entry expires, zero if it never expires.
+	ExpireAt time.Time
+	// Cost is the weight of the entry measured by the scavenger or the weigher.
+	Cost int64
+}
+
+func (c *lruCache[K, V]) Snapshot() []Entry[K, V] {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	now := c.clock.Now()
+	entries := make([]Entry[K, V], 0, len(c.items))
+	c.evictor.victims(func(n *policyNode) bool {
+		item := n.owner.(*cacheItem[K, V])
+		if !item.expired(now) {
+			entries = append(entries, Entry[K, V]{Key: item.key, Value: item.value, ExpireAt: item.expireAt, Cost: item.cost})
+		}
+		return true
+	})
+	return entries
+}
+
+func (c *lruCache[K, V]) SnapshotKeys() []K {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	now := c.clock.Now()
+	keys := make([]K, 0, len(c.items))
+	c.evictor.victims(func(n *policyNode) bool {
+		item := n.owner.(*cacheItem[K, V])
+		if !item.expired(now) {
+			keys = append(keys, item.key)
+		}
+		return true
+	})
+	return keys
+}
+
+// Iterator walks the entries copied by Cache.Iterator, it is not thread safe.
+//
+//	for it := cache.Iterator(); it.Next(); {
+//		export(it.Key(), it.Value())
+//	}
+type Iterator[K comparable, V any] struct {
+	// sources copy the entries of each shard, called one by one as the iterator reaches them
+	sources []func() []Entry[K, V]
+	entries []Entry[K, V]
+	entry   Entry[K, V]
+}
+
+// Next advances to the next entry, it returns false once all the entries are iterated.
+func (it *Iterator[K, V]) Next() bool {
+	for len(it.entries) == 0 {
+		if len(it.sources) == 0 {
+			return false
+		}
+		it.entries, it.sources = it.sources[0](), it.sources[1:]
+	}
+	it.entry, it.entries = it.entries[0], it.entries[1:]
+	return true
+}
+
+// Key returns the key of the current entry.
+func (it *Iterator[K, V]) Key() K {
+	return it.entry.Key
+}
+
+// Value returns the value of the current entry.
+func (it *Iterator[K, V]) Value() V {
+	return it.entry.Value
+}
+
+// Entry returns the current entry, along with its expiry and cost.
+func (it *Iterator[K, V]) Entry() Entry[K, V] {
+	return it.entry
+}
+
+func (c *lruCache[K, V]) Iterator() *Iterator[K, V] {
+	return &Iterator[K, V]{sources: []func() []Entry[K, V]{c.copyEntries}}
+}
+
+func (c *shardedCache[K, V]) Iterator() *Iterator[K, V] {
+	sources := make([]func() []Entry[K, V], 0, len(c.shards))
+	for _, shard := range c.shards {
+		sources = append(sources, shard.copyEntries)
+	}
+	return &Iterator[K, V]{sources: sources}
+}
+
+// copyEntries copies the unexpired entries under the read lock, walking the items rather than the evictor.
+func (c *lruCache[K, V]) copyEntries() []Entry[K, V] {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	now := c.clock.Now()
+	entries := make([]Entry[K, V], 0, len(c.items))
+	for _, item := range c.items {
+		if !item.expired(now) {
+			entries = append(entries, Entry[K, V]{Key: item.key, Value: item.value, ExpireAt: item.expireAt, Cost: item.cost})
+		}
+	}
+	return entries
+}
+
+// restore inserts entries in order, so that the last one is the most recently used.
+//
+//	Expired entries are skipped, the others keep their expiry unless the ttl of the cache expires them earlier.
+//	Costs are measured again, entries are evicted in eviction order if they do not fit.
+func (c *lruCache[K, V]) restore(entries []Entry[K, V]) {
+	c.rwlock.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	for _, entry := range entries {
+		if !entry.ExpireAt.IsZero() && !now.Before(entry.ExpireAt) {
+			continue
+		}
+		item := c.newItem(entry.Key, entry.Value, 0)
+		if !entry.ExpireAt.IsZero() && (item.deadline.IsZero() || entry.ExpireAt.Before(item.deadline)) {
+			item.deadline = entry.ExpireAt
+			if item.expireAt.IsZero() || entry.ExpireAt.Before(item.expireAt) {
+				item.expireAt = entry.ExpireAt
+			}
+		}
+		if err := c.insert(item, false); err != nil {
+			c.release(item, Evicted)
+		}
+	}
+}
+
+func (c *shardedCache[K, V]) Snapshot() []Entry[K, V] {
+	entries := make([]Entry[K, V], 0)
+	for _, shard := range c.shards {
+		entries = append(entries, shard.Snapshot()...)
+	}
+	return entries
+}
+
+func (c *shardedCache[K, V]) SnapshotKeys() []K {
+	keys := make([]K, 0)
+	for _, shard := range c.shards {
+		keys = append(keys, shard.SnapshotKeys()...)
+	}
+	return keys
+}
+
+func (c *shardedCache[K, V]) restore(entries []Entry[K, V]) {
+	byShard := make(map[*lruCache[K, V]][]Entry[K, V])
+	for _, entry := range entries {
+		shard := c.shard(entry.Key)
+		byShard[shard] = append(byShard[shard], entry)
+	}
+	for shard, entries := range byShard {
+		shard.restore(entries)
+	}
+}
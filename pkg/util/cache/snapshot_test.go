@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCacheBuilder[int, int]().WithLoaderTTL(func(key int) (int, time.Duration, bool) {
+		return key, time.Duration(key) * time.Minute, true
+	}).WithCapacity(5).WithClock(clock).Build()
+	for i := 1; i <= 4; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	assert.NoError(t, cache.Put(10, 100))
+	// 1 expires, 2 becomes the most recently used
+	clock.Advance(time.Minute)
+	assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+
+	entries := cache.Snapshot()
+	assert.Equal(t, []Entry[int, int]{
+		{Key: 3, Value: 3, ExpireAt: time.Unix(180, 0), Cost: 1},
+		{Key: 4, Value: 4, ExpireAt: time.Unix(240, 0), Cost: 1},
+		{Key: 10, Value: 100, Cost: 1},
+		{Key: 2, Value: 2, ExpireAt: time.Unix(120, 0), Cost: 1},
+	}, entries)
+	assert.Equal(t, []int{3, 4, 10, 2}, cache.SnapshotKeys())
+
+	t.Run("test restore", func(t *testing.T) {
+		clock := newFakeClock()
+		clock.Advance(150 * time.Second)
+		finalizeSeq := make([]int, 0)
+		restored := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			finalizeSeq = append(finalizeSeq, key)
+			return nil
+		}).WithCapacity(2).WithTTL(time.Minute).WithClock(clock).WithRestore(entries).Build()
+
+		// 2 has expired, 3 is evicted by 10, the ttl of the cache bounds the expiry of 4
+		assert.Equal(t, []int{3}, finalizeSeq)
+		assert.Equal(t, []int{4, 10}, restored.SnapshotKeys())
+		ttl, ok := restored.TTL(4)
+		assert.True(t, ok)
+		assert.Equal(t, 60*time.Second, ttl)
+		ttl, ok = restored.TTL(10)
+		assert.True(t, ok)
+		assert.Equal(t, time.Minute, ttl)
+	})
+
+	t.Run("test restore sharded", func(t *testing.T) {
+		restored := NewCacheBuilder[int, int]().WithCapacity(8).WithShards(2).WithClock(clock).WithRestore(entries).Build()
+		assert.ElementsMatch(t, []int{3, 4, 10, 2}, restored.SnapshotKeys())
+		assert.Len(t, restored.Snapshot(), 4)
+	})
+}
+
+func TestIterator(t *testing.T) {
+	for name, shards := range map[string]int{"lru": 1, "sharded": 4} {
+		t.Run("test iterator "+name, func(t *testing.T) {
+			clock := newFakeClock()
+			cache := NewCacheBuilder[int, int]().WithCapacity(100).WithTTL(time.Minute).WithClock(clock).WithShards(shards).Build()
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, cache.Put(i, i*10))
+			}
+			clock.Advance(time.Minute / 2)
+			assert.NoError(t, cache.Put(10, 100))
+			clock.Advance(time.Minute / 2)
+
+			// the cache is free to use while iterated
+			got := make(map[int]int)
+			for it := cache.Iterator(); it.Next(); {
+				got[it.Key()] = it.Value()
+				assert.Equal(t, time.Unix(90, 0), it.Entry().ExpireAt)
+				assert.True(t, cache.Remove(it.Key()))
+			}
+			// the expired are left out
+			assert.Equal(t, map[int]int{10: 100}, got)
+
+			it := NewCacheBuilder[int, int]().WithShards(shards).Build().Iterator()
+			assert.False(t, it.Next())
+			assert.False(t, it.Next())
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+const (
+	// softCheckInterval is how often soft values check the memory pressure.
+	softCheckInterval = time.Second
+	// softPressure is the ratio of memory in use to the memory limit, beyond which soft values are reclaimed.
+	softPressure = 0.9
+	// softReclaim is the fraction of entries reclaimed on each check under pressure.
+	softReclaim = 0.25
+	// pressureThreshold is the pressure signaled beyond which entries are evicted in proportion.
+	pressureThreshold = 0.8
+)
+
+// reclaimEvery reclaims soft values every interval while the memory is under pressure.
+func (c *lruCache[K, V]) reclaimEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.reclaim(memoryPressure())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reclaim evicts a fraction of entries if pressure exceeds the threshold of soft values, returns the number evicted.
+func (c *lruCache[K, V]) reclaim(pressure float64) int {
+	if pressure < softPressure {
+		return 0
+	}
+	return c.shrink(softReclaim)
+}
+
+// shrink evicts the fraction of entries in eviction order, pinned ones are skipped, returns the number evicted.
+func (c *lruCache[K, V]) shrink(fraction float64) int {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	n := int(math.Ceil(float64(len(c.items)) * fraction))
+	toEvict := make([]*cacheItem[K, V], 0, n)
+	c.evictor.victims(func(node *policyNode) bool {
+		if len(toEvict) >= n {
+			return false
+		}
+		item := node.owner.(*cacheItem[K, V])
+		if c.evictable(item) {
+			toEvict = append(toEvict, item)
+		}
+		return true
+	})
+	for _, item := range toEvict {
+		c.removeItem(item, c.removalCause(item, Evicted))
+		c.stats.evictions.Inc()
+	}
+	return len(toEvict)
+}
+
+// watchPressure shrinks the caches in proportion to the pressure signaled beyond the threshold, until done is closed.
+func watchPressure[K comparable, V any](signal <-chan float64, done <-chan struct{}, caches ...*lruCache[K, V]) {
+	for {
+		select {
+		case pressure, ok := <-signal:
+			if !ok {
+				return
+			}
+			if fraction := pressureFraction(pressure); fraction > 0 {
+				for _, c := range caches {
+					c.shrink(fraction)
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// pressureFraction maps the pressure beyond the threshold to the fraction of entries to evict, all of them at 1.0.
+func pressureFraction(pressure float64) float64 {
+	if pressure <= pressureThreshold {
+		return 0
+	}
+	if pressure >= 1 {
+		return 1
+	}
+	return (pressure - pressureThreshold) / (1 - pressureThreshold)
+}
+
+// memoryPressure returns the ratio of memory in use to the memory limit of the runtime, 0 if there is no limit.
+func memoryPressure() float64 {
+	limit := debug.SetMemoryLimit(-1)
+	if limit <= 0 || limit == math.MaxInt64 {
+		return 0
+	}
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	used := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	return float64(used) / float64(limit)
+}
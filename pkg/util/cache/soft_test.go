@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftValues(t *testing.T) {
+	finalizeSeq := make([]int, 0)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalizeSeq = append(finalizeSeq, key)
+		return nil
+	}).WithCapacity(8).WithSoftValues().Build().(*lruCache[int, int])
+	defer cache.Close()
+
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	assert.Equal(t, 0, cache.reclaim(0.5))
+	cache.Pin(0)
+	assert.Equal(t, 2, cache.reclaim(0.95))
+	assert.Equal(t, []int{1, 2}, finalizeSeq)
+	assert.EqualValues(t, 6, cache.Usage())
+	assert.EqualValues(t, 2, cache.Stats().Evictions)
+
+	// a reclaimed entry is reloaded
+	assert.NoError(t, cache.Do(1, func(v int) error {
+		assert.Equal(t, 1, v)
+		return nil
+	}))
+	assert.EqualValues(t, 9, cache.Stats().LoadSuccesses)
+	assert.GreaterOrEqual(t, memoryPressure(), 0.0)
+}
+
+func TestMemoryPressureSignal(t *testing.T) {
+	signal := make(chan float64)
+	finalized := make(chan int, 10)
+	cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+		return key, true
+	}).WithFinalizer(func(key, value int) error {
+		finalized <- key
+		return nil
+	}).WithCapacity(10).WithMemoryPressureSignal(signal).Build()
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+	}
+	signal <- 0.5
+	// half of the entries for 0.9, the unbuffered send returns once the previous signal is handled
+	signal <- 0.9
+	signal <- 0.5
+	assert.Equal(t, 5, cache.Len())
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, []int{<-finalized, <-finalized, <-finalized, <-finalized, <-finalized})
+
+	cache.Pin(9)
+	signal <- 1
+	signal <- 0
+	assert.Equal(t, 1, cache.Len())
+	close(signal)
+
+	t.Run("test sharded", func(t *testing.T) {
+		signal := make(chan float64)
+		cache := NewCacheBuilder[int, int]().WithCapacity(100).WithShards(4).WithMemoryPressureSignal(signal).Build()
+		defer cache.Close()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		signal <- 1
+		signal <- 0
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("test fraction", func(t *testing.T) {
+		assert.Equal(t, 0.0, pressureFraction(0.8))
+		assert.InDelta(t, 0.25, pressureFraction(0.85), 1e-9)
+		assert.Equal(t, 1.0, pressureFraction(1.2))
+	})
+}
@@ -0,0 +1,36 @@
+package cache
+
+import "context"
+
+// Source tells where DoWithSource gets the value from.
+type Source int32
+
+const (
+	// Hit means the value is found in the cache.
+	Hit Source = iota + 1
+	// Loaded means the value is missed and loaded, or joined the load in flight.
+	Loaded
+	// Refreshed means the value is found in the cache, and it is being reloaded in background as it is about to expire, or stale.
+	Refreshed
+)
+
+func (s Source) String() string {
+	switch s {
+	case Hit:
+		return "Hit"
+	case Loaded:
+		return "Loaded"
+	case Refreshed:
+		return "Refreshed"
+	default:
+		return "Unknown"
+	}
+}
+
+func (c *lruCache[K, V]) DoWithSource(key K, fn func(V, Source) error) error {
+	return c.do(context.Background(), key, fn)
+}
+
+func (c *shardedCache[K, V]) DoWithSource(key K, fn func(V, Source) error) error {
+	return c.shard(key).DoWithSource(key, fn)
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Stats is a point-in-time copy of cache statistics.
+type Stats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	LoadSuccesses int64
+	LoadFailures  int64
+	// HardLimitHits counts the inserts finding no room, which evict entries inline.
+	HardLimitHits int64
+	// DroppedEvictions counts the evictions not published as the channel of WithEvictionChannel is full.
+	DroppedEvictions int64
+}
+
+// merge adds up the stats of shards.
+func (s Stats) merge(other Stats) Stats {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Evictions += other.Evictions
+	s.LoadSuccesses += other.LoadSuccesses
+	s.LoadFailures += other.LoadFailures
+	s.HardLimitHits += other.HardLimitHits
+	s.DroppedEvictions += other.DroppedEvictions
+	return s
+}
+
+// LoadLatencyBuckets are the upper bounds of the buckets of LoadLatency.
+var LoadLatencyBuckets = [...]time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// LoadLatency is a point-in-time copy of the histogram of loader call durations.
+type LoadLatency struct {
+	Count int64
+	Sum   time.Duration
+	// Buckets[i] counts the loads took longer than LoadLatencyBuckets[i-1] and up to LoadLatencyBuckets[i],
+	// the last one counts the loads longer than all bounds.
+	Buckets [len(LoadLatencyBuckets) + 1]int64
+}
+
+// Mean returns the average duration of loads.
+func (l LoadLatency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
+}
+
+// Quantile returns the upper bound of the bucket where the q-quantile of loads falls, e.g. 0.99 for p99.
+// It returns -1 if the quantile is beyond all bounds, or 0 if there is no load.
+func (l LoadLatency) Quantile(q float64) time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(l.Count))
+	if rank >= l.Count {
+		rank = l.Count - 1
+	}
+	var seen int64
+	for i, n := range l.Buckets[:len(LoadLatencyBuckets)] {
+		seen += n
+		if seen > rank {
+			return LoadLatencyBuckets[i]
+		}
+	}
+	return -1
+}
+
+// latencyCounter records a LoadLatency, all counters are updated atomically.
+type latencyCounter struct {
+	count   atomic.Int64
+	sum     atomic.Int64
+	buckets [len(LoadLatencyBuckets) + 1]atomic.Int64
+}
+
+func (l *latencyCounter) observe(d time.Duration) {
+	i := 0
+	for i < len(LoadLatencyBuckets) && d > LoadLatencyBuckets[i] {
+		i++
+	}
+	l.buckets[i].Inc()
+	l.sum.Add(int64(d))
+	l.count.Inc()
+}
+
+func (l *latencyCounter) snapshot() LoadLatency {
+	latency := LoadLatency{
+		Count: l.count.Load(),
+		Sum:   time.Duration(l.sum.Load()),
+	}
+	for i := range l.buckets {
+		latency.Buckets[i] = l.buckets[i].Load()
+	}
+	return latency
+}
+
+func (l *latencyCounter) reset() {
+	l.count.Store(0)
+	l.sum.Store(0)
+	for i := range l.buckets {
+		l.buckets[i].Store(0)
+	}
+}
+
+// merge adds up the latencies of shards.
+func (l LoadLatency) merge(other LoadLatency) LoadLatency {
+	l.Count += other.Count
+	l.Sum += other.Sum
+	for i := range l.Buckets {
+		l.Buckets[i] += other.Buckets[i]
+	}
+	return l
+}
+
+// statsCounter records cache statistics, all counters are updated atomically.
+type statsCounter struct {
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	loadSuccesses atomic.Int64
+	loadFailures  atomic.Int64
+	hardLimitHits atomic.Int64
+	dropped       atomic.Int64
+	loadLatency   latencyCounter
+	// removals counts the entries finalized by cause
+	removals [Abandoned + 1]atomic.Int64
+}
+
+func (s *statsCounter) snapshot() Stats {
+	return Stats{
+		Hits:          s.hits.Load(),
+		Misses:        s.misses.Load(),
+		Evictions:     s.evictions.Load(),
+		LoadSuccesses: s.loadSuccesses.Load(),
+		LoadFailures:  s.loadFailures.Load(),
+		HardLimitHits: s.hardLimitHits.Load(),
+
+		DroppedEvictions: s.dropped.Load(),
+	}
+}
+
+func (s *statsCounter) reset() {
+	s.hits.Store(0)
+	s.misses.Store(0)
+	s.evictions.Store(0)
+	s.loadSuccesses.Store(0)
+	s.loadFailures.Store(0)
+	s.hardLimitHits.Store(0)
+	s.dropped.Store(0)
+	s.loadLatency.reset()
+	for i := range s.removals {
+		s.removals[i].Store(0)
+	}
+}
+
+// StatsSnapshot is a point-in-time copy of the statistics and the occupation of a cache, to be marshaled as JSON.
+type StatsSnapshot struct {
+	TakenAt       time.Time `json:"taken_at"`
+	Hits          int64     `json:"hits"`
+	Misses        int64     `json:"misses"`
+	Evictions     int64     `json:"evictions"`
+	LoadSuccesses int64     `json:"load_successes"`
+	LoadFailures  int64     `json:"load_failures"`
+	HardLimitHits int64     `json:"hard_limit_hits"`
+	// DroppedEvictions counts the evictions not published to the channel of WithEvictionChannel.
+	DroppedEvictions int64 `json:"dropped_evictions"`
+	// Removals counts the entries finalized by the names of their removal causes.
+	Removals    map[string]int64    `json:"removals"`
+	LoadLatency LoadLatencySnapshot `json:"load_latency"`
+	// Size is the number of entries, Usage and Capacity are measured by the scavenger.
+	Size     int   `json:"size"`
+	Usage    int64 `json:"usage"`
+	Capacity int64 `json:"capacity"`
+}
+
+// LoadLatencySnapshot summarizes LoadLatency by percentiles, which are the upper bounds of the buckets as Quantile.
+type LoadLatencySnapshot struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+}
+
+func newStatsSnapshot(stats Stats, removals map[RemovalCause]int64, latency LoadLatency) StatsSnapshot {
+	s := StatsSnapshot{
+		Hits:          stats.Hits,
+		Misses:        stats.Misses,
+		Evictions:     stats.Evictions,
+		LoadSuccesses: stats.LoadSuccesses,
+		LoadFailures:  stats.LoadFailures,
+		HardLimitHits: stats.HardLimitHits,
+		Removals:      make(map[string]int64, len(removals)),
+
+		DroppedEvictions: stats.DroppedEvictions,
+		LoadLatency: LoadLatencySnapshot{
+			Count: latency.Count,
+			Mean:  latency.Mean(),
+			P50:   latency.Quantile(0.5),
+			P90:   latency.Quantile(0.9),
+			P99:   latency.Quantile(0.99),
+		},
+	}
+	for cause, n := range removals {
+		s.Removals[cause.String()] = n
+	}
+	return s
+}
+
+// addRemovals adds up the removals of s by cause into removals.
+func (s *statsCounter) addRemovals(removals map[RemovalCause]int64) {
+	for cause := Evicted; cause <= Abandoned; cause++ {
+		removals[cause] += s.removals[cause].Load()
+	}
+}
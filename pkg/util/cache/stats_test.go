@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("test counters", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, key >= 0
+		}).WithCapacity(2).Build()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		assert.NoError(t, cache.Do(2, func(v int) error { return nil }))
+		assert.Equal(t, ErrNoSuchItem, cache.Do(-1, func(v int) error { return nil }))
+
+		assert.Equal(t, Stats{
+			Hits:          1,
+			Misses:        4,
+			Evictions:     1,
+			LoadSuccesses: 3,
+			LoadFailures:  1,
+			HardLimitHits: 1,
+		}, cache.Stats())
+
+		cache.ResetStats()
+		assert.Equal(t, Stats{}, cache.Stats())
+	})
+
+	t.Run("test load latency", func(t *testing.T) {
+		clock := newFakeClock()
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			clock.Advance(time.Duration(key) * time.Millisecond)
+			return key, true
+		}).WithClock(clock).Build()
+
+		for _, key := range []int{1, 3, 3, 4, 30, 20000} {
+			assert.NoError(t, cache.Do(key, func(v int) error {
+				// the doer is not timed
+				clock.Advance(time.Second)
+				return nil
+			}))
+		}
+		latency := cache.LoadLatency()
+		assert.EqualValues(t, 5, latency.Count)
+		assert.Equal(t, 20038*time.Millisecond, latency.Sum)
+		assert.Equal(t, int64(1), latency.Buckets[0])
+		assert.Equal(t, int64(2), latency.Buckets[2])
+		assert.Equal(t, int64(1), latency.Buckets[5])
+		assert.Equal(t, int64(1), latency.Buckets[len(LoadLatencyBuckets)])
+		assert.Equal(t, 5*time.Millisecond, latency.Quantile(0.5))
+		assert.Equal(t, 50*time.Millisecond, latency.Quantile(0.7))
+		assert.Equal(t, time.Duration(-1), latency.Quantile(0.99))
+		assert.Equal(t, latency.Sum/5, latency.Mean())
+
+		cache.ResetStats()
+		assert.Equal(t, LoadLatency{}, cache.LoadLatency())
+		assert.Equal(t, time.Duration(0), cache.LoadLatency().Quantile(0.5))
+	})
+
+	t.Run("test sharded load latency", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithShards(4).Build()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+		}
+		latency := cache.LoadLatency()
+		assert.EqualValues(t, 10, latency.Count)
+		assert.EqualValues(t, 10, latency.Buckets[0])
+	})
+
+	t.Run("test concurrency", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		}).WithCapacity(10).Build()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					assert.NoError(t, cache.Do(j, func(v int) error { return nil }))
+				}
+			}()
+		}
+		wg.Wait()
+
+		stats := cache.Stats()
+		assert.EqualValues(t, 1000, stats.Hits+stats.Misses)
+		assert.Equal(t, stats.LoadSuccesses-10, stats.Evictions)
+		assert.Zero(t, stats.LoadFailures)
+	})
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	for name, builder := range map[string]*CacheBuilder[int, int]{
+		"lru":     NewCacheBuilder[int, int](),
+		"sharded": NewCacheBuilder[int, int]().WithShards(2),
+	} {
+		t.Run("test "+name, func(t *testing.T) {
+			cache := builder.WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithCapacity(4).Build()
+			for i := 0; i < 6; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			// the eviction is per shard, there are at most 4 entries
+			size := cache.Len()
+			assert.NoError(t, cache.Put(5, 50))
+			cache.Remove(5)
+
+			s := cache.StatsSnapshot()
+			assert.EqualValues(t, 6, s.Misses)
+			assert.EqualValues(t, 6, s.LoadSuccesses)
+			assert.EqualValues(t, 6-size, s.Removals["Evicted"])
+			assert.EqualValues(t, 1, s.Removals["Replaced"])
+			assert.EqualValues(t, 1, s.Removals["Explicit"])
+			assert.EqualValues(t, 6, s.LoadLatency.Count)
+			assert.Equal(t, size-1, s.Size)
+			assert.EqualValues(t, size-1, s.Usage)
+			assert.EqualValues(t, 4, s.Capacity)
+
+			data, err := json.Marshal(s)
+			assert.NoError(t, err)
+			var decoded map[string]any
+			assert.NoError(t, json.Unmarshal(data, &decoded))
+			assert.EqualValues(t, 6, decoded["misses"])
+			assert.Contains(t, decoded["removals"], "Evicted")
+			assert.Contains(t, decoded["load_latency"], "p99_ns")
+		})
+	}
+}
+
+func TestHitRatio(t *testing.T) {
+	for name, builder := range map[string]*CacheBuilder[int, int]{
+		"lru":     NewCacheBuilder[int, int](),
+		"sharded": NewCacheBuilder[int, int]().WithShards(2),
+	} {
+		t.Run("test "+name, func(t *testing.T) {
+			clock := newFakeClock()
+			cache := builder.WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithCapacity(100).WithHitRatioWindow(time.Minute).WithClock(clock).Build()
+			assert.Zero(t, cache.HitRatio())
+
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			for i := 0; i < 90; i++ {
+				assert.NoError(t, cache.Do(i%10, func(v int) error { return nil }))
+			}
+			assert.InDelta(t, 0.9, cache.HitRatio(), 1e-9)
+
+			// the old lookups weigh 1/1024 after 10 half-lives, the recent misses dominate
+			clock.Advance(10 * time.Minute)
+			for i := 10; i < 60; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			assert.InDelta(t, 0.0017, cache.HitRatio(), 1e-4)
+			// while the all-time ratio is still 0.6
+			assert.EqualValues(t, 90, cache.Stats().Hits)
+			assert.EqualValues(t, 60, cache.Stats().Misses)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package cache
+
+// tagIndex maps tags to the keys of the entries carrying them.
+//
+//	A nil tagIndex indexes nothing. It is not thread safe, the owner cache holds the lock.
+type tagIndex[K comparable] map[string]map[K]struct{}
+
+func (x tagIndex[K]) add(key K, tags []string) {
+	if x == nil {
+		return
+	}
+	for _, tag := range tags {
+		keys, ok := x[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			x[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (x tagIndex[K]) remove(key K, tags []string) {
+	if x == nil {
+		return
+	}
+	for _, tag := range tags {
+		if keys, ok := x[tag]; ok {
+			delete(keys, key)
+			// Drop the empty tag, so that tags of entries gone do not pile up.
+			if len(keys) == 0 {
+				delete(x, tag)
+			}
+		}
+	}
+}
+
+func (c *lruCache[K, V]) InvalidateTag(tag string) int {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	keys := c.tags[tag]
+	items := make([]*cacheItem[K, V], 0, len(keys))
+	for key := range keys {
+		items = append(items, c.items[key])
+	}
+	for _, item := range items {
+		c.removeItem(item, Explicit)
+	}
+	return len(items)
+}
+
+func (c *shardedCache[K, V]) InvalidateTag(tag string) int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.InvalidateTag(tag)
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		t.Run(fmt.Sprintf("test %d shards", shards), func(t *testing.T) {
+			finalized := 0
+			cache := NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+				return key, true
+			}).WithFinalizer(func(key, value int) error {
+				finalized++
+				return nil
+			}).WithTagger(func(key, value int) []string {
+				return []string{fmt.Sprintf("collection-%d", value%3), "all"}
+			}).WithCapacity(100).WithShards(shards).Build()
+
+			for i := 0; i < 30; i++ {
+				assert.NoError(t, cache.Do(i, func(v int) error { return nil }))
+			}
+			assert.Equal(t, 10, cache.InvalidateTag("collection-0"))
+			assert.Equal(t, 10, finalized)
+			assert.Equal(t, 0, cache.InvalidateTag("collection-0"))
+			assert.Equal(t, 0, cache.InvalidateTag("unknown"))
+
+			// a replaced entry is tagged by its new value
+			assert.NoError(t, cache.Put(1, 3))
+			assert.Equal(t, 1, cache.InvalidateTag("collection-0"))
+			assert.Equal(t, 9, cache.InvalidateTag("collection-1"))
+			assert.Equal(t, 10, cache.InvalidateTag("all"))
+			assert.Equal(t, 0, cache.Len())
+		})
+	}
+
+	t.Run("test index", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithTagger(func(key, value int) []string {
+			return []string{fmt.Sprint(value)}
+		}).WithCapacity(2).Build().(*lruCache[int, int])
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		// evicted entries leave the index
+		assert.Len(t, cache.tags, 2)
+		assert.NoError(t, cache.Clear())
+		assert.Empty(t, cache.tags)
+	})
+}
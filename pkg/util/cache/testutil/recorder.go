@@ -0,0 +1,204 @@
+// Package testutil helps tests of util/cache, and of its users, to observe the entries finalized.
+//
+//	It is for external test packages, the tests within package cache could not import it.
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+// DefaultWait is how long the assertions of Recorder wait for the records expected.
+const DefaultWait = 5 * time.Second
+
+// Record is an entry finalized, Cause is zero if it is recorded by the finalizer alone.
+type Record[K comparable, V any] struct {
+	Key   K
+	Value V
+	Cause cache.RemovalCause
+	At    time.Time
+}
+
+// Recorder records the entries finalized by a cache in the order they are.
+//
+//	Attach it to a cache builder, or use Finalizer and Listener separately. It is safe for concurrent use,
+//	so the finalizer may run on the workers of WithAsyncFinalizer, or on many shards.
+type Recorder[K comparable, V any] struct {
+	clock cache.Clock
+
+	mu      sync.Mutex
+	records []Record[K, V]
+	// updated is closed and renewed on each record, to wake up the waiters
+	updated chan struct{}
+	// errs are returned by the finalizer of keys
+	errs map[K]error
+	// attached tells whether the records are complete only once the listener fills in the cause
+	attached bool
+}
+
+// NewRecorder creates a recorder taking the time of records from clock, it is the wall clock if nil.
+func NewRecorder[K comparable, V any](clock cache.Clock) *Recorder[K, V] {
+	if clock == nil {
+		clock = wallClock{}
+	}
+	return &Recorder[K, V]{
+		clock:   clock,
+		updated: make(chan struct{}),
+		errs:    make(map[K]error),
+	}
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// Attach sets the finalizer and the eviction listener of b to record with, replacing the ones set before.
+//
+//	Each entry is recorded once by the finalizer, the listener called right after fills in the cause.
+func (r *Recorder[K, V]) Attach(b *cache.CacheBuilder[K, V]) *cache.CacheBuilder[K, V] {
+	r.mu.Lock()
+	r.attached = true
+	r.mu.Unlock()
+	return b.WithFinalizer(r.Finalizer()).WithEvictionListener(r.Listener())
+}
+
+// Finalizer records each entry finalized, it fails with the error set by FailOn for the key.
+func (r *Recorder[K, V]) Finalizer() cache.Finalizer[K, V] {
+	return func(key K, value V) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.append(Record[K, V]{Key: key, Value: value, At: r.clock.Now()})
+		return r.errs[key]
+	}
+}
+
+// Listener records each entry leaving the cache along with the cause,
+// or fills in the cause of the record by Finalizer of the entry if Attach is used.
+func (r *Recorder[K, V]) Listener() cache.EvictionListener[K, V] {
+	return func(key K, value V, cause cache.RemovalCause) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for i := len(r.records) - 1; i >= 0; i-- {
+			if record := &r.records[i]; record.Key == key && record.Cause == 0 {
+				record.Cause = cause
+				r.notify()
+				return
+			}
+		}
+		r.append(Record[K, V]{Key: key, Value: value, Cause: cause, At: r.clock.Now()})
+	}
+}
+
+// append adds a record, the caller must hold the lock.
+func (r *Recorder[K, V]) append(record Record[K, V]) {
+	r.records = append(r.records, record)
+	r.notify()
+}
+
+// notify wakes up the waiters, the caller must hold the lock.
+func (r *Recorder[K, V]) notify() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// FailOn makes the finalizer of key fail with err, a nil err lets it succeed again.
+func (r *Recorder[K, V]) FailOn(key K, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		delete(r.errs, key)
+		return
+	}
+	r.errs[key] = err
+}
+
+// Records returns a copy of the records so far.
+func (r *Recorder[K, V]) Records() []Record[K, V] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record[K, V](nil), r.records...)
+}
+
+// Keys returns the keys of the records so far in order.
+func (r *Recorder[K, V]) Keys() []K {
+	records := r.Records()
+	keys := make([]K, len(records))
+	for i, record := range records {
+		keys[i] = record.Key
+	}
+	return keys
+}
+
+// Causes returns the cause of each key recorded, the latest one if a key is recorded many times.
+func (r *Recorder[K, V]) Causes() map[K]cache.RemovalCause {
+	causes := make(map[K]cache.RemovalCause)
+	for _, record := range r.Records() {
+		causes[record.Key] = record.Cause
+	}
+	return causes
+}
+
+// Reset drops the records so far.
+func (r *Recorder[K, V]) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// Wait waits until there are at least n records with their causes filled in if the listener is attached,
+// it returns false if timeout elapses first.
+func (r *Recorder[K, V]) Wait(n int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		complete := 0
+		for _, record := range r.records {
+			if !r.attached || record.Cause != 0 {
+				complete++
+			}
+		}
+		ready := complete >= n
+		updated := r.updated
+		r.mu.Unlock()
+		if ready {
+			return true
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// AssertSequence asserts the keys are recorded exactly in order, waiting up to DefaultWait for them.
+//
+//	Use it if the entries are finalized in a deterministic order, e.g. by a single shard finalizing synchronously.
+func (r *Recorder[K, V]) AssertSequence(t assert.TestingT, keys ...K) bool {
+	r.Wait(len(keys), DefaultWait)
+	if len(keys) == 0 {
+		return assert.Empty(t, r.Keys())
+	}
+	return assert.Equal(t, keys, r.Keys())
+}
+
+// AssertSet asserts the keys are recorded in any order, waiting up to DefaultWait for them.
+//
+//	Use it if the order is not deterministic, e.g. with WithAsyncFinalizer or WithShards.
+func (r *Recorder[K, V]) AssertSet(t assert.TestingT, keys ...K) bool {
+	r.Wait(len(keys), DefaultWait)
+	return assert.ElementsMatch(t, keys, r.Keys())
+}
+
+// AssertCauses asserts the cause of each key recorded, waiting up to DefaultWait for them.
+func (r *Recorder[K, V]) AssertCauses(t assert.TestingT, causes map[K]cache.RemovalCause) bool {
+	r.Wait(len(causes), DefaultWait)
+	return assert.Equal(t, causes, r.Causes())
+}
@@ -0,0 +1,81 @@
+package testutil
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/milvus-io/milvus/pkg/util/cache"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("test sequence", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		recorder := NewRecorder[int, int](clock)
+		c := recorder.Attach(cache.NewCacheBuilder[int, int]().WithLoader(func(key int) (int, bool) {
+			return key, true
+		})).WithCapacity(2).Build()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, c.Do(i, func(v int) error { return nil }))
+			clock.Advance(time.Second)
+		}
+		assert.NoError(t, c.Put(2, 20))
+		assert.True(t, c.Remove(1))
+		recorder.AssertSequence(t, 0, 2, 1)
+		recorder.AssertCauses(t, map[int]cache.RemovalCause{0: cache.Evicted, 2: cache.Replaced, 1: cache.Explicit})
+		records := recorder.Records()
+		assert.Equal(t, 2, records[1].Value)
+		assert.Equal(t, time.Unix(3, 0), records[1].At)
+
+		recorder.Reset()
+		recorder.AssertSequence(t)
+	})
+
+	t.Run("test async", func(t *testing.T) {
+		recorder := NewRecorder[int, int](nil)
+		failure := errors.New("mocked")
+		recorder.FailOn(3, failure)
+		failed := make(chan int, 1)
+		c := recorder.Attach(cache.NewCacheBuilder[int, int]()).WithFinalizerErrorHandler(func(key, value int, err error) {
+			assert.ErrorIs(t, err, failure)
+			failed <- key
+		}).WithAsyncFinalizer(4).WithShards(4).WithCapacity(100).Build()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, c.Put(i, i))
+		}
+		c.Clear()
+		recorder.AssertSet(t, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+		assert.Equal(t, 3, <-failed)
+		assert.False(t, recorder.Wait(11, 10*time.Millisecond))
+		assert.NoError(t, c.Close())
+	})
+
+	t.Run("test listener alone", func(t *testing.T) {
+		recorder := NewRecorder[int, int](nil)
+		c := cache.NewCacheBuilder[int, int]().WithEvictionListener(recorder.Listener()).Build()
+		assert.NoError(t, c.Put(1, 1))
+		assert.True(t, c.Remove(1))
+		recorder.AssertCauses(t, map[int]cache.RemovalCause{1: cache.Explicit})
+	})
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"strconv"
+)
+
+// Tracer starts the spans of cache operations, package cachetracing adapts an OpenTelemetry tracer to it.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, and returns the context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span, value is a bool, an int64 or a string.
+	SetAttribute(key string, value any)
+	// End ends the span, err is the error of the operation traced, or nil.
+	End(err error)
+}
+
+const (
+	spanDo   = "cache.Do"
+	spanLoad = "cache.load"
+
+	attrHit = "cache.hit"
+	attrKey = "cache.key"
+)
+
+// startSpan starts a span if there is a tracer, the span returned is nil otherwise.
+func (c *lruCache[K, V]) startSpan(ctx context.Context, name string, key K) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := c.tracer.Start(ctx, name)
+	span.SetAttribute(attrKey, keyFingerprint(c.seed, key))
+	return ctx, span
+}
+
+// keyFingerprint identifies a key in traces without exposing it.
+//
+//	Every key is hashed with seed by its bytes, so that the fingerprints of integer keys could not be reversed
+//	and do not tell anything across caches, unlike hashKey which mixes integers without the seed.
+func keyFingerprint[K comparable](seed maphash.Seed, key K) string {
+	var h maphash.Hash
+	h.SetSeed(seed)
+	var buf [8]byte
+	switch k := any(key).(type) {
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case int32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		h.Write(buf[:])
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], k)
+		h.Write(buf[:])
+	case string:
+		h.WriteString(k)
+	default:
+		h.WriteString(fmt.Sprint(key))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
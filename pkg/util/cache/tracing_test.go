@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"hash/maphash"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFingerprint(t *testing.T) {
+	seed, other := maphash.MakeSeed(), maphash.MakeSeed()
+
+	assert.Equal(t, keyFingerprint(seed, 1), keyFingerprint(seed, 1))
+	assert.NotEqual(t, keyFingerprint(seed, 1), keyFingerprint(seed, 2))
+	assert.Equal(t, keyFingerprint(seed, "a"), keyFingerprint(seed, "a"))
+
+	// integer keys are seeded as well, and not the mixed key
+	assert.NotEqual(t, keyFingerprint(seed, 1), keyFingerprint(other, 1))
+	assert.NotEqual(t, keyFingerprint(seed, int64(1)), keyFingerprint(other, int64(1)))
+	assert.NotEqual(t, strconv.FormatUint(mix(1), 16), keyFingerprint(seed, 1))
+	assert.NotEqual(t, keyFingerprint(seed, "a"), keyFingerprint(other, "a"))
+}
@@ -0,0 +1,45 @@
+package cache
+
+import "github.com/cockroachdb/errors"
+
+// Transient marks err returned by a loader as a transient failure, which is worth retrying, such as a timeout.
+//
+//	A loader classifies its errors by returning ones implementing Temporary() bool, as net.Error does,
+//	or by wrapping them by Transient, the outermost classification in the chain wins. A permanent error,
+//	reporting Temporary() false, propagates immediately: it is never retried, never trips the circuit breaker
+//	as the backend has answered, never served stale by WithStaleIfError and never remembered by WithErrorTTL.
+//	Unclassified errors are handled as transient ones.
+//	Under single flight the error of the last loader call is classified once, and all the waiters of the load get it.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func (e *transientError) Temporary() bool {
+	return true
+}
+
+// temporary is implemented by the errors classifying themselves.
+type temporary interface {
+	Temporary() bool
+}
+
+// isPermanent tells whether err is classified as a permanent failure, unclassified errors are not.
+func isPermanent(err error) bool {
+	var t temporary
+	return errors.As(err, &t) && !t.Temporary()
+}
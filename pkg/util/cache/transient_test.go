@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type classifiedError struct {
+	temporary bool
+}
+
+func (e classifiedError) Error() string   { return "classified" }
+func (e classifiedError) Temporary() bool { return e.temporary }
+
+func TestTransient(t *testing.T) {
+	permanent := classifiedError{temporary: false}
+
+	t.Run("test classify", func(t *testing.T) {
+		assert.Nil(t, Transient(nil))
+		assert.False(t, isPermanent(errors.New("unclassified")))
+		assert.True(t, isPermanent(permanent))
+		assert.True(t, isPermanent(errors.Wrap(permanent, "load")))
+		assert.False(t, isPermanent(classifiedError{temporary: true}))
+		// the outermost classification wins
+		err := Transient(permanent)
+		assert.False(t, isPermanent(err))
+		assert.ErrorIs(t, err, permanent)
+	})
+
+	t.Run("test permanent propagates", func(t *testing.T) {
+		loads := 0
+		var loadErr error
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			loads++
+			return 0, loadErr
+		}).WithLoadRetry(2, 0).WithLoaderCircuitBreaker(1, time.Hour).WithErrorTTL(time.Hour).Build()
+
+		loadErr = permanent
+		for i := 0; i < 2; i++ {
+			// neither retried, remembered nor tripping the breaker
+			assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), permanent)
+		}
+		assert.Equal(t, 2, loads)
+
+		// a transient error trips the breaker, which stops the retries
+		loadErr = Transient(errors.New("timeout"))
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), ErrLoaderUnavailable)
+		assert.ErrorIs(t, cache.Do(2, func(v int) error { return nil }), ErrLoaderUnavailable)
+		assert.Equal(t, 3, loads)
+	})
+
+	t.Run("test permanent not served stale", func(t *testing.T) {
+		clock := newFakeClock()
+		var loadErr error
+		cache := NewCacheBuilder[int, int]().WithErrorLoader(func(key int) (int, error) {
+			return key, loadErr
+		}).WithTTL(time.Second).WithStaleIfError(time.Hour).WithClock(clock).Build()
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+
+		clock.Advance(2 * time.Second)
+		loadErr = errors.New("unclassified")
+		assert.NoError(t, cache.Do(1, func(v int) error { return nil }))
+		loadErr = permanent
+		assert.ErrorIs(t, cache.Do(1, func(v int) error { return nil }), permanent)
+	})
+}
@@ -0,0 +1,56 @@
+package cache
+
+// trimEvery evicts entries down to the soft watermark in background each time it is signaled, until the cache is closed.
+func (c *lruCache[K, V]) trimEvery() {
+	for {
+		select {
+		case <-c.trim:
+			c.trimToSoft()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// signalTrim wakes up the trimmer if usage is above the soft watermark, the caller must hold the lock.
+func (c *lruCache[K, V]) signalTrim() {
+	if c.softLimit <= 0 || c.scavenger.size <= c.softLimit {
+		return
+	}
+	select {
+	case c.trim <- struct{}{}:
+	default:
+	}
+}
+
+// trimToSoft evicts entries in eviction order until usage is at most the soft watermark,
+// at most reapBatch entries are evicted within a lock.
+func (c *lruCache[K, V]) trimToSoft() {
+	for {
+		c.rwlock.Lock()
+		c.drainReads()
+		over := c.scavenger.size - c.softLimit
+		toEvict := make([]*cacheItem[K, V], 0)
+		c.evictor.victims(func(n *policyNode) bool {
+			if over <= 0 || len(toEvict) >= reapBatch {
+				return false
+			}
+			item := n.owner.(*cacheItem[K, V])
+			if !c.evictable(item) {
+				return true
+			}
+			toEvict = append(toEvict, item)
+			over -= item.cost
+			return true
+		})
+		for _, item := range toEvict {
+			c.removeItem(item, c.removalCause(item, Evicted))
+			c.stats.evictions.Inc()
+		}
+		c.rwlock.Unlock()
+		if over <= 0 || len(toEvict) < reapBatch {
+			// Done, or the rest are pinned.
+			return
+		}
+	}
+}
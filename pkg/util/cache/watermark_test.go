@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermarks(t *testing.T) {
+	t.Run("test trim to soft", func(t *testing.T) {
+		finalizeSeq := make(chan int, 10)
+		cache := NewCacheBuilder[int, int]().WithFinalizer(func(key, value int) error {
+			finalizeSeq <- key
+			return nil
+		}).WithWatermarks(3, 5).Build()
+
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		// evicted in background in lru order
+		assert.Equal(t, 0, <-finalizeSeq)
+		assert.Eventually(t, func() bool { return cache.Usage() == 3 }, time.Second, time.Millisecond)
+		assert.EqualValues(t, 1, cache.Stats().Evictions)
+
+		// pinned entries are kept
+		cache.Pin(1)
+		cache.Pin(2)
+		cache.Pin(3)
+		assert.NoError(t, cache.Put(4, 4))
+		assert.NoError(t, cache.Put(5, 5))
+		assert.Equal(t, 4, <-finalizeSeq)
+		assert.Equal(t, 5, <-finalizeSeq)
+		assert.Eventually(t, func() bool { return cache.Usage() == 3 }, time.Second, time.Millisecond)
+		assert.EqualValues(t, 0, cache.Stats().HardLimitHits)
+
+		// the hard limit evicts inline
+		cache.Unpin(1)
+		assert.NoError(t, cache.Put(6, 6))
+		assert.NoError(t, cache.Put(7, 7))
+		assert.NoError(t, cache.Put(8, 8))
+		assert.LessOrEqual(t, cache.Usage(), int64(5))
+		assert.NoError(t, cache.Close())
+	})
+
+	t.Run("test hard limit hits", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithCapacity(2).Build()
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		assert.EqualValues(t, 2, cache.Stats().HardLimitHits)
+	})
+
+	t.Run("test sharded", func(t *testing.T) {
+		cache := NewCacheBuilder[int, int]().WithWatermarks(40, 100).WithShards(4).Build()
+		for _, shard := range cache.(*shardedCache[int, int]).shards {
+			assert.EqualValues(t, 10, shard.softLimit)
+		}
+		for i := 0; i < 80; i++ {
+			assert.NoError(t, cache.Put(i, i))
+		}
+		assert.Eventually(t, func() bool { return cache.Usage() <= 40 }, time.Second, time.Millisecond)
+	})
+}
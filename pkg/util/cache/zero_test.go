@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZeroValue(t *testing.T) {
+	// even keys are loaded as nil, odd keys are not found
+	loader := func(key int) (*int, bool) {
+		return nil, key%2 == 0
+	}
+	builders := map[string]func() *CacheBuilder[int, *int]{
+		"loader": func() *CacheBuilder[int, *int] {
+			return NewCacheBuilder[int, *int]().WithLoader(loader)
+		},
+		"batch loader": func() *CacheBuilder[int, *int] {
+			return NewCacheBuilder[int, *int]().WithBatchLoader(func(keys []int) (map[int]*int, error) {
+				values := make(map[int]*int)
+				for _, key := range keys {
+					if _, ok := loader(key); ok {
+						values[key] = nil
+					}
+				}
+				return values, nil
+			})
+		},
+		"sharded": func() *CacheBuilder[int, *int] {
+			return NewCacheBuilder[int, *int]().WithLoader(loader).WithShards(4)
+		},
+		"read mostly": func() *CacheBuilder[int, *int] {
+			return NewCacheBuilder[int, *int]().WithLoader(loader).WithReadMostly()
+		},
+		"second level": func() *CacheBuilder[int, *int] {
+			return NewCacheBuilder[int, *int]().WithSecondLevel(NewCacheBuilder[int, *int]().WithLoader(loader).Build())
+		},
+	}
+	for name, builder := range builders {
+		t.Run("test "+name, func(t *testing.T) {
+			cache := builder().WithCapacity(10).Build()
+			for i := 0; i < 2; i++ {
+				assert.NoError(t, cache.Do(0, func(v *int) error {
+					assert.Nil(t, v)
+					return nil
+				}))
+				assert.Equal(t, ErrNoSuchItem, cache.Do(1, func(v *int) error { return nil }))
+			}
+			assert.EqualValues(t, 1, cache.Stats().Hits)
+			assert.EqualValues(t, 1, cache.Stats().LoadSuccesses)
+
+			v, ok := cache.GetIfPresent(0)
+			assert.True(t, ok)
+			assert.Nil(t, v)
+			assert.True(t, cache.Contains(0))
+			assert.Nil(t, cache.GetOrDefault(0, new(int)))
+			assert.NotNil(t, cache.GetOrDefault(1, new(int)))
+			assert.Equal(t, map[int]*int{0: nil}, cache.GetAllPresent([]int{0, 1}))
+			assert.EqualValues(t, 4, cache.Stats().Hits)
+
+			err := cache.DoMany([]int{0, 2, 3}, func(values map[int]*int) error {
+				assert.Equal(t, map[int]*int{0: nil, 2: nil}, values)
+				return nil
+			})
+			assert.Equal(t, KeyErrors[int]{3: ErrNoSuchItem}, err)
+			assert.EqualValues(t, 5, cache.Stats().Hits)
+			assert.ElementsMatch(t, []int{0, 2}, cache.SnapshotKeys())
+		})
+	}
+}